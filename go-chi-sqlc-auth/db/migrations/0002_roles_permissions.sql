@@ -0,0 +1,116 @@
+-- Roles and permissions replace the fixed user_role enum.
+CREATE TABLE roles (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid (),
+    name TEXT NOT NULL UNIQUE,
+    description TEXT NOT NULL DEFAULT '',
+    built_in BOOLEAN NOT NULL DEFAULT false,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE permissions (
+    name TEXT PRIMARY KEY,
+    description TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE role_permissions (
+    role_id UUID NOT NULL REFERENCES roles (id) ON DELETE CASCADE,
+    permission TEXT NOT NULL REFERENCES permissions (name) ON DELETE CASCADE,
+    PRIMARY KEY (role_id, permission)
+);
+
+CREATE TABLE user_roles (
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    role_id UUID NOT NULL REFERENCES roles (id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, role_id)
+);
+
+CREATE INDEX idx_user_roles_role_id ON user_roles (role_id);
+
+INSERT INTO
+    permissions (name, description)
+VALUES (
+        'users:read',
+        'List users and view any user'
+    ),
+    (
+        'users:update',
+        'Edit any user profile'
+    ),
+    (
+        'users:delete',
+        'Delete users'
+    ),
+    (
+        'users:role:assign',
+        'Assign roles to users'
+    ),
+    (
+        'users:password:set',
+        'Set another user''s password'
+    ),
+    (
+        'roles:read',
+        'View roles and permissions'
+    ),
+    (
+        'roles:manage',
+        'Create, edit and delete roles'
+    );
+
+INSERT INTO
+    roles (name, description, built_in)
+VALUES (
+        'admin',
+        'Full access',
+        true
+    ),
+    (
+        'user',
+        'Regular account',
+        true
+    );
+
+INSERT INTO
+    role_permissions (role_id, permission)
+SELECT r.id, p.name
+FROM roles r, permissions p
+WHERE
+    r.name = 'admin';
+
+INSERT INTO
+    user_roles (user_id, role_id)
+SELECT u.id, r.id
+FROM users u
+    JOIN roles r ON r.name = u.role::text;
+
+ALTER TABLE users DROP COLUMN role;
+
+DROP TYPE user_role;
+
+-- +goose Down
+CREATE TYPE user_role AS ENUM ('admin', 'user');
+
+ALTER TABLE users ADD COLUMN role user_role NOT NULL DEFAULT 'user';
+
+UPDATE users u
+SET
+    role = 'admin'
+WHERE
+    EXISTS (
+        SELECT 1
+        FROM user_roles ur
+            JOIN roles r ON r.id = ur.role_id
+        WHERE
+            ur.user_id = u.id
+            AND r.name = 'admin'
+    );
+
+DROP TABLE IF EXISTS user_roles;
+
+DROP TABLE IF EXISTS role_permissions;
+
+DROP TABLE IF EXISTS permissions;
+
+DROP TABLE IF EXISTS roles;
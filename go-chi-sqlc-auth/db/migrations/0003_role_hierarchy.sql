@@ -0,0 +1,42 @@
+-- Role levels: a role can only grant roles below its own level.
+ALTER TABLE roles ADD COLUMN level INT NOT NULL DEFAULT 0;
+
+UPDATE roles SET level = 100 WHERE name = 'admin';
+
+INSERT INTO
+    roles (
+        name,
+        description,
+        built_in,
+        level
+    )
+VALUES (
+        'support',
+        'Helpdesk staff: edits regular users, cannot create admins',
+        true,
+        50
+    );
+
+INSERT INTO
+    role_permissions (role_id, permission)
+SELECT r.id, p.perm
+FROM roles r, (
+        VALUES ('users:read'), ('users:update'), ('users:role:assign'), ('roles:read')
+    ) AS p (perm)
+WHERE
+    r.name = 'support';
+
+-- Delegated admins: when a user has scopes, their user-management
+-- permissions only apply to users whose email domain is listed.
+CREATE TABLE user_admin_scopes (
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    email_domain TEXT NOT NULL,
+    PRIMARY KEY (user_id, email_domain)
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS user_admin_scopes;
+
+DELETE FROM roles WHERE name = 'support';
+
+ALTER TABLE roles DROP COLUMN level;
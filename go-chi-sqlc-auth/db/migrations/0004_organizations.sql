@@ -0,0 +1,41 @@
+CREATE TABLE organizations (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid (),
+    name TEXT NOT NULL,
+    slug TEXT NOT NULL UNIQUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE memberships (
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    org_id UUID NOT NULL REFERENCES organizations (id) ON DELETE CASCADE,
+    org_role TEXT NOT NULL DEFAULT 'member' CHECK (
+        org_role IN ('owner', 'admin', 'member')
+    ),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, org_id)
+);
+
+CREATE INDEX idx_memberships_org_id ON memberships (org_id);
+
+-- Platform admins act across every organization.
+INSERT INTO
+    permissions (name, description)
+VALUES (
+        'platform:admin',
+        'Manage users and organizations across all organizations'
+    );
+
+INSERT INTO
+    role_permissions (role_id, permission)
+SELECT id, 'platform:admin'
+FROM roles
+WHERE
+    name = 'admin';
+
+-- +goose Down
+DELETE FROM permissions WHERE name = 'platform:admin';
+
+DROP TABLE IF EXISTS memberships;
+
+DROP TABLE IF EXISTS organizations;
@@ -0,0 +1,37 @@
+CREATE TABLE org_invitations (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid (),
+    org_id UUID NOT NULL REFERENCES organizations (id) ON DELETE CASCADE,
+    email TEXT NOT NULL,
+    org_role TEXT NOT NULL DEFAULT 'member' CHECK (
+        org_role IN ('owner', 'admin', 'member')
+    ),
+    -- sha256 of the emailed token; the token itself is never stored
+    token_hash TEXT NOT NULL UNIQUE,
+    invited_by UUID REFERENCES users (id) ON DELETE SET NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    accepted_at TIMESTAMPTZ,
+    accepted_by UUID REFERENCES users (id) ON DELETE SET NULL,
+    revoked_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_org_invitations_org_id ON org_invitations (org_id);
+
+-- A verified domain attaches new users with a matching email to the organization.
+CREATE TABLE org_domains (
+    domain TEXT PRIMARY KEY,
+    org_id UUID NOT NULL REFERENCES organizations (id) ON DELETE CASCADE,
+    verification_token TEXT NOT NULL,
+    verified_at TIMESTAMPTZ,
+    auto_join_role TEXT NOT NULL DEFAULT 'member' CHECK (
+        auto_join_role IN ('admin', 'member')
+    ),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_org_domains_org_id ON org_domains (org_id);
+
+-- +goose Down
+DROP TABLE IF EXISTS org_domains;
+
+DROP TABLE IF EXISTS org_invitations;
@@ -0,0 +1,43 @@
+-- Accounts registered in approval mode wait as 'pending' until an admin approves them.
+ALTER TABLE users
+ADD COLUMN status TEXT NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'pending'));
+
+CREATE INDEX idx_users_status ON users (status)
+WHERE
+    status <> 'active';
+
+CREATE TABLE registration_codes (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid (),
+    -- sha256 of the code; the code itself is only shown once
+    code_hash TEXT NOT NULL UNIQUE,
+    note TEXT NOT NULL DEFAULT '',
+    max_uses INT,
+    uses INT NOT NULL DEFAULT 0,
+    expires_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ,
+    created_by UUID REFERENCES users (id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+INSERT INTO
+    permissions (name, description)
+VALUES (
+        'registrations:manage',
+        'Approve or reject pending accounts and manage registration codes'
+    );
+
+INSERT INTO
+    role_permissions (role_id, permission)
+SELECT id, 'registrations:manage'
+FROM roles
+WHERE
+    name = 'admin';
+
+-- +goose Down
+DELETE FROM permissions WHERE name = 'registrations:manage';
+
+DROP TABLE IF EXISTS registration_codes;
+
+DROP INDEX IF EXISTS idx_users_status;
+
+ALTER TABLE users DROP COLUMN status;
@@ -0,0 +1,62 @@
+-- Account lifecycle. Allowed transitions are enforced by the application
+-- (models.UserStatus.CanTransitionTo); the table only constrains the values.
+ALTER TABLE users DROP CONSTRAINT users_status_check;
+
+ALTER TABLE users
+ADD CONSTRAINT users_status_check CHECK (
+    status IN (
+        'active',
+        'pending',
+        'suspended',
+        'locked',
+        'deactivated'
+    )
+),
+ADD COLUMN status_reason TEXT NOT NULL DEFAULT '',
+ADD COLUMN status_changed_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+ADD COLUMN status_changed_by UUID REFERENCES users (id) ON DELETE SET NULL;
+
+UPDATE users SET status_changed_at = created_at;
+
+-- Every transition, newest last
+CREATE TABLE user_status_history (
+    id BIGSERIAL PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    from_status TEXT NOT NULL,
+    to_status TEXT NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    changed_by UUID REFERENCES users (id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_user_status_history_user ON user_status_history (user_id, created_at);
+
+INSERT INTO
+    permissions (name, description)
+VALUES (
+        'users:status:update',
+        'Suspend, lock, deactivate and reactivate accounts'
+    );
+
+INSERT INTO
+    role_permissions (role_id, permission)
+SELECT id, 'users:status:update'
+FROM roles
+WHERE
+    name IN ('admin', 'support');
+
+-- +goose Down
+DELETE FROM permissions WHERE name = 'users:status:update';
+
+DROP TABLE IF EXISTS user_status_history;
+
+UPDATE users SET status = 'active' WHERE status NOT IN ('active', 'pending');
+
+ALTER TABLE users
+DROP COLUMN status_changed_by,
+DROP COLUMN status_changed_at,
+DROP COLUMN status_reason,
+DROP CONSTRAINT users_status_check;
+
+ALTER TABLE users
+ADD CONSTRAINT users_status_check CHECK (status IN ('active', 'pending'));
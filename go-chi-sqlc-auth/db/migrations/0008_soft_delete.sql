@@ -0,0 +1,14 @@
+-- Deleted users keep their row until the purger removes it after the retention period.
+-- Usernames and emails stay reserved until then so the account can be restored.
+ALTER TABLE users
+ADD COLUMN deleted_at TIMESTAMPTZ,
+ADD COLUMN deleted_by UUID REFERENCES users (id) ON DELETE SET NULL;
+
+CREATE INDEX idx_users_deleted_at ON users (deleted_at)
+WHERE
+    deleted_at IS NOT NULL;
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_users_deleted_at;
+
+ALTER TABLE users DROP COLUMN deleted_by, DROP COLUMN deleted_at;
@@ -0,0 +1,31 @@
+-- Self-service deletion: the account is erased once deletion_scheduled_at has passed,
+-- unless the user logs in before then.
+ALTER TABLE users
+ADD COLUMN deletion_scheduled_at TIMESTAMPTZ,
+ADD COLUMN erased_at TIMESTAMPTZ;
+
+CREATE INDEX idx_users_deletion_scheduled_at ON users (deletion_scheduled_at)
+WHERE
+    deletion_scheduled_at IS NOT NULL;
+
+-- Append-only audit trail. actor_id and target_id are plain values, not foreign keys,
+-- so entries outlive the users they mention. signature is an HMAC over the entry.
+CREATE TABLE audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    actor_id UUID,
+    action TEXT NOT NULL,
+    target_type TEXT NOT NULL,
+    target_id TEXT NOT NULL,
+    data JSONB NOT NULL DEFAULT '{}',
+    signature TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_audit_log_target ON audit_log (target_type, target_id, created_at);
+
+-- +goose Down
+DROP TABLE IF EXISTS audit_log;
+
+DROP INDEX IF EXISTS idx_users_deletion_scheduled_at;
+
+ALTER TABLE users DROP COLUMN erased_at, DROP COLUMN deletion_scheduled_at;
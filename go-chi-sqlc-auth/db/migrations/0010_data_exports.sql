@@ -0,0 +1,41 @@
+-- Personal data exports. The archive is kept in content until expires_at,
+-- after which the purger removes the row.
+CREATE TABLE data_exports (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid (),
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    requested_by UUID REFERENCES users (id) ON DELETE SET NULL,
+    format TEXT NOT NULL CHECK (format IN ('json', 'zip')),
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (
+        status IN ('pending', 'ready', 'failed')
+    ),
+    error TEXT NOT NULL DEFAULT '',
+    content BYTEA,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    completed_at TIMESTAMPTZ,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_data_exports_user_id ON data_exports (user_id);
+
+CREATE INDEX idx_audit_log_actor ON audit_log (actor_id, created_at);
+
+INSERT INTO
+    permissions (name, description)
+VALUES (
+        'users:export',
+        'Export the personal data of other users'
+    );
+
+INSERT INTO
+    role_permissions (role_id, permission)
+SELECT id, 'users:export'
+FROM roles
+WHERE
+    name = 'admin';
+
+-- +goose Down
+DELETE FROM permissions WHERE name = 'users:export';
+
+DROP INDEX IF EXISTS idx_audit_log_actor;
+
+DROP TABLE IF EXISTS data_exports;
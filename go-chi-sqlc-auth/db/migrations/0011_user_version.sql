@@ -0,0 +1,21 @@
+-- Row version for optimistic concurrency (ETag / If-Match). The trigger bumps
+-- it on every update, so no write path can forget to.
+ALTER TABLE users ADD COLUMN version BIGINT NOT NULL DEFAULT 1;
+
+CREATE FUNCTION users_bump_version () RETURNS trigger AS $$
+BEGIN
+    NEW.version := OLD.version + 1;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER users_version
+BEFORE UPDATE ON users
+FOR EACH ROW EXECUTE FUNCTION users_bump_version ();
+
+-- +goose Down
+DROP TRIGGER IF EXISTS users_version ON users;
+
+DROP FUNCTION IF EXISTS users_bump_version ();
+
+ALTER TABLE users DROP COLUMN version;
@@ -0,0 +1,5 @@
+-- Supports keyset pagination of GET /users over (created_at, id).
+CREATE INDEX idx_users_created_at_id ON users (created_at DESC, id DESC);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_users_created_at_id;
@@ -0,0 +1,37 @@
+-- Search and filtering for GET /users.
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- Lowercased searchable text, kept in sync by Postgres. The tsvector serves
+-- whole-word matches, the trigram index fuzzy and substring matches.
+ALTER TABLE users
+ADD COLUMN search_text TEXT GENERATED ALWAYS AS (
+    lower(username || ' ' || email || ' ' || first_name || ' ' || last_name)
+) STORED,
+ADD COLUMN search_vector TSVECTOR GENERATED ALWAYS AS (
+    to_tsvector('simple', lower(username || ' ' || email || ' ' || first_name || ' ' || last_name))
+) STORED;
+
+CREATE INDEX idx_users_search_vector ON users USING GIN (search_vector);
+
+CREATE INDEX idx_users_search_text ON users USING GIN (search_text gin_trgm_ops);
+
+CREATE INDEX idx_users_email_domain ON users (lower(split_part(email, '@', 2)));
+
+-- Keyset pagination for the other sort orders
+CREATE INDEX idx_users_updated_at_id ON users (updated_at, id);
+
+CREATE INDEX idx_users_last_name_id ON users (last_name, id);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_users_last_name_id;
+
+DROP INDEX IF EXISTS idx_users_updated_at_id;
+
+DROP INDEX IF EXISTS idx_users_email_domain;
+
+DROP INDEX IF EXISTS idx_users_search_text;
+
+DROP INDEX IF EXISTS idx_users_search_vector;
+
+ALTER TABLE users DROP COLUMN IF EXISTS search_vector,
+DROP COLUMN IF EXISTS search_text;
@@ -0,0 +1,32 @@
+-- Single-use links for users created by an admin to choose their first password.
+CREATE TABLE password_setup_tokens (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid (),
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    -- sha256 of the emailed token; the token itself is never stored
+    token_hash TEXT NOT NULL UNIQUE,
+    created_by UUID REFERENCES users (id) ON DELETE SET NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_password_setup_tokens_user_id ON password_setup_tokens (user_id);
+
+INSERT INTO
+    permissions (name, description)
+VALUES (
+        'users:create',
+        'Create accounts on behalf of other people'
+    );
+
+INSERT INTO
+    role_permissions (role_id, permission)
+SELECT id, 'users:create'
+FROM roles
+WHERE
+    name = 'admin';
+
+-- +goose Down
+DELETE FROM permissions WHERE name = 'users:create';
+
+DROP TABLE IF EXISTS password_setup_tokens;
@@ -0,0 +1,28 @@
+-- Bulk user imports. The per-row report is kept in report until expires_at,
+-- after which the purger removes the row.
+CREATE TABLE user_imports (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid (),
+    requested_by UUID REFERENCES users (id) ON DELETE SET NULL,
+    format TEXT NOT NULL CHECK (format IN ('csv', 'ndjson')),
+    dry_run BOOLEAN NOT NULL DEFAULT false,
+    upsert BOOLEAN NOT NULL DEFAULT false,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (
+        status IN ('pending', 'running', 'done', 'failed')
+    ),
+    error TEXT NOT NULL DEFAULT '',
+    total INT NOT NULL DEFAULT 0,
+    processed INT NOT NULL DEFAULT 0,
+    created INT NOT NULL DEFAULT 0,
+    updated INT NOT NULL DEFAULT 0,
+    skipped INT NOT NULL DEFAULT 0,
+    failed INT NOT NULL DEFAULT 0,
+    report JSONB,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    completed_at TIMESTAMPTZ,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_user_imports_requested_by ON user_imports (requested_by);
+
+-- +goose Down
+DROP TABLE IF EXISTS user_imports;
@@ -0,0 +1,8 @@
+-- Profile pictures. The thumbnails live in the blob store; avatar_keys lists
+-- every stored file so they can be removed when replaced, erased or purged.
+ALTER TABLE users
+ADD COLUMN avatar_url TEXT,
+ADD COLUMN avatar_keys TEXT[] NOT NULL DEFAULT '{}';
+
+-- +goose Down
+ALTER TABLE users DROP COLUMN avatar_keys, DROP COLUMN avatar_url;
@@ -0,0 +1,53 @@
+-- Admin-defined profile attributes. Values live in users.attributes keyed by
+-- attribute name and are validated against the definition on write.
+CREATE TABLE profile_attributes (
+    name TEXT PRIMARY KEY CHECK (name ~ '^[a-z][a-z0-9_]{0,62}$'),
+    label TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    type TEXT NOT NULL CHECK (
+        type IN ('string', 'number', 'boolean', 'date', 'enum')
+    ),
+    required BOOLEAN NOT NULL DEFAULT false,
+    pattern TEXT NOT NULL DEFAULT '',
+    options TEXT[] NOT NULL DEFAULT '{}',
+    visibility TEXT NOT NULL DEFAULT 'private' CHECK (
+        visibility IN ('public', 'private', 'admin')
+    ),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+ALTER TABLE users ADD COLUMN attributes JSONB NOT NULL DEFAULT '{}';
+
+-- Per-user settings that are not part of the profile. A missing row means
+-- the defaults.
+CREATE TABLE user_preferences (
+    user_id UUID PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    locale TEXT NOT NULL DEFAULT 'en',
+    timezone TEXT NOT NULL DEFAULT 'UTC',
+    notifications JSONB NOT NULL DEFAULT '{}',
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+INSERT INTO
+    permissions (name, description)
+VALUES (
+        'profile:attributes:manage',
+        'Define the custom profile attributes users have'
+    );
+
+INSERT INTO
+    role_permissions (role_id, permission)
+SELECT id, 'profile:attributes:manage'
+FROM roles
+WHERE
+    name = 'admin';
+
+-- +goose Down
+DELETE FROM permissions WHERE name = 'profile:attributes:manage';
+
+DROP TABLE IF EXISTS user_preferences;
+
+ALTER TABLE users DROP COLUMN attributes;
+
+DROP TABLE IF EXISTS profile_attributes;
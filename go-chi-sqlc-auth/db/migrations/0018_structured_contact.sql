@@ -0,0 +1,38 @@
+-- Phone numbers in E.164 with the region they belong to and when they were
+-- confirmed by SMS; addresses as a JSON object (lines, city, region,
+-- postal_code, country). The old free text moves to legacy_* columns so
+-- nothing is lost: the server converts legacy phone numbers it can parse at
+-- startup, the rest stays until the user enters a new value.
+ALTER TABLE users RENAME COLUMN phone_number TO legacy_phone_number;
+ALTER TABLE users RENAME COLUMN address TO legacy_address;
+
+UPDATE users SET legacy_phone_number = NULLIF(btrim(legacy_phone_number), ''),
+    legacy_address = NULLIF(btrim(legacy_address), '')
+WHERE legacy_phone_number IS NOT NULL OR legacy_address IS NOT NULL;
+
+ALTER TABLE users
+ADD COLUMN phone_number TEXT CHECK (phone_number ~ '^\+[1-9][0-9]{6,14}$'),
+ADD COLUMN phone_region TEXT,
+ADD COLUMN phone_verified_at TIMESTAMPTZ,
+ADD COLUMN address JSONB CHECK (jsonb_typeof(address) = 'object');
+
+-- +goose Down
+-- Structured values are flattened back to text; legacy text that was never
+-- replaced is restored as it was.
+UPDATE users SET legacy_phone_number = COALESCE(phone_number, legacy_phone_number),
+    legacy_address = CASE WHEN address IS NULL THEN legacy_address ELSE
+        concat_ws(E'\n',
+            array_to_string(ARRAY(SELECT jsonb_array_elements_text(address->'lines')), E'\n'),
+            concat_ws(' ', address->>'postal_code', address->>'city'),
+            address->>'region',
+            address->>'country') END
+WHERE phone_number IS NOT NULL OR address IS NOT NULL;
+
+ALTER TABLE users
+DROP COLUMN address,
+DROP COLUMN phone_verified_at,
+DROP COLUMN phone_region,
+DROP COLUMN phone_number;
+
+ALTER TABLE users RENAME COLUMN legacy_address TO address;
+ALTER TABLE users RENAME COLUMN legacy_phone_number TO phone_number;
@@ -0,0 +1,59 @@
+-- Emails and usernames are unique regardless of case and Unicode
+-- compatibility forms: email_key and username_key hold the NFKC-normalized,
+-- lower-cased value, and logins look users up by them. Lower-casing beyond
+-- ASCII follows the database's LC_CTYPE, so use a UTF-8 locale.
+-- username_skeleton is written by the server (internal/confusables) to find
+-- lookalike usernames. Usernames were ASCII until now, for which the
+-- expression below gives the same skeleton.
+--
+-- Accounts that already differ only in case stop the migration; the error
+-- lists them so they can be renamed or merged first.
+DO $$
+DECLARE
+    dupes TEXT;
+BEGIN
+    SELECT string_agg(k, ', ') INTO dupes FROM (
+        SELECT 'email ' || lower(normalize(email, NFKC)) AS k FROM users GROUP BY 1 HAVING count(*) > 1
+        UNION ALL
+        SELECT 'username ' || lower(normalize(username, NFKC)) FROM users GROUP BY 1 HAVING count(*) > 1
+    ) d;
+    IF dupes IS NOT NULL THEN
+        RAISE EXCEPTION 'users differ only in case or Unicode form: %', dupes;
+    END IF;
+END $$;
+
+ALTER TABLE users
+ADD COLUMN email_key TEXT GENERATED ALWAYS AS (lower(normalize(email, NFKC))) STORED,
+ADD COLUMN username_key TEXT GENERATED ALWAYS AS (lower(normalize(username, NFKC))) STORED,
+ADD COLUMN username_skeleton TEXT;
+
+UPDATE users SET username_skeleton = replace(lower(translate(normalize(username, NFKC), '01I', 'oll')), 'rn', 'm');
+
+ALTER TABLE users ALTER COLUMN username_skeleton SET NOT NULL;
+
+ALTER TABLE users DROP CONSTRAINT users_email_key, DROP CONSTRAINT users_username_key;
+
+DROP INDEX IF EXISTS idx_users_email;
+
+DROP INDEX IF EXISTS idx_users_username;
+
+CREATE UNIQUE INDEX idx_users_email_key ON users (email_key);
+
+CREATE UNIQUE INDEX idx_users_username_key ON users (username_key);
+
+CREATE INDEX idx_users_username_skeleton ON users (username_skeleton);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_users_username_skeleton;
+
+DROP INDEX IF EXISTS idx_users_username_key;
+
+DROP INDEX IF EXISTS idx_users_email_key;
+
+ALTER TABLE users DROP COLUMN username_skeleton, DROP COLUMN username_key, DROP COLUMN email_key;
+
+ALTER TABLE users ADD CONSTRAINT users_email_key UNIQUE (email), ADD CONSTRAINT users_username_key UNIQUE (username);
+
+CREATE INDEX idx_users_email ON users (email);
+
+CREATE INDEX idx_users_username ON users (username);
@@ -0,0 +1,29 @@
+-- Usernames admins have blocked, e.g. offensive words or impersonations of
+-- staff. '*' in a pattern matches any run of characters and '?' exactly one;
+-- patterns are stored lower-cased and matched regardless of case.
+CREATE TABLE username_blocklist (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid (),
+    pattern TEXT NOT NULL UNIQUE,
+    note TEXT NOT NULL DEFAULT '',
+    created_by UUID REFERENCES users (id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+INSERT INTO
+    permissions (name, description)
+VALUES (
+        'usernames:manage',
+        'Manage the blocklist of usernames'
+    );
+
+INSERT INTO
+    role_permissions (role_id, permission)
+SELECT id, 'usernames:manage'
+FROM roles
+WHERE
+    name = 'admin';
+
+-- +goose Down
+DELETE FROM permissions WHERE name = 'usernames:manage';
+
+DROP TABLE IF EXISTS username_blocklist;
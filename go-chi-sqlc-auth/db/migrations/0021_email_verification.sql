@@ -0,0 +1,32 @@
+-- Organizations' verified domains only admit users who proved they own their
+-- address: by following the link sent at registration, or the set-password
+-- link of an admin-created account. Changing the email clears the flag.
+ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMPTZ;
+
+CREATE TABLE email_verification_tokens (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid (),
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    -- the address the link was sent to; the link stops working if it changes
+    email TEXT NOT NULL,
+    -- sha256 of the emailed token; the token itself is never stored
+    token_hash TEXT NOT NULL UNIQUE,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_email_verification_tokens_user_id ON email_verification_tokens (user_id);
+
+-- Auto-joining makes people members, never admins
+UPDATE org_domains SET auto_join_role = 'member' WHERE auto_join_role <> 'member';
+
+ALTER TABLE org_domains DROP CONSTRAINT org_domains_auto_join_role_check,
+ADD CONSTRAINT org_domains_auto_join_role_check CHECK (auto_join_role = 'member');
+
+-- +goose Down
+ALTER TABLE org_domains DROP CONSTRAINT org_domains_auto_join_role_check,
+ADD CONSTRAINT org_domains_auto_join_role_check CHECK (auto_join_role IN ('admin', 'member'));
+
+DROP TABLE IF EXISTS email_verification_tokens;
+
+ALTER TABLE users DROP COLUMN email_verified_at;
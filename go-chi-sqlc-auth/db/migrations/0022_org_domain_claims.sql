@@ -0,0 +1,29 @@
+-- Several organizations may claim a domain; only proving it with the DNS
+-- record makes it theirs, and only one organization can hold it verified.
+-- Before, the first claim reserved the domain even without proof.
+ALTER TABLE org_domains DROP CONSTRAINT org_domains_pkey, ADD PRIMARY KEY (domain, org_id);
+
+CREATE UNIQUE INDEX idx_org_domains_verified ON org_domains (domain)
+WHERE
+    verified_at IS NOT NULL;
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_org_domains_verified;
+
+-- Keep the verified claim, or else the oldest one, for each domain
+DELETE FROM org_domains d
+WHERE
+    EXISTS (
+        SELECT 1
+        FROM org_domains o
+        WHERE
+            o.domain = d.domain
+            AND o.org_id <> d.org_id
+            AND (
+                o.verified_at IS NOT NULL AND d.verified_at IS NULL
+                OR (o.verified_at IS NULL) = (d.verified_at IS NULL)
+                AND (o.created_at, o.org_id) < (d.created_at, d.org_id)
+            )
+    );
+
+ALTER TABLE org_domains DROP CONSTRAINT org_domains_pkey, ADD PRIMARY KEY (domain);
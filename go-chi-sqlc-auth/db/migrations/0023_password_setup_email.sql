@@ -0,0 +1,7 @@
+-- Set-password links remember the address they were mailed to. Redeeming one
+-- only verifies that address, so changing the email before the link is used
+-- can't verify an address nobody proved to own.
+ALTER TABLE password_setup_tokens ADD COLUMN email TEXT;
+
+-- +goose Down
+ALTER TABLE password_setup_tokens DROP COLUMN email;
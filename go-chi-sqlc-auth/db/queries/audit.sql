@@ -0,0 +1,14 @@
+-- name: InsertAuditEntry :one
+INSERT INTO
+    audit_log (
+        actor_id,
+        action,
+        target_type,
+        target_id,
+        data,
+        signature,
+        created_at
+    )
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+RETURNING
+    id;
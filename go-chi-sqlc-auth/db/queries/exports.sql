@@ -0,0 +1,31 @@
+-- name: CreateDataExport :one
+INSERT INTO
+    data_exports (
+        user_id,
+        requested_by,
+        format,
+        expires_at
+    )
+VALUES ($1, $2, $3, $4)
+RETURNING
+    *;
+
+-- name: GetDataExport :one
+SELECT *
+FROM data_exports
+WHERE
+    id = $1
+    AND user_id = $2
+    AND expires_at > now();
+
+-- name: CompleteDataExport :exec
+UPDATE data_exports
+SET
+    status = 'ready',
+    content = $2,
+    completed_at = now()
+WHERE
+    id = $1;
+
+-- name: PurgeExpiredDataExports :execrows
+DELETE FROM data_exports WHERE expires_at <= $1;
@@ -0,0 +1,59 @@
+-- name: CreateInvitation :one
+INSERT INTO
+    org_invitations (
+        org_id,
+        email,
+        org_role,
+        token_hash,
+        invited_by,
+        expires_at
+    )
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING
+    *;
+
+-- name: ListInvitations :many
+SELECT *
+FROM org_invitations
+WHERE
+    org_id = $1
+ORDER BY created_at DESC;
+
+-- name: GetPendingInvitationByTokenHash :one
+SELECT *
+FROM org_invitations
+WHERE
+    token_hash = $1
+    AND accepted_at IS NULL
+    AND revoked_at IS NULL
+    AND expires_at > now();
+
+-- name: RevokeInvitation :one
+UPDATE org_invitations
+SET
+    revoked_at = now()
+WHERE
+    id = $1
+    AND org_id = $2
+    AND accepted_at IS NULL
+    AND revoked_at IS NULL
+RETURNING
+    *;
+
+-- name: CreateOrgDomain :one
+INSERT INTO
+    org_domains (
+        domain,
+        org_id,
+        verification_token,
+        auto_join_role
+    )
+VALUES ($1, $2, $3, $4)
+RETURNING
+    *;
+
+-- name: VerifyOrgDomain :one
+UPDATE org_domains SET verified_at = now() WHERE domain = $1 RETURNING *;
+
+-- name: ListVerifiedOrgDomains :many
+SELECT * FROM org_domains WHERE domain = $1 AND verified_at IS NOT NULL;
@@ -0,0 +1,49 @@
+-- name: CreateOrganization :one
+INSERT INTO organizations (name, slug) VALUES ($1, $2) RETURNING *;
+
+-- name: GetOrganization :one
+SELECT * FROM organizations WHERE id = $1;
+
+-- name: ListOrganizations :many
+SELECT * FROM organizations ORDER BY name;
+
+-- name: ListUserOrganizations :many
+SELECT o.*
+FROM organizations o
+    JOIN memberships m ON m.org_id = o.id
+WHERE
+    m.user_id = $1
+ORDER BY o.name;
+
+-- name: UpdateOrganization :one
+UPDATE organizations
+SET
+    name = $2,
+    slug = $3,
+    updated_at = now()
+WHERE
+    id = $1
+RETURNING
+    *;
+
+-- name: DeleteOrganization :exec
+DELETE FROM organizations WHERE id = $1;
+
+-- name: UpsertMembership :one
+INSERT INTO
+    memberships (user_id, org_id, org_role)
+VALUES ($1, $2, $3) ON CONFLICT (user_id, org_id) DO
+UPDATE
+SET
+    org_role = EXCLUDED.org_role
+RETURNING
+    *;
+
+-- name: GetMembership :one
+SELECT * FROM memberships WHERE user_id = $1 AND org_id = $2;
+
+-- name: ListMemberships :many
+SELECT * FROM memberships WHERE org_id = $1 ORDER BY created_at;
+
+-- name: DeleteMembership :exec
+DELETE FROM memberships WHERE user_id = $1 AND org_id = $2;
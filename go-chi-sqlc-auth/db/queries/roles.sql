@@ -0,0 +1,63 @@
+-- name: ListRoles :many
+SELECT * FROM roles ORDER BY name;
+
+-- name: GetRoleByID :one
+SELECT * FROM roles WHERE id = $1;
+
+-- name: CreateRole :one
+INSERT INTO
+    roles (name, description, level)
+VALUES ($1, $2, $3)
+RETURNING
+    *;
+
+-- name: UpdateRole :one
+UPDATE roles
+SET
+    description = $2,
+    level = $3,
+    updated_at = now()
+WHERE
+    id = $1
+RETURNING
+    *;
+
+-- name: DeleteRole :exec
+DELETE FROM roles WHERE id = $1 AND NOT built_in;
+
+-- name: ListPermissions :many
+SELECT * FROM permissions ORDER BY name;
+
+-- name: ListRolePermissions :many
+SELECT permission FROM role_permissions WHERE role_id = $1 ORDER BY permission;
+
+-- name: ListUserRoleNames :many
+SELECT r.name
+FROM user_roles ur
+    JOIN roles r ON r.id = ur.role_id
+WHERE
+    ur.user_id = $1
+ORDER BY r.name;
+
+-- name: ListUserPermissions :many
+SELECT DISTINCT
+    rp.permission
+FROM user_roles ur
+    JOIN role_permissions rp ON rp.role_id = ur.role_id
+WHERE
+    ur.user_id = $1;
+
+-- name: ListAdminScopes :many
+SELECT email_domain
+FROM user_admin_scopes
+WHERE
+    user_id = $1
+ORDER BY email_domain;
+
+-- name: AddAdminScope :exec
+INSERT INTO
+    user_admin_scopes (user_id, email_domain)
+VALUES ($1, $2) ON CONFLICT DO NOTHING;
+
+-- name: ClearAdminScopes :exec
+DELETE FROM user_admin_scopes WHERE user_id = $1;
@@ -7,8 +7,7 @@ INSERT INTO
         first_name,
         last_name,
         phone_number,
-        address,
-        role
+        address
     )
 VALUES (
         $1,
@@ -17,23 +16,29 @@ VALUES (
         $4,
         $5,
         $6,
-        $7,
-        $8
+        $7
     )
 RETURNING
     *;
 
 -- name: GetUserByID :one
-SELECT * FROM users WHERE id = $1;
+SELECT * FROM users WHERE id = $1 AND deleted_at IS NULL;
 
 -- name: GetUserByEmail :one
-SELECT * FROM users WHERE email = $1;
+SELECT * FROM users WHERE email = $1 AND deleted_at IS NULL;
 
 -- name: GetUserByUsername :one
-SELECT * FROM users WHERE username = $1;
+SELECT * FROM users WHERE username = $1 AND deleted_at IS NULL;
 
 -- name: ListUsers :many
-SELECT * FROM users ORDER BY created_at DESC LIMIT $1 OFFSET $2;
+SELECT *
+FROM users
+WHERE
+    deleted_at IS NULL
+ORDER BY created_at DESC
+LIMIT $1
+OFFSET
+    $2;
 
 -- name: UpdateUser :one
 UPDATE users
@@ -44,7 +49,6 @@ SET
     last_name = $5,
     phone_number = $6,
     address = $7,
-    role = $8,
     updated_at = now()
 WHERE
     id = $1
@@ -62,4 +66,67 @@ RETURNING
     *;
 
 -- name: DeleteUser :exec
-DELETE FROM users WHERE id = $1;
\ No newline at end of file
+UPDATE users
+SET
+    deleted_at = now(),
+    deleted_by = $2,
+    updated_at = now()
+WHERE
+    id = $1
+    AND deleted_at IS NULL;
+
+-- name: RestoreUser :execrows
+UPDATE users
+SET
+    deleted_at = NULL,
+    deleted_by = NULL,
+    updated_at = now()
+WHERE
+    id = $1
+    AND deleted_at IS NOT NULL;
+
+-- name: PurgeDeletedUsers :execrows
+DELETE FROM users WHERE deleted_at < $1;
+
+-- name: SetUserStatus :execrows
+WITH
+    upd AS (
+        UPDATE users
+        SET
+            status = sqlc.arg (to_status),
+            status_reason = sqlc.arg (reason),
+            status_changed_at = now(),
+            status_changed_by = sqlc.narg (changed_by),
+            updated_at = now()
+        WHERE
+            id = sqlc.arg (id)
+            AND status = sqlc.arg (from_status)
+        RETURNING
+            id
+    )
+INSERT INTO
+    user_status_history (
+        user_id,
+        from_status,
+        to_status,
+        reason,
+        changed_by
+    )
+SELECT id, sqlc.arg (from_status), sqlc.arg (to_status), sqlc.arg (reason), sqlc.narg (changed_by)
+FROM upd;
+
+-- name: ListUserStatusHistory :many
+SELECT *
+FROM user_status_history
+WHERE
+    user_id = $1
+ORDER BY created_at, id;
+
+-- name: ListUsersPage :many
+SELECT *
+FROM users
+WHERE
+    deleted_at IS NULL
+    AND (created_at, id) < (sqlc.arg (after_created_at)::timestamptz, sqlc.arg (after_id)::uuid)
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg (page_size);
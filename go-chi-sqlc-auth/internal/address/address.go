@@ -0,0 +1,62 @@
+// Package address checks postal addresses against per-country rules kept
+// in countries.json: every ISO 3166-1 alpha-2 code (plus XK for Kosovo) and,
+// where the country uses them, the format of its postal codes. The formats
+// follow Google's libaddressinput data.
+package address
+
+import (
+	_ "embed"
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+//go:embed countries.json
+var countriesJSON []byte
+
+type Country struct {
+	Code string `json:"-"`
+	Name string `json:"name"`
+	// PostalCode is the pattern a postal code must match in full, empty
+	// when the country has no postal codes.
+	PostalCode string `json:"postal_code"`
+	postal     *regexp.Regexp
+}
+
+var countries = load()
+
+func load() map[string]Country {
+	var m map[string]Country
+	if err := json.Unmarshal(countriesJSON, &m); err != nil {
+		panic("address: countries.json: " + err.Error())
+	}
+	for code, c := range m {
+		c.Code = code
+		if c.PostalCode != "" {
+			c.postal = regexp.MustCompile(`^(?:` + c.PostalCode + `)$`)
+		}
+		m[code] = c
+	}
+	return m
+}
+
+// Lookup returns the country with the upper-case alpha-2 code.
+func Lookup(code string) (Country, bool) {
+	c, ok := countries[code]
+	return c, ok
+}
+
+// HasPostalCodes reports whether the country uses postal codes at all.
+func (c Country) HasPostalCodes() bool { return c.postal != nil }
+
+// ValidPostalCode reports whether s, as returned by NormalizePostalCode, is
+// a postal code of the country. Countries without postal codes take any.
+func (c Country) ValidPostalCode(s string) bool {
+	return c.postal == nil || c.postal.MatchString(s)
+}
+
+// NormalizePostalCode upper-cases s and collapses runs of spaces, the form
+// the patterns are written for.
+func NormalizePostalCode(s string) string {
+	return strings.ToUpper(strings.Join(strings.Fields(s), " "))
+}
@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Hashes imported from other systems may be argon2id in the PHC string
+// format, $argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>. New passwords are
+// always hashed with bcrypt.
+
+var errInvalidArgon2Hash = errors.New("invalid argon2id hash")
+
+type argon2Params struct {
+	memory     uint32
+	time       uint32
+	threads    uint8
+	salt, hash []byte
+}
+
+func parseArgon2(encoded string) (argon2Params, error) {
+	var p argon2Params
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return p, errInvalidArgon2Hash
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, errInvalidArgon2Hash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.threads); err != nil || p.time == 0 || p.threads == 0 {
+		return p, errInvalidArgon2Hash
+	}
+	var err error
+	if p.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return p, errInvalidArgon2Hash
+	}
+	if p.hash, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(p.hash) == 0 {
+		return p, errInvalidArgon2Hash
+	}
+	return p, nil
+}
+
+func checkArgon2(encoded, pw string) error {
+	p, err := parseArgon2(encoded)
+	if err != nil {
+		return err
+	}
+	got := argon2.IDKey([]byte(pw), p.salt, p.time, p.memory, p.threads, uint32(len(p.hash)))
+	if subtle.ConstantTimeCompare(got, p.hash) != 1 {
+		return bcrypt.ErrMismatchedHashAndPassword
+	}
+	return nil
+}
+
+// ValidPasswordHash reports whether hash is a bcrypt or argon2id hash that
+// CheckPassword can verify.
+func ValidPasswordHash(hash string) bool {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		_, err := parseArgon2(hash)
+		return err == nil
+	}
+	_, err := bcrypt.Cost([]byte(hash))
+	return err == nil
+}
@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"strings"
 	"time"
 
 	"dev.mfr/go-chi-sqlc-auth/internal/models"
@@ -21,26 +22,45 @@ func HashPassword(pw string) (string, error) {
 	return string(b), nil
 }
 
+// CheckPassword verifies pw against a bcrypt hash, or an argon2id hash from an import.
 func CheckPassword(hash, pw string) error {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		return checkArgon2(hash, pw)
+	}
 	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(pw))
 }
 
+// AMRPassword is the RFC 8176 method reference for password authentication.
+const AMRPassword = "pwd"
+
 type Claims struct {
-	UserID string      `json:"uid"`
-	Role   models.Role `json:"role"`
+	UserID   string           `json:"uid"`
+	Roles    []models.Role    `json:"roles"`
+	OrgID    string           `json:"org,omitempty"` // active organization
+	AuthTime *jwt.NumericDate `json:"auth_time,omitempty"`
+	AMR      []string         `json:"amr,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func (j JWTIssuer) Issue(userID string, role models.Role) (string, error) {
-	claims := &Claims{
-		UserID: userID,
-		Role:   role,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(j.Expires)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-		},
+// Issue creates a token for a user who has just authenticated with a password.
+func (j JWTIssuer) Issue(userID string, roles []models.Role, orgID string) (string, error) {
+	return j.IssueClaims(Claims{
+		UserID:   userID,
+		Roles:    roles,
+		OrgID:    orgID,
+		AuthTime: jwt.NewNumericDate(time.Now()),
+		AMR:      []string{AMRPassword},
+	})
+}
+
+// IssueClaims signs c with fresh expiry and issued-at times, keeping its auth_time and amr.
+func (j JWTIssuer) IssueClaims(c Claims) (string, error) {
+	now := time.Now()
+	c.RegisteredClaims = jwt.RegisteredClaims{
+		ExpiresAt: jwt.NewNumericDate(now.Add(j.Expires)),
+		IssuedAt:  jwt.NewNumericDate(now),
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, &c)
 	return token.SignedString(j.Secret)
 }
 
@@ -0,0 +1,307 @@
+package authz
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"dev.mfr/go-chi-sqlc-auth/internal/middleware"
+	"dev.mfr/go-chi-sqlc-auth/internal/models"
+)
+
+var ErrForbidden = errors.New("forbidden")
+
+type ctxKey string
+
+const ctxClientIP ctxKey = "client_ip"
+
+// Resource describes what an action targets. Attrs are exposed to policies as resource.<key>.
+type Resource struct {
+	Type  string         `json:"type"`
+	ID    string         `json:"id,omitempty"`
+	Attrs map[string]any `json:"attrs,omitempty"`
+}
+
+// Decision is the outcome of evaluating a request against the policy.
+type Decision struct {
+	Allowed bool   `json:"allowed"`
+	Rule    string `json:"rule,omitempty"`
+	Reason  string `json:"reason"`
+}
+
+// Engine evaluates subject, resource, action and environment attributes against a Policy.
+type Engine struct {
+	Policy       *Policy
+	LogDecisions bool
+}
+
+func New(p *Policy, logDecisions bool) *Engine {
+	return &Engine{Policy: p, LogDecisions: logDecisions}
+}
+
+// UserResource builds the resource attributes for a user account.
+func UserResource(u models.ManagedUser) Resource {
+	roles := make([]string, len(u.Roles))
+	for i, r := range u.Roles {
+		roles[i] = string(r)
+	}
+	return Resource{Type: "user", ID: u.ID, Attrs: map[string]any{
+		"owner_id":     u.ID,
+		"email_domain": u.EmailDomain,
+		"level":        u.Level,
+		"roles":        roles,
+		"org_ids":      u.OrgIDs,
+	}}
+}
+
+// OrgResource builds the resource attributes for an organization. callerRole is
+// the caller's role in that organization, empty when they are not a member.
+func OrgResource(orgID string, callerRole models.OrgRole) Resource {
+	return Resource{Type: "org", ID: orgID, Attrs: map[string]any{"caller_role": string(callerRole)}}
+}
+
+// RoleResource builds the resource attributes for a role of the given level.
+func RoleResource(name models.Role, level int) Resource {
+	return Resource{Type: "role", ID: string(name), Attrs: map[string]any{"level": level}}
+}
+
+// CaptureEnvironment records the client IP so policies can match on env.ip.
+func CaptureEnvironment(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := r.RemoteAddr
+		if host, _, err := net.SplitHostPort(ip); err == nil {
+			ip = host
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ctxClientIP, ip)))
+	})
+}
+
+// Authorize checks whether the authenticated caller may perform action on res.
+// It returns ErrForbidden when the policy denies the request.
+func (e *Engine) Authorize(ctx context.Context, action string, res Resource) error {
+	if !e.Check(ctx, action, res).Allowed {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// Check evaluates the request for the caller in ctx and returns the full decision.
+func (e *Engine) Check(ctx context.Context, action string, res Resource) Decision {
+	attrs := map[string]any{"action": action}
+	if p := middleware.PrincipalFrom(ctx); p != nil {
+		roles := make([]string, len(p.Roles))
+		for i, r := range p.Roles {
+			roles[i] = string(r)
+		}
+		perms := make([]string, len(p.Permissions))
+		for i, perm := range p.Permissions {
+			perms[i] = string(perm)
+		}
+		attrs["subject.id"] = p.UserID
+		attrs["subject.roles"] = roles
+		attrs["subject.permissions"] = perms
+		attrs["subject.level"] = p.Level
+		attrs["subject.scopes"] = p.Scopes
+		attrs["subject.org"] = p.OrgID
+		attrs["subject.org_role"] = string(p.OrgRole)
+	}
+	attrs["resource.type"] = res.Type
+	attrs["resource.id"] = res.ID
+	for k, v := range res.Attrs {
+		attrs["resource."+k] = v
+	}
+	now := time.Now().UTC()
+	attrs["env.time"] = now.Format(time.RFC3339)
+	attrs["env.hour"] = now.Hour()
+	attrs["env.weekday"] = strings.ToLower(now.Weekday().String())
+	if ip, ok := ctx.Value(ctxClientIP).(string); ok {
+		attrs["env.ip"] = ip
+	}
+
+	d := e.evaluate(action, res.Type, attrs)
+	if e.LogDecisions {
+		log.Printf("authz: allowed=%t subject=%v action=%s resource=%s/%s rule=%s reason=%q",
+			d.Allowed, attrs["subject.id"], action, res.Type, res.ID, d.Rule, d.Reason)
+	}
+	return d
+}
+
+func (e *Engine) evaluate(action, resourceType string, attrs map[string]any) Decision {
+	var allow *Rule
+	for i := range e.Policy.Rules {
+		rule := &e.Policy.Rules[i]
+		if !rule.matches(action, resourceType) || !allHold(rule.When, attrs) {
+			continue
+		}
+		if rule.Effect == Deny {
+			return Decision{Allowed: false, Rule: rule.ID, Reason: "denied by rule"}
+		}
+		if allow == nil {
+			allow = rule
+		}
+	}
+	if allow != nil {
+		return Decision{Allowed: true, Rule: allow.ID, Reason: "allowed by rule"}
+	}
+	return Decision{Allowed: false, Reason: "no rule allows this action"}
+}
+
+func allHold(conds []Condition, attrs map[string]any) bool {
+	for _, c := range conds {
+		if !c.holds(attrs) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c Condition) holds(attrs map[string]any) bool {
+	if len(c.All) > 0 && !allHold(c.All, attrs) {
+		return false
+	}
+	if len(c.Any) > 0 {
+		matched := false
+		for _, sub := range c.Any {
+			if sub.holds(attrs) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	for _, sub := range c.None {
+		if sub.holds(attrs) {
+			return false
+		}
+	}
+	if c.Attr == "" {
+		return true
+	}
+	left := attrs[c.Attr]
+	right := c.Value
+	if c.Ref != "" {
+		right = attrs[c.Ref]
+	}
+	switch c.Op {
+	case "eq":
+		return equal(left, right)
+	case "ne":
+		return !equal(left, right)
+	case "in":
+		for _, s := range toStrings(right) {
+			if equal(left, s) {
+				return true
+			}
+		}
+		return false
+	case "contains":
+		for _, s := range toStrings(left) {
+			if equal(s, right) {
+				return true
+			}
+		}
+		return false
+	case "gt", "gte", "lt", "lte":
+		l, lok := toFloat(left)
+		r, rok := toFloat(right)
+		if !lok || !rok {
+			return false
+		}
+		switch c.Op {
+		case "gt":
+			return l > r
+		case "gte":
+			return l >= r
+		case "lt":
+			return l < r
+		default:
+			return l <= r
+		}
+	case "cidr":
+		ip := net.ParseIP(toString(left))
+		if ip == nil {
+			return false
+		}
+		for _, cidr := range toStrings(right) {
+			if _, n, err := net.ParseCIDR(cidr); err == nil && n.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	case "empty":
+		return isEmpty(left)
+	case "not_empty":
+		return !isEmpty(left)
+	}
+	return false
+}
+
+func equal(a, b any) bool {
+	if af, ok := toFloat(a); ok {
+		bf, ok := toFloat(b)
+		return ok && af == bf
+	}
+	if a == nil || b == nil {
+		return false
+	}
+	return toString(a) == toString(b)
+}
+
+func isEmpty(v any) bool {
+	switch x := v.(type) {
+	case nil:
+		return true
+	case string:
+		return x == ""
+	}
+	return len(toStrings(v)) == 0
+}
+
+func toString(v any) string {
+	switch x := v.(type) {
+	case string:
+		return x
+	case nil:
+		return ""
+	case int:
+		return strconv.Itoa(x)
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(x)
+	}
+	return ""
+}
+
+func toStrings(v any) []string {
+	switch x := v.(type) {
+	case []string:
+		return x
+	case []any:
+		out := make([]string, 0, len(x))
+		for _, e := range x {
+			out = append(out, toString(e))
+		}
+		return out
+	case string:
+		return []string{x}
+	}
+	return nil
+}
+
+func toFloat(v any) (float64, bool) {
+	switch x := v.(type) {
+	case int:
+		return float64(x), true
+	case float64:
+		return x, true
+	}
+	return 0, false
+}
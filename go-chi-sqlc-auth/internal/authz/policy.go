@@ -0,0 +1,135 @@
+package authz
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+//go:embed default_policy.json
+var defaultPolicy []byte
+
+// Policy is a declarative list of rules. A request is allowed when at least
+// one allow rule matches and no deny rule does; anything else is denied.
+type Policy struct {
+	Rules []Rule `json:"rules"`
+}
+
+type Effect string
+
+const (
+	Allow Effect = "allow"
+	Deny  Effect = "deny"
+)
+
+// Rule matches when the action and resource type match and every condition holds.
+type Rule struct {
+	ID          string      `json:"id"`
+	Description string      `json:"description,omitempty"`
+	Effect      Effect      `json:"effect"`
+	Actions     []string    `json:"actions"`             // exact, "users:*" or "*"
+	Resources   []string    `json:"resources,omitempty"` // resource types; empty matches any
+	When        []Condition `json:"when,omitempty"`
+}
+
+// Condition compares an attribute with a literal Value or another attribute
+// named by Ref. Any, All and None group nested conditions instead.
+type Condition struct {
+	Attr  string      `json:"attr,omitempty"`
+	Op    string      `json:"op,omitempty"`
+	Value any         `json:"value,omitempty"`
+	Ref   string      `json:"ref,omitempty"`
+	Any   []Condition `json:"any,omitempty"`
+	All   []Condition `json:"all,omitempty"`
+	None  []Condition `json:"none,omitempty"`
+}
+
+var knownOps = map[string]bool{
+	"eq": true, "ne": true, "in": true, "contains": true,
+	"gt": true, "gte": true, "lt": true, "lte": true,
+	"cidr": true, "empty": true, "not_empty": true,
+}
+
+// LoadPolicy reads a JSON policy file, or the built-in default when path is empty.
+func LoadPolicy(path string) (*Policy, error) {
+	data := defaultPolicy
+	if path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		data = b
+	}
+	var p Policy
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("parse policy: %w", err)
+	}
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (p *Policy) validate() error {
+	for i, r := range p.Rules {
+		if r.ID == "" {
+			return fmt.Errorf("rule %d: id required", i)
+		}
+		if r.Effect != Allow && r.Effect != Deny {
+			return fmt.Errorf("rule %s: effect must be allow or deny", r.ID)
+		}
+		if len(r.Actions) == 0 {
+			return fmt.Errorf("rule %s: at least one action required", r.ID)
+		}
+		for _, c := range r.When {
+			if err := c.validate(); err != nil {
+				return fmt.Errorf("rule %s: %w", r.ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (c Condition) validate() error {
+	if len(c.Any) > 0 || len(c.All) > 0 || len(c.None) > 0 {
+		for _, sub := range append(append(append([]Condition{}, c.Any...), c.All...), c.None...) {
+			if err := sub.validate(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if c.Attr == "" {
+		return fmt.Errorf("condition needs attr, any, all or none")
+	}
+	if !knownOps[c.Op] {
+		return fmt.Errorf("unknown op %q", c.Op)
+	}
+	return nil
+}
+
+func (r Rule) matches(action, resourceType string) bool {
+	if len(r.Resources) > 0 && !containsString(r.Resources, resourceType) {
+		return false
+	}
+	for _, a := range r.Actions {
+		if a == "*" || a == action {
+			return true
+		}
+		if strings.HasSuffix(a, ":*") && strings.HasPrefix(action, strings.TrimSuffix(a, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,212 @@
+// Package avatar turns an uploaded picture into square profile thumbnails.
+package avatar
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/webp"
+)
+
+// Sizes are the edge lengths of the generated thumbnails, in pixels.
+var Sizes = []int{64, 128, 256}
+
+// MaxPixels bounds the decoded size of an upload, so a small file can't
+// expand into gigabytes of pixels.
+const MaxPixels = 25_000_000
+
+var (
+	ErrUnsupported = errors.New("image must be PNG, JPEG or WebP")
+	ErrDimensions  = errors.New("image dimensions too large")
+)
+
+// ContentTypes are the accepted upload types.
+var ContentTypes = []string{"image/png", "image/jpeg", "image/webp"}
+
+type Thumbnail struct {
+	Size        int
+	ContentType string
+	Ext         string
+	Data        []byte
+}
+
+// Sniff returns the image type of data judged by its content, or "" when
+// it isn't one of ContentTypes.
+func Sniff(data []byte) string {
+	ct := http.DetectContentType(data)
+	for _, t := range ContentTypes {
+		if ct == t {
+			return ct
+		}
+	}
+	return ""
+}
+
+// Process decodes data and returns a center-cropped square thumbnail for
+// each of Sizes. The thumbnails are encoded from the pixels alone, so EXIF,
+// XMP, ICC and text chunks of the upload are dropped; JPEG orientation is
+// applied first. Opaque pictures become JPEG, others keep alpha as PNG.
+func Process(data []byte) ([]Thumbnail, error) {
+	ct := Sniff(data)
+	decodeConfig, decode := decoders(ct)
+	if decode == nil {
+		return nil, ErrUnsupported
+	}
+	cfg, err := decodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupported, err)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > MaxPixels {
+		return nil, ErrDimensions
+	}
+	src, err := decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupported, err)
+	}
+	orientation := 1
+	if ct == "image/jpeg" {
+		orientation = jpegOrientation(data)
+	}
+
+	// The centered square is the same whichever way the picture is rotated,
+	// so orientation is applied to the small result rather than the upload.
+	b := src.Bounds()
+	side := min(b.Dx(), b.Dy())
+	x0, y0 := b.Min.X+(b.Dx()-side)/2, b.Min.Y+(b.Dy()-side)/2
+	square := image.Rect(x0, y0, x0+side, y0+side)
+
+	out := make([]Thumbnail, 0, len(Sizes))
+	opaque := true
+	scaled := make([]*image.RGBA, len(Sizes))
+	for i, n := range Sizes {
+		dst := image.NewRGBA(image.Rect(0, 0, n, n))
+		xdraw.CatmullRom.Scale(dst, dst.Bounds(), src, square, xdraw.Src, nil)
+		scaled[i] = orient(dst, orientation)
+		opaque = opaque && scaled[i].Opaque()
+	}
+	for i, img := range scaled {
+		var buf bytes.Buffer
+		t := Thumbnail{Size: Sizes[i], ContentType: "image/png", Ext: "png"}
+		if opaque {
+			t.ContentType, t.Ext = "image/jpeg", "jpg"
+			err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85})
+		} else {
+			err = png.Encode(&buf, img)
+		}
+		if err != nil {
+			return nil, err
+		}
+		t.Data = buf.Bytes()
+		out = append(out, t)
+	}
+	return out, nil
+}
+
+func decoders(ct string) (func(r *bytes.Reader) (image.Config, error), func(r *bytes.Reader) (image.Image, error)) {
+	switch ct {
+	case "image/png":
+		return func(r *bytes.Reader) (image.Config, error) { return png.DecodeConfig(r) },
+			func(r *bytes.Reader) (image.Image, error) { return png.Decode(r) }
+	case "image/jpeg":
+		return func(r *bytes.Reader) (image.Config, error) { return jpeg.DecodeConfig(r) },
+			func(r *bytes.Reader) (image.Image, error) { return jpeg.Decode(r) }
+	case "image/webp":
+		return func(r *bytes.Reader) (image.Config, error) { return webp.DecodeConfig(r) },
+			func(r *bytes.Reader) (image.Image, error) { return webp.Decode(r) }
+	}
+	return nil, nil
+}
+
+// orient turns a square image upright given its EXIF orientation (1-8); the
+// comments say how the stored picture is turned.
+func orient(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+	n := src.Bounds().Dx() - 1
+	dst := image.NewRGBA(src.Bounds())
+	for y := 0; y <= n; y++ {
+		for x := 0; x <= n; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // mirrored
+				sx, sy = n-x, y
+			case 3: // upside down
+				sx, sy = n-x, n-y
+			case 4: // mirrored upside down
+				sx, sy = x, n-y
+			case 5: // mirrored, rotated 90° counter-clockwise
+				sx, sy = y, x
+			case 6: // rotated 90° counter-clockwise
+				sx, sy = y, n-x
+			case 7: // mirrored, rotated 90° clockwise
+				sx, sy = n-y, n-x
+			case 8: // rotated 90° clockwise
+				sx, sy = n-y, x
+			}
+			dst.SetRGBA(x, y, src.RGBAAt(sx, sy))
+		}
+	}
+	return dst
+}
+
+// jpegOrientation reads the EXIF orientation tag from the JPEG's APP1
+// segment, returning 1 (as stored) when there is none.
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	for i := 2; i+4 <= len(data) && data[i] == 0xFF; {
+		marker := data[i+1]
+		length := int(data[i+2])<<8 | int(data[i+3])
+		if marker == 0xDA || length < 2 || i+2+length > len(data) {
+			break // start of scan: metadata comes before it
+		}
+		seg := data[i+4 : i+2+length]
+		if marker == 0xE1 && bytes.HasPrefix(seg, []byte("Exif\x00\x00")) {
+			return tiffOrientation(seg[6:])
+		}
+		i += 2 + length
+	}
+	return 1
+}
+
+func tiffOrientation(t []byte) int {
+	if len(t) < 8 {
+		return 1
+	}
+	u16 := func(b []byte) int { return int(b[0]) | int(b[1])<<8 }
+	u32 := func(b []byte) int { return int(b[0]) | int(b[1])<<8 | int(b[2])<<16 | int(b[3])<<24 }
+	switch string(t[:2]) {
+	case "II":
+	case "MM":
+		u16 = func(b []byte) int { return int(b[0])<<8 | int(b[1]) }
+		u32 = func(b []byte) int { return int(b[0])<<24 | int(b[1])<<16 | int(b[2])<<8 | int(b[3]) }
+	default:
+		return 1
+	}
+	ifd := u32(t[4:8])
+	if ifd < 8 || ifd+2 > len(t) {
+		return 1
+	}
+	entries := u16(t[ifd:])
+	for e := 0; e < entries; e++ {
+		off := ifd + 2 + e*12
+		if off+12 > len(t) {
+			break
+		}
+		// Orientation is tag 0x0112, a SHORT stored in the value field
+		if u16(t[off:]) == 0x0112 && u16(t[off+2:]) == 3 {
+			if v := u16(t[off+8:]); v >= 1 && v <= 8 {
+				return v
+			}
+		}
+	}
+	return 1
+}
@@ -4,14 +4,26 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/joho/godotenv"
 )
 
 type Config struct {
-	Port int
-	DB   DBConfig
-	JWT  JWTConfig
+	Port      int
+	BaseURL   string // public URL of this API, used in download and media links
+	AppURL    string // web app that opens the links in emails and posts their tokens to the API
+	DB        DBConfig
+	JWT       JWTConfig
+	Authz     AuthzConfig
+	Mail      MailConfig
+	Orgs      OrgsConfig
+	Reg       RegistrationConfig
+	Retention RetentionConfig
+	Audit     AuditConfig
+	Exports   ExportsConfig
+	Users     UsersConfig
+	Storage   StorageConfig
 }
 
 type DBConfig struct {
@@ -27,8 +39,98 @@ type DBConfig struct {
 }
 
 type JWTConfig struct {
-	Secret         string
-	ExpiresInHours int
+	Secret              string
+	ExpiresInHours      int
+	ReauthMaxAgeMinutes int // step-up window for sensitive operations
+}
+
+type AuthzConfig struct {
+	PolicyFile   string // JSON policy; empty uses the built-in default
+	LogDecisions bool
+}
+
+type MailConfig struct {
+	Driver       string // log|smtp
+	From         string
+	DevDir       string // log driver: also write .eml files here
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUser     string
+	SMTPPassword string
+}
+
+type OrgsConfig struct {
+	InviteExpiresInHours int
+}
+
+// Registration modes for POST /auth/register.
+const (
+	RegistrationDisabled = "disabled"
+	RegistrationOpen     = "open"
+	RegistrationInvite   = "invite"   // needs an org invitation or a registration code
+	RegistrationDomain   = "domain"   // email domain must be in AllowedDomains
+	RegistrationApproval = "approval" // account stays pending until an admin approves it
+)
+
+type RegistrationConfig struct {
+	Mode           string
+	AllowedDomains []string
+	DeniedDomains  []string // rejected in every mode
+}
+
+type RetentionConfig struct {
+	DeletedUserDays      int // soft-deleted users are purged after this many days
+	PurgeIntervalMinutes int
+	// DeletionGraceDays is how long a self-service deletion can be cancelled by logging in.
+	DeletionGraceDays int
+}
+
+type UsersConfig struct {
+	RequireIfMatch bool // PUT, PATCH and DELETE on /users/{id} must send If-Match
+	// PasswordSetupHours is how long the set-password link for admin-created users works.
+	PasswordSetupHours int
+	// EmailVerificationHours is how long the link confirming a user's email address works.
+	EmailVerificationHours int
+	// Imports larger than ImportSyncRows run in the background; none may exceed ImportMaxRows or ImportMaxMB.
+	ImportSyncRows       int
+	ImportMaxRows        int
+	ImportMaxMB          int
+	ImportRetentionHours int // import reports are deleted after this
+	AvatarMaxMB          int // largest accepted avatar upload
+	// LegacyPhoneRegion is the ISO country used to read phone numbers saved
+	// as free text without a country code; empty leaves those for users to re-enter.
+	LegacyPhoneRegion string
+	// ReservedUsernames can't be registered or taken by renaming; setting
+	// USER_RESERVED_USERNAMES replaces DefaultReservedUsernames.
+	ReservedUsernames []string
+}
+
+// DefaultReservedUsernames are names users could mistake for the service or its staff.
+var DefaultReservedUsernames = []string{
+	"admin", "administrator", "root", "superuser", "system", "support", "help", "security", "abuse",
+	"postmaster", "webmaster", "hostmaster", "noreply", "no-reply", "api", "www", "mail", "ftp",
+}
+
+type StorageConfig struct {
+	Driver   string // local|s3
+	LocalDir string // local driver: files are kept here and served under /media
+	// S3-compatible object storage; S3PublicURL is where browsers read the
+	// bucket, e.g. a CDN, and defaults to the object URL on S3Endpoint.
+	S3Endpoint  string
+	S3Region    string
+	S3Bucket    string
+	S3AccessKey string
+	S3SecretKey string
+	S3PublicURL string
+}
+
+type ExportsConfig struct {
+	URLTTLMinutes  int // lifetime of signed download URLs
+	RetentionHours int // finished archives are deleted after this
+}
+
+type AuditConfig struct {
+	SigningKey string // HMAC key for audit entries; defaults to the JWT secret
 }
 
 func Load() (*Config, error) {
@@ -37,6 +139,8 @@ func Load() (*Config, error) {
 	cfg := &Config{}
 
 	cfg.Port = getInt("PORT", 8080)
+	cfg.BaseURL = getStr("BASE_URL", fmt.Sprintf("http://localhost:%d", cfg.Port))
+	cfg.AppURL = getStr("APP_URL", "http://localhost:3000")
 
 	cfg.DB = DBConfig{
 		Host:        getStr("DB_HOST", "localhost"),
@@ -51,8 +155,81 @@ func Load() (*Config, error) {
 	}
 
 	cfg.JWT = JWTConfig{
-		Secret:         getStr("JWT_SECRET", "change_me_super_secret"),
-		ExpiresInHours: getInt("JWT_EXPIRES_IN_HOURS", 24),
+		Secret:              getStr("JWT_SECRET", "change_me_super_secret"),
+		ExpiresInHours:      getInt("JWT_EXPIRES_IN_HOURS", 24),
+		ReauthMaxAgeMinutes: getInt("JWT_REAUTH_MAX_AGE_MINUTES", 10),
+	}
+
+	cfg.Authz = AuthzConfig{
+		PolicyFile:   getStr("AUTHZ_POLICY_FILE", ""),
+		LogDecisions: getBool("AUTHZ_LOG_DECISIONS", false),
+	}
+
+	cfg.Mail = MailConfig{
+		Driver:       getStr("MAIL_DRIVER", "log"),
+		From:         getStr("MAIL_FROM", "no-reply@example.com"),
+		DevDir:       getStr("MAIL_DEV_DIR", ""),
+		SMTPHost:     getStr("MAIL_SMTP_HOST", ""),
+		SMTPPort:     getInt("MAIL_SMTP_PORT", 587),
+		SMTPUser:     getStr("MAIL_SMTP_USER", ""),
+		SMTPPassword: getStr("MAIL_SMTP_PASSWORD", ""),
+	}
+
+	cfg.Orgs = OrgsConfig{
+		InviteExpiresInHours: getInt("ORG_INVITE_EXPIRES_IN_HOURS", 72),
+	}
+
+	cfg.Reg = RegistrationConfig{
+		Mode:           getStr("REGISTRATION_MODE", RegistrationOpen),
+		AllowedDomains: getList("REGISTRATION_ALLOWED_DOMAINS"),
+		DeniedDomains:  getList("REGISTRATION_DENIED_DOMAINS"),
+	}
+	switch cfg.Reg.Mode {
+	case RegistrationDisabled, RegistrationOpen, RegistrationInvite, RegistrationDomain, RegistrationApproval:
+	default:
+		return nil, fmt.Errorf("unknown REGISTRATION_MODE %q", cfg.Reg.Mode)
+	}
+
+	cfg.Retention = RetentionConfig{
+		DeletedUserDays:      getInt("DELETED_USER_RETENTION_DAYS", 30),
+		PurgeIntervalMinutes: getInt("PURGE_INTERVAL_MINUTES", 60),
+		DeletionGraceDays:    getInt("ACCOUNT_DELETION_GRACE_DAYS", 14),
+	}
+
+	cfg.Users = UsersConfig{
+		RequireIfMatch:         getBool("USERS_REQUIRE_IF_MATCH", false),
+		PasswordSetupHours:     getInt("USER_PASSWORD_SETUP_TTL_HOURS", 72),
+		EmailVerificationHours: getInt("USER_EMAIL_VERIFICATION_TTL_HOURS", 48),
+		ImportSyncRows:         getInt("USER_IMPORT_SYNC_ROWS", 500),
+		ImportMaxRows:          getInt("USER_IMPORT_MAX_ROWS", 100000),
+		ImportMaxMB:            getInt("USER_IMPORT_MAX_MB", 32),
+		ImportRetentionHours:   getInt("USER_IMPORT_RETENTION_HOURS", 168),
+		AvatarMaxMB:            getInt("USER_AVATAR_MAX_MB", 5),
+		LegacyPhoneRegion:      strings.ToUpper(getStr("USER_LEGACY_PHONE_REGION", "")),
+		ReservedUsernames:      getList("USER_RESERVED_USERNAMES"),
+	}
+	if cfg.Users.ReservedUsernames == nil {
+		cfg.Users.ReservedUsernames = DefaultReservedUsernames
+	}
+
+	cfg.Storage = StorageConfig{
+		Driver:      getStr("STORAGE_DRIVER", "local"),
+		LocalDir:    getStr("STORAGE_LOCAL_DIR", "./data/blobs"),
+		S3Endpoint:  getStr("STORAGE_S3_ENDPOINT", ""),
+		S3Region:    getStr("STORAGE_S3_REGION", "us-east-1"),
+		S3Bucket:    getStr("STORAGE_S3_BUCKET", ""),
+		S3AccessKey: getStr("STORAGE_S3_ACCESS_KEY", ""),
+		S3SecretKey: getStr("STORAGE_S3_SECRET_KEY", ""),
+		S3PublicURL: getStr("STORAGE_S3_PUBLIC_URL", ""),
+	}
+
+	cfg.Exports = ExportsConfig{
+		URLTTLMinutes:  getInt("EXPORT_URL_TTL_MINUTES", 15),
+		RetentionHours: getInt("EXPORT_RETENTION_HOURS", 24),
+	}
+
+	cfg.Audit = AuditConfig{
+		SigningKey: getStr("AUDIT_SIGNING_KEY", cfg.JWT.Secret),
 	}
 
 	return cfg, nil
@@ -92,3 +269,23 @@ func getInt(key string, def int) int {
 	}
 	return def
 }
+
+func getBool(key string, def bool) bool {
+	if v := os.Getenv(key); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b
+		}
+	}
+	return def
+}
+
+// getList reads a comma-separated list, lowercased and trimmed.
+func getList(key string) []string {
+	var out []string
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.ToLower(strings.TrimSpace(v)); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,109 @@
+// Package confusables finds usernames that look alike, after Unicode
+// Technical Standard #39. A name's skeleton maps characters that are easily
+// mistaken for one another to a common form; two names with the same
+// skeleton are confusable. The table is the part of Unicode's
+// confusables.txt that matters for names made of letters and digits: ASCII
+// lookalikes and the Cyrillic and Greek letters that pass for Latin ones.
+package confusables
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+var table = map[rune]string{
+	// ASCII
+	'0': "o", '1': "l", 'I': "l",
+	// Cyrillic
+	'а': "a", 'в': "b", 'е': "e", 'ё': "ë", 'і': "i", 'ї': "ï", 'ј': "j", 'к': "k", 'м': "m", 'н': "h",
+	'о': "o", 'р': "p", 'с': "c", 'т': "t", 'у': "y", 'х': "x", 'ѕ': "s", 'ԁ': "d", 'һ': "h", 'ӏ': "l",
+	'ԛ': "q", 'ԝ': "w", 'ү': "y",
+	'А': "A", 'В': "B", 'Е': "E", 'Ё': "Ë", 'І': "l", 'Ї': "Ï", 'Ј': "J", 'К': "K", 'М': "M", 'Н': "H",
+	'О': "O", 'Р': "P", 'С': "C", 'Т': "T", 'У': "Y", 'Х': "X", 'Ѕ': "S", 'Ԁ': "D", 'Һ': "H", 'Ӏ': "l",
+	'Ԛ': "Q", 'Ԝ': "W", 'Ү': "Y",
+	// Greek
+	'α': "a", 'β': "ß", 'γ': "y", 'ι': "i", 'κ': "k", 'ν': "v", 'ο': "o", 'ρ': "p", 'σ': "o", 'τ': "t",
+	'υ': "u", 'χ': "x",
+	'Α': "A", 'Β': "B", 'Ε': "E", 'Ζ': "Z", 'Η': "H", 'Ι': "l", 'Κ': "K", 'Μ': "M", 'Ν': "N", 'Ο': "O",
+	'Ρ': "P", 'Τ': "T", 'Υ': "Y", 'Χ': "X",
+}
+
+// Skeleton returns the form s is compared in: NFKC, lookalikes replaced,
+// lower-cased, and "rn" read as "m". For ASCII names it equals
+// replace(lower(translate(s, '01I', 'oll')), 'rn', 'm') in SQL, which the
+// migration used for existing users.
+func Skeleton(s string) string {
+	return strings.ReplaceAll(Fold(s), "rn", "m")
+}
+
+// Fold is Skeleton without reading "rn" as "m", for matching parts of names:
+// a word containing "rn" shouldn't match every name with an "m".
+func Fold(s string) string {
+	var b strings.Builder
+	for _, r := range norm.NFKC.String(s) {
+		if m, ok := table[r]; ok {
+			b.WriteString(m)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return strings.ToLower(b.String())
+}
+
+// scripts are those names are commonly written in; letters from any other
+// script count as one script of their own.
+var scripts = map[string]*unicode.RangeTable{
+	"Latin": unicode.Latin, "Greek": unicode.Greek, "Cyrillic": unicode.Cyrillic, "Armenian": unicode.Armenian,
+	"Georgian": unicode.Georgian, "Hebrew": unicode.Hebrew, "Arabic": unicode.Arabic, "Devanagari": unicode.Devanagari,
+	"Bengali": unicode.Bengali, "Tamil": unicode.Tamil, "Thai": unicode.Thai, "Han": unicode.Han,
+	"Hiragana": unicode.Hiragana, "Katakana": unicode.Katakana, "Hangul": unicode.Hangul, "Bopomofo": unicode.Bopomofo,
+}
+
+// allowedMixes are the script combinations written together, as UTS #39's
+// highly restrictive level allows them. Latin may join each.
+var allowedMixes = []map[string]bool{
+	{"Han": true, "Hiragana": true, "Katakana": true},
+	{"Han": true, "Hangul": true},
+	{"Han": true, "Bopomofo": true},
+}
+
+// MixedScript reports whether the letters of s come from scripts that are
+// not written together, such as a Latin name with one Cyrillic letter.
+// Digits and punctuation belong to every script.
+func MixedScript(s string) bool {
+	used := map[string]bool{}
+	for _, r := range s {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		name := "Other"
+		for n, t := range scripts {
+			if unicode.Is(t, r) {
+				name = n
+				break
+			}
+		}
+		used[name] = true
+	}
+	if len(used) <= 1 {
+		return false
+	}
+	delete(used, "Latin")
+	return !mixable(used)
+}
+
+// mixable reports whether the non-Latin scripts are all part of one allowed mix.
+func mixable(used map[string]bool) bool {
+	for _, mix := range allowedMixes {
+		ok := true
+		for n := range used {
+			ok = ok && mix[n]
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,189 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"time"
+
+	"dev.mfr/go-chi-sqlc-auth/internal/audit"
+	"dev.mfr/go-chi-sqlc-auth/internal/models"
+	"dev.mfr/go-chi-sqlc-auth/internal/store"
+)
+
+// notCollected explains the categories a data subject might expect but this
+// service does not store.
+const notCollected = "This service keeps no server-side sessions, login history or linked identities."
+
+// Archive is everything the service holds about one user. The password hash
+// is deliberately left out.
+type Archive struct {
+	GeneratedAt   time.Time             `json:"generated_at"`
+	User          exportUser            `json:"user"`
+	Roles         []models.Role         `json:"roles"`
+	AdminScopes   []string              `json:"admin_scopes"`
+	Organizations []exportMembership    `json:"organizations"`
+	Invitations   []exportInvitation    `json:"invitations"`
+	Attributes    map[string]any        `json:"attributes"`
+	Preferences   models.Preferences    `json:"preferences"`
+	StatusHistory []models.StatusChange `json:"status_history"`
+	AuditLog      []audit.Entry         `json:"audit_log"`
+	Notes         []string              `json:"notes"`
+}
+
+type exportUser struct {
+	ID                  string            `json:"id"`
+	Username            string            `json:"username"`
+	Email               string            `json:"email"`
+	FirstName           string            `json:"first_name"`
+	LastName            string            `json:"last_name"`
+	Phone               *models.Phone     `json:"phone"`
+	Address             *models.Address   `json:"address"`
+	LegacyPhoneNumber   *string           `json:"legacy_phone_number,omitempty"`
+	LegacyAddress       *string           `json:"legacy_address,omitempty"`
+	AvatarURL           *string           `json:"avatar_url"`
+	Status              models.UserStatus `json:"status"`
+	StatusReason        string            `json:"status_reason"`
+	StatusChangedAt     time.Time         `json:"status_changed_at"`
+	DeletionScheduledAt *time.Time        `json:"deletion_scheduled_at"`
+	CreatedAt           time.Time         `json:"created_at"`
+	UpdatedAt           time.Time         `json:"updated_at"`
+}
+
+type exportMembership struct {
+	OrgID    string         `json:"org_id"`
+	Name     string         `json:"name"`
+	Slug     string         `json:"slug"`
+	OrgRole  models.OrgRole `json:"org_role"`
+	JoinedAt time.Time      `json:"joined_at"`
+}
+
+type exportInvitation struct {
+	OrgID      string         `json:"org_id"`
+	OrgRole    models.OrgRole `json:"org_role"`
+	CreatedAt  time.Time      `json:"created_at"`
+	ExpiresAt  time.Time      `json:"expires_at"`
+	AcceptedAt *time.Time     `json:"accepted_at"`
+	RevokedAt  *time.Time     `json:"revoked_at"`
+}
+
+// Collect assembles the archive for userID. It returns pgx.ErrNoRows when the user does not exist.
+func Collect(ctx context.Context, db store.DBTX, userID string) (*Archive, error) {
+	a := &Archive{GeneratedAt: time.Now().UTC(), Notes: []string{notCollected}}
+	u := &a.User
+	err := db.QueryRow(ctx, `SELECT id, username, email, first_name, last_name,
+			CASE WHEN phone_number IS NOT NULL THEN jsonb_build_object('number', phone_number, 'region', COALESCE(phone_region, ''),
+				'verified', phone_verified_at IS NOT NULL, 'verified_at', phone_verified_at) END,
+			address, legacy_phone_number, legacy_address, avatar_url,
+			status, status_reason, status_changed_at, deletion_scheduled_at, created_at, updated_at
+		FROM users WHERE id=$1`, userID).Scan(&u.ID, &u.Username, &u.Email, &u.FirstName, &u.LastName, &u.Phone, &u.Address,
+		&u.LegacyPhoneNumber, &u.LegacyAddress, &u.AvatarURL,
+		&u.Status, &u.StatusReason, &u.StatusChangedAt, &u.DeletionScheduledAt, &u.CreatedAt, &u.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if a.Roles, err = store.UserRoles(ctx, db, userID); err != nil {
+		return nil, err
+	}
+	if a.AdminScopes, err = store.AdminScopes(ctx, db, userID); err != nil {
+		return nil, err
+	}
+	if a.StatusHistory, err = store.StatusHistory(ctx, db, userID); err != nil {
+		return nil, err
+	}
+	if a.AuditLog, err = audit.ForUser(ctx, db, userID); err != nil {
+		return nil, err
+	}
+	// Every attribute is the user's own data, whatever its visibility
+	if a.Attributes, err = store.UserAttributes(ctx, db, userID); err != nil {
+		return nil, err
+	}
+	if a.Preferences, err = store.UserPreferences(ctx, db, userID); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(ctx, `SELECT o.id, o.name, o.slug, m.org_role, m.created_at
+		FROM memberships m JOIN organizations o ON o.id = m.org_id
+		WHERE m.user_id=$1 ORDER BY m.created_at`, userID)
+	if err != nil {
+		return nil, err
+	}
+	a.Organizations = []exportMembership{}
+	for rows.Next() {
+		var m exportMembership
+		if err := rows.Scan(&m.OrgID, &m.Name, &m.Slug, &m.OrgRole, &m.JoinedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		a.Organizations = append(a.Organizations, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.Query(ctx, `SELECT org_id, org_role, created_at, expires_at, accepted_at, revoked_at
+		FROM org_invitations WHERE lower(email)=lower($1) OR accepted_by=$2 ORDER BY created_at`, u.Email, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	a.Invitations = []exportInvitation{}
+	for rows.Next() {
+		var inv exportInvitation
+		if err := rows.Scan(&inv.OrgID, &inv.OrgRole, &inv.CreatedAt, &inv.ExpiresAt, &inv.AcceptedAt, &inv.RevokedAt); err != nil {
+			return nil, err
+		}
+		a.Invitations = append(a.Invitations, inv)
+	}
+	return a, rows.Err()
+}
+
+// Encode renders the archive as a single JSON document or as a ZIP with one
+// JSON file per section.
+func (a *Archive) Encode(format models.ExportFormat) ([]byte, error) {
+	if format == models.ExportJSON {
+		return json.MarshalIndent(a, "", "  ")
+	}
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	files := []struct {
+		name string
+		v    any
+	}{
+		{"user.json", a.User},
+		{"roles.json", a.Roles},
+		{"admin_scopes.json", a.AdminScopes},
+		{"organizations.json", a.Organizations},
+		{"invitations.json", a.Invitations},
+		{"attributes.json", a.Attributes},
+		{"preferences.json", a.Preferences},
+		{"status_history.json", a.StatusHistory},
+		{"audit_log.json", a.AuditLog},
+	}
+	for _, f := range files {
+		b, err := json.MarshalIndent(f.v, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: f.name, Method: zip.Deflate, Modified: a.GeneratedAt})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(b); err != nil {
+			return nil, err
+		}
+	}
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "README.txt", Method: zip.Deflate, Modified: a.GeneratedAt})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write([]byte("Personal data export generated " + a.GeneratedAt.Format(time.RFC3339) + ".\n" + notCollected + "\n")); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,142 @@
+package export
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"time"
+
+	"dev.mfr/go-chi-sqlc-auth/internal/models"
+	"dev.mfr/go-chi-sqlc-auth/internal/store"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	ErrInvalidLink = errors.New("invalid download link")
+	ErrLinkExpired = errors.New("download link expired")
+)
+
+// generateTimeout bounds the background work for one export.
+const generateTimeout = 5 * time.Minute
+
+// Service creates exports in the background and hands them out through
+// short-lived signed download URLs.
+type Service struct {
+	Pool    *pgxpool.Pool
+	Secret  []byte
+	BaseURL string
+	// URLTTL is how long a download URL stays valid.
+	URLTTL time.Duration
+	// Retention is how long a finished archive is kept.
+	Retention time.Duration
+}
+
+func New(pool *pgxpool.Pool, secret []byte, baseURL string, urlTTL, retention time.Duration) *Service {
+	return &Service{Pool: pool, Secret: secret, BaseURL: baseURL, URLTTL: urlTTL, Retention: retention}
+}
+
+const exportColumns = "id, user_id, requested_by, format, status, error, created_at, completed_at, expires_at"
+
+func scanExport(row pgx.Row, e *models.DataExport) error {
+	return row.Scan(&e.ID, &e.UserID, &e.RequestedBy, &e.Format, &e.Status, &e.Error, &e.CreatedAt, &e.CompletedAt, &e.ExpiresAt)
+}
+
+// Request records an export of userID's data and starts generating it.
+func (s *Service) Request(ctx context.Context, db store.DBTX, userID, requestedBy string, format models.ExportFormat) (models.DataExport, error) {
+	var e models.DataExport
+	err := scanExport(db.QueryRow(ctx, `INSERT INTO data_exports (user_id, requested_by, format, expires_at)
+		VALUES ($1, NULLIF($2, '')::uuid, $3, $4) RETURNING `+exportColumns, userID, requestedBy, format, time.Now().Add(s.Retention)), &e)
+	return e, err
+}
+
+// Start generates the export in the background. Call it after the row from
+// Request is committed.
+func (s *Service) Start(e models.DataExport) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), generateTimeout)
+		defer cancel()
+		if err := s.generate(ctx, e); err != nil {
+			log.Printf("export %s: %v", e.ID, err)
+			if _, err := s.Pool.Exec(ctx, "UPDATE data_exports SET status=$2, error=$3, completed_at=now() WHERE id=$1",
+				e.ID, models.ExportFailed, err.Error()); err != nil {
+				log.Printf("export %s: %v", e.ID, err)
+			}
+		}
+	}()
+}
+
+func (s *Service) generate(ctx context.Context, e models.DataExport) error {
+	a, err := Collect(ctx, s.Pool, e.UserID)
+	if err != nil {
+		return err
+	}
+	content, err := a.Encode(e.Format)
+	if err != nil {
+		return err
+	}
+	_, err = s.Pool.Exec(ctx, "UPDATE data_exports SET status=$2, content=$3, completed_at=now() WHERE id=$1",
+		e.ID, models.ExportReady, content)
+	return err
+}
+
+// Get loads an export of userID, with a fresh download URL when it is ready.
+// It returns pgx.ErrNoRows when there is no such export or it has expired.
+func (s *Service) Get(ctx context.Context, userID, id string) (models.DataExport, error) {
+	var e models.DataExport
+	err := scanExport(s.Pool.QueryRow(ctx, "SELECT "+exportColumns+" FROM data_exports WHERE id=$1 AND user_id=$2 AND expires_at > now()", id, userID), &e)
+	if err != nil {
+		return e, err
+	}
+	if e.Status == models.ExportReady {
+		e.DownloadURL = s.SignedURL(e.ID, time.Now().Add(s.URLTTL))
+	}
+	return e, nil
+}
+
+// SignedURL returns a download URL for the export that works without a token until expires.
+func (s *Service) SignedURL(id string, expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	q := url.Values{"expires": {exp}, "sig": {s.sign(id, exp)}}
+	return fmt.Sprintf("%s/exports/%s/download?%s", s.BaseURL, url.PathEscape(id), q.Encode())
+}
+
+func (s *Service) sign(id, expires string) string {
+	mac := hmac.New(sha256.New, s.Secret)
+	fmt.Fprintf(mac, "export\n%s\n%s", id, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Download checks a signed URL's parameters and returns the archive.
+func (s *Service) Download(ctx context.Context, id, expires, sig string) (models.ExportFormat, []byte, error) {
+	if !hmac.Equal([]byte(sig), []byte(s.sign(id, expires))) {
+		return "", nil, ErrInvalidLink
+	}
+	exp, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return "", nil, ErrInvalidLink
+	}
+	if time.Now().Unix() > exp {
+		return "", nil, ErrLinkExpired
+	}
+	var format models.ExportFormat
+	var content []byte
+	err = s.Pool.QueryRow(ctx, "SELECT format, content FROM data_exports WHERE id=$1 AND status=$2 AND expires_at > now()",
+		id, models.ExportReady).Scan(&format, &content)
+	return format, content, err
+}
+
+// PurgeExpired deletes exports past their retention and returns how many were removed.
+func PurgeExpired(ctx context.Context, db store.DBTX, now time.Time) (int64, error) {
+	ct, err := db.Exec(ctx, "DELETE FROM data_exports WHERE expires_at <= $1", now)
+	if err != nil {
+		return 0, err
+	}
+	return ct.RowsAffected(), nil
+}
@@ -2,36 +2,67 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strings"
 	"time"
 
+	"dev.mfr/go-chi-sqlc-auth/internal/audit"
 	"dev.mfr/go-chi-sqlc-auth/internal/auth"
+	"dev.mfr/go-chi-sqlc-auth/internal/config"
+	"dev.mfr/go-chi-sqlc-auth/internal/confusables"
 	"dev.mfr/go-chi-sqlc-auth/internal/httpx"
+	"dev.mfr/go-chi-sqlc-auth/internal/mailer"
 	"dev.mfr/go-chi-sqlc-auth/internal/middleware"
 	"dev.mfr/go-chi-sqlc-auth/internal/models"
+	"dev.mfr/go-chi-sqlc-auth/internal/store"
+	"dev.mfr/go-chi-sqlc-auth/internal/usernames"
+	"dev.mfr/go-chi-sqlc-auth/internal/validate"
 	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type AuthHandler struct {
-	Pool   *pgxpool.Pool
-	Issuer auth.JWTIssuer
+	Pool         *pgxpool.Pool
+	Store        *store.Store
+	Issuer       auth.JWTIssuer
+	Registration config.RegistrationConfig
+	Audit        *audit.Logger
+	ReauthMaxAge time.Duration
+	// DeletionGrace is how long after DELETE /auth/me the account is erased.
+	DeletionGrace time.Duration
+	Usernames     *usernames.Policy
+	Mailer        mailer.Mailer
+	// AppURL prefixes the verification links in emails.
+	AppURL string
+	// EmailVerificationTTL is how long the link confirming a new user's address works.
+	EmailVerificationTTL time.Duration
 }
 
-func NewAuthHandler(pool *pgxpool.Pool, issuer auth.JWTIssuer) *AuthHandler {
-	return &AuthHandler{Pool: pool, Issuer: issuer}
+func NewAuthHandler(pool *pgxpool.Pool, issuer auth.JWTIssuer, reg config.RegistrationConfig, al *audit.Logger, mail mailer.Mailer, appURL string,
+	reauthMaxAge, deletionGrace, emailVerificationTTL time.Duration, names *usernames.Policy) *AuthHandler {
+	return &AuthHandler{Pool: pool, Store: store.New(pool), Issuer: issuer, Registration: reg, Audit: al, ReauthMaxAge: reauthMaxAge,
+		DeletionGrace: deletionGrace, Usernames: names, Mailer: mail, AppURL: appURL, EmailVerificationTTL: emailVerificationTTL}
 }
 
 func (h *AuthHandler) Routes() http.Handler {
 	r := chi.NewRouter()
 	r.Post("/register", h.Register)
 	r.Post("/login", h.Login)
+	r.Get("/username-availability", h.UsernameAvailability)
+	r.Post("/password/setup", h.SetupPassword)
+	r.Post("/email/verify", h.VerifyEmail)
 	r.Group(func(pr chi.Router) {
-		pr.Use(middleware.JWT(h.Issuer))
+		pr.Use(middleware.JWT(h.Issuer, h.Store))
 		pr.Get("/me", h.Me)
+		pr.Post("/email/verification", h.RequestEmailVerification)
+		pr.With(middleware.RequireRecentAuth(h.ReauthMaxAge)).Delete("/me", h.DeleteMe)
+		pr.Post("/reauthenticate", h.Reauthenticate)
+		pr.Post("/switch-org", h.SwitchOrg)
 	})
 	return r
 }
@@ -42,8 +73,20 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		httpx.Error(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	if strings.TrimSpace(req.Password) == "" {
-		httpx.Error(w, http.StatusBadRequest, "password required")
+	req.Username, req.Email = validate.NormalizeUsername(req.Username), validate.NormalizeEmail(req.Email)
+	err := validate.User(validate.UserFields{Username: req.Username, Email: req.Email, FirstName: req.FirstName,
+		LastName: req.LastName, Phone: req.Phone, Address: req.Address})
+	if err == nil {
+		err = validate.Password(req.Password)
+	}
+	if err != nil {
+		httpx.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !h.registrationAllowed(w, &req) {
+		return
+	}
+	if !usernameAllowed(w, r, h.Pool, h.Usernames, req.Username) {
 		return
 	}
 	ph, err := auth.HashPassword(req.Password)
@@ -51,16 +94,28 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		httpx.Error(w, http.StatusInternalServerError, "failed to hash password")
 		return
 	}
-	role := models.RoleUser
-	if req.Role != nil {
-		role = *req.Role
+	// Self-registered accounts always get the default role
+	roles := []models.Role{models.RoleUser}
+	status := models.StatusActive
+	if h.Registration.Mode == config.RegistrationApproval {
+		status = models.StatusPending
 	}
 
-	row := h.Pool.QueryRow(r.Context(),
-		`INSERT INTO users (username, email, password_hash, first_name, last_name, phone_number, address, role)
-         VALUES ($1,$2,$3,$4,$5,$6,$7,$8)
+	tx, err := h.Pool.Begin(r.Context())
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, "failed to begin transaction")
+		return
+	}
+	defer tx.Rollback(r.Context())
+	if !checkUsername(w, r, tx, "", req.Username) {
+		return
+	}
+	number, region := phoneColumns(req.Phone)
+	row := tx.QueryRow(r.Context(),
+		`INSERT INTO users (username, username_skeleton, email, password_hash, first_name, last_name, phone_number, phone_region, address, status)
+         VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10)
          RETURNING id, created_at, updated_at`,
-		req.Username, req.Email, ph, req.FirstName, req.LastName, req.PhoneNumber, req.Address, role,
+		req.Username, confusables.Skeleton(req.Username), req.Email, ph, req.FirstName, req.LastName, number, region, req.Address, status,
 	)
 	var id string
 	var createdAt, updatedAt time.Time
@@ -68,12 +123,123 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		httpx.Error(w, http.StatusBadRequest, parsePGError(err))
 		return
 	}
-	token, err := h.Issuer.Issue(id, role)
+	if err := store.SetUserRoles(r.Context(), tx, id, roles); err != nil {
+		httpx.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.InviteCode != nil {
+		err := store.ConsumeRegistrationCode(r.Context(), tx, auth.HashOpaqueToken(*req.InviteCode))
+		if err == store.ErrRegistrationCodeInvalid {
+			httpx.ErrorCode(w, http.StatusForbidden, "invalid_invite_code", err.Error())
+			return
+		}
+		if err != nil {
+			httpx.Error(w, http.StatusInternalServerError, "failed to check invite code")
+			return
+		}
+	}
+	// The invitation was mailed to the address, so accepting it proves the
+	// user owns it. Otherwise they verify it by email before joining
+	// organizations through their domain.
+	var orgID string
+	var verification *emailVerification
+	if req.InviteToken != nil {
+		orgID, err = store.AcceptInvitation(r.Context(), tx, auth.HashOpaqueToken(*req.InviteToken), id, req.Email)
+		if err == store.ErrInvitationInvalid || err == store.ErrInvitationEmailMismatch {
+			httpx.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err != nil {
+			httpx.Error(w, http.StatusInternalServerError, "failed to accept invitation")
+			return
+		}
+		if _, err := store.AutoJoinByDomain(r.Context(), tx, id); err != nil {
+			httpx.Error(w, http.StatusInternalServerError, "failed to join organization")
+			return
+		}
+	} else {
+		v, err := h.issueEmailVerification(r.Context(), tx, id, req.Email, req.FirstName)
+		if err != nil {
+			httpx.Error(w, http.StatusInternalServerError, "failed to create verification link")
+			return
+		}
+		verification = &v
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		httpx.Error(w, http.StatusInternalServerError, "failed to commit")
+		return
+	}
+	// The account exists either way, so a failed email is reported, not an error
+	var warning string
+	if verification != nil {
+		if err := h.sendEmailVerification(r.Context(), *verification); err != nil {
+			warning = "account created but verification email failed: " + err.Error()
+		}
+	}
+	if status == models.StatusPending {
+		resp := map[string]any{"id": id, "status": status}
+		if warning != "" {
+			resp["warning"] = warning
+		}
+		httpx.JSON(w, http.StatusAccepted, resp)
+		return
+	}
+	token, err := h.Issuer.Issue(id, roles, orgID)
 	if err != nil {
 		httpx.Error(w, http.StatusInternalServerError, "failed to issue token")
 		return
 	}
-	httpx.JSON(w, http.StatusCreated, models.AuthResponse{Token: token, Role: role})
+	httpx.JSON(w, http.StatusCreated, models.AuthResponse{Token: token, Roles: roles, OrgID: orgID, Warning: warning})
+}
+
+var errUsernameTaken = errors.New("username is already taken")
+
+// UsernameAvailability tells signup forms whether a username can be
+// registered, with the code registration would fail with. It needs no token.
+func (h *AuthHandler) UsernameAvailability(w http.ResponseWriter, r *http.Request) {
+	resp := models.UsernameAvailability{Username: validate.NormalizeUsername(r.URL.Query().Get("username"))}
+	if err := validate.Username(resp.Username); err != nil {
+		resp.Code, resp.Reason = "username_invalid", err.Error()
+		httpx.JSON(w, http.StatusOK, resp)
+		return
+	}
+	// Rolled back; the transaction is only for the lookalike check's lock
+	tx, err := h.Pool.Begin(r.Context())
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer tx.Rollback(r.Context())
+	err = h.Usernames.Check(r.Context(), tx, resp.Username)
+	if err == nil {
+		var taken bool
+		err = tx.QueryRow(r.Context(), "SELECT EXISTS (SELECT 1 FROM users WHERE username_key = lower(normalize($1, NFKC)))", resp.Username).Scan(&taken)
+		if taken {
+			err = errUsernameTaken
+		}
+	}
+	if err == nil {
+		err = store.CheckUsername(r.Context(), tx, "", resp.Username, confusables.Skeleton(resp.Username))
+	}
+	switch {
+	case err == nil:
+		resp.Available = true
+	case errors.Is(err, usernames.ErrReserved):
+		resp.Code = "username_reserved"
+	case errors.Is(err, usernames.ErrBlocked):
+		resp.Code = "username_blocked"
+	case errors.Is(err, errUsernameTaken):
+		resp.Code = "username_taken"
+	case errors.Is(err, store.ErrUsernameConfusable):
+		resp.Code = "username_confusable"
+	default:
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !resp.Available {
+		resp.Reason = err.Error()
+	}
+	httpx.JSON(w, http.StatusOK, resp)
 }
 
 func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
@@ -83,11 +249,18 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	var (
-		id   string
-		role string
-		hash string
+		id     string
+		hash   string
+		status models.UserStatus
 	)
-	err := h.Pool.QueryRow(r.Context(), "SELECT id, role, password_hash FROM users WHERE email=$1", req.Email).Scan(&id, &role, &hash)
+	login := req.Login
+	if login == "" {
+		login = req.Email
+	}
+	// Usernames can't contain '@' and emails must, so at most one user matches
+	err := h.Pool.QueryRow(r.Context(), `SELECT id, password_hash, status FROM users
+		WHERE (email_key = lower(normalize($1, NFKC)) OR username_key = lower(normalize($1, NFKC))) AND deleted_at IS NULL`,
+		strings.TrimSpace(login)).Scan(&id, &hash, &status)
 	if err == pgx.ErrNoRows {
 		httpx.Error(w, http.StatusUnauthorized, "invalid credentials")
 		return
@@ -100,12 +273,38 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		httpx.Error(w, http.StatusUnauthorized, "invalid credentials")
 		return
 	}
-	token, err := h.Issuer.Issue(id, models.Role(role))
+	if status != models.StatusActive {
+		middleware.AccountInactive(w, status)
+		return
+	}
+	// Logging in during the grace period cancels a self-service deletion
+	cancelled, err := store.CancelDeletion(r.Context(), h.Pool, id)
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, "query error")
+		return
+	}
+	if cancelled {
+		if _, err := h.Audit.Record(r.Context(), h.Pool, audit.Entry{ActorID: &id, Action: "user.deletion_cancelled", TargetType: "user", TargetID: id}); err != nil {
+			httpx.Error(w, http.StatusInternalServerError, "failed to write audit log")
+			return
+		}
+	}
+	roles, err := store.UserRoles(r.Context(), h.Pool, id)
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, "query error")
+		return
+	}
+	orgID, err := store.DefaultOrg(r.Context(), h.Pool, id)
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, "query error")
+		return
+	}
+	token, err := h.Issuer.Issue(id, roles, orgID)
 	if err != nil {
 		httpx.Error(w, http.StatusInternalServerError, "failed to issue token")
 		return
 	}
-	httpx.JSON(w, http.StatusOK, models.AuthResponse{Token: token, Role: models.Role(role)})
+	httpx.JSON(w, http.StatusOK, models.AuthResponse{Token: token, Roles: roles, OrgID: orgID})
 }
 
 func (h *AuthHandler) Me(w http.ResponseWriter, r *http.Request) {
@@ -115,21 +314,239 @@ func (h *AuthHandler) Me(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	var resp struct {
-		ID    string      `json:"id"`
-		Email string      `json:"email"`
-		Role  models.Role `json:"role"`
+		ID              string              `json:"id"`
+		Email           string              `json:"email"`
+		Roles           []models.Role       `json:"roles"`
+		Permissions     []models.Permission `json:"permissions"`
+		OrgID           string              `json:"org_id,omitempty"`
+		OrgRole         models.OrgRole      `json:"org_role,omitempty"`
+		EmailVerifiedAt *time.Time          `json:"email_verified_at"`
+		// DeletionScheduledAt is set while a DELETE /auth/me is pending
+		DeletionScheduledAt *time.Time `json:"deletion_scheduled_at,omitempty"`
 	}
-	err := h.Pool.QueryRow(r.Context(), "SELECT id, email, role FROM users WHERE id=$1", uid).Scan(&resp.ID, &resp.Email, &resp.Role)
+	err := h.Pool.QueryRow(r.Context(), "SELECT id, email, email_verified_at, deletion_scheduled_at FROM users WHERE id=$1 AND deleted_at IS NULL", uid).
+		Scan(&resp.ID, &resp.Email, &resp.EmailVerifiedAt, &resp.DeletionScheduledAt)
 	if err != nil {
 		httpx.Error(w, http.StatusNotFound, "user not found")
 		return
 	}
+	p := middleware.PrincipalFrom(r.Context())
+	resp.Roles, resp.Permissions = p.Roles, p.Permissions
+	resp.OrgID, resp.OrgRole = p.OrgID, p.OrgRole
 	httpx.JSON(w, http.StatusOK, resp)
 }
 
+// DeleteMe schedules erasure of the caller's account after the grace period.
+// Logging in before then cancels it; afterwards the personal data is erased.
+func (h *AuthHandler) DeleteMe(w http.ResponseWriter, r *http.Request) {
+	uid, _ := r.Context().Value(middleware.CtxUserID).(string)
+	at := time.Now().Add(h.DeletionGrace).UTC().Truncate(time.Second)
+	tx, err := h.Pool.Begin(r.Context())
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, "failed to begin transaction")
+		return
+	}
+	defer tx.Rollback(r.Context())
+	if err := store.ScheduleDeletion(r.Context(), tx, uid, at); err != nil {
+		httpx.Error(w, http.StatusInternalServerError, "failed to schedule deletion")
+		return
+	}
+	entry := audit.Entry{ActorID: &uid, Action: "user.deletion_scheduled", TargetType: "user", TargetID: uid,
+		Data: map[string]any{"scheduled_at": at.Format(time.RFC3339)}}
+	if _, err := h.Audit.Record(r.Context(), tx, entry); err != nil {
+		httpx.Error(w, http.StatusInternalServerError, "failed to write audit log")
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		httpx.Error(w, http.StatusInternalServerError, "failed to commit")
+		return
+	}
+	httpx.JSON(w, http.StatusAccepted, map[string]any{"deletion_scheduled_at": at})
+}
+
+// SetupPassword redeems the set-password link emailed to users created by an
+// admin. It needs no token; the user logs in afterwards.
+func (h *AuthHandler) SetupPassword(w http.ResponseWriter, r *http.Request) {
+	var req models.PasswordSetupRequest
+	if err := decodeJSON(r, &req); err != nil {
+		httpx.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := validate.Password(req.Password); err != nil {
+		httpx.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	ph, err := auth.HashPassword(req.Password)
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, "failed to hash password")
+		return
+	}
+	tx, err := h.Pool.Begin(r.Context())
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, "failed to begin transaction")
+		return
+	}
+	defer tx.Rollback(r.Context())
+	id, sameEmail, err := store.ConsumePasswordSetup(r.Context(), tx, auth.HashOpaqueToken(req.Token))
+	if err == store.ErrPasswordSetupInvalid {
+		httpx.ErrorCode(w, http.StatusBadRequest, "invalid_setup_token", err.Error())
+		return
+	}
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, "failed to check link")
+		return
+	}
+	if _, err := tx.Exec(r.Context(), "UPDATE users SET password_hash=$2, updated_at=now() WHERE id=$1", id, ph); err != nil {
+		httpx.Error(w, http.StatusInternalServerError, "failed to set password")
+		return
+	}
+	// Redeeming the link verifies the address it was mailed to, unless the
+	// email has changed since; the new one needs its own verification
+	if sameEmail {
+		if err := store.MarkEmailVerified(r.Context(), tx, id); err != nil {
+			httpx.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if _, err := store.AutoJoinByDomain(r.Context(), tx, id); err != nil {
+			httpx.Error(w, http.StatusInternalServerError, "failed to join organization")
+			return
+		}
+	}
+	if _, err := h.Audit.Record(r.Context(), tx, audit.Entry{ActorID: &id, Action: "user.password_set", TargetType: "user", TargetID: id}); err != nil {
+		httpx.Error(w, http.StatusInternalServerError, "failed to write audit log")
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		httpx.Error(w, http.StatusInternalServerError, "failed to commit")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Reauthenticate confirms the caller's password and re-issues their token with a fresh auth_time.
+func (h *AuthHandler) Reauthenticate(w http.ResponseWriter, r *http.Request) {
+	uid, _ := r.Context().Value(middleware.CtxUserID).(string)
+	var req models.ReauthenticateRequest
+	if err := decodeJSON(r, &req); err != nil {
+		httpx.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var hash string
+	err := h.Pool.QueryRow(r.Context(), "SELECT password_hash FROM users WHERE id=$1 AND deleted_at IS NULL", uid).Scan(&hash)
+	if err == pgx.ErrNoRows {
+		httpx.Error(w, http.StatusUnauthorized, "invalid credentials")
+		return
+	}
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, "query error")
+		return
+	}
+	if err := auth.CheckPassword(hash, req.Password); err != nil {
+		httpx.Error(w, http.StatusUnauthorized, "invalid credentials")
+		return
+	}
+	p := middleware.PrincipalFrom(r.Context())
+	token, err := h.Issuer.Issue(uid, p.Roles, p.OrgID)
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, "failed to issue token")
+		return
+	}
+	httpx.JSON(w, http.StatusOK, models.AuthResponse{Token: token, Roles: p.Roles, OrgID: p.OrgID})
+}
+
+// SwitchOrg re-issues the caller's token with another organization they belong to as the
+// active one. The original auth_time is kept so switching doesn't count as a fresh login.
+func (h *AuthHandler) SwitchOrg(w http.ResponseWriter, r *http.Request) {
+	var req models.SwitchOrgRequest
+	if err := decodeJSON(r, &req); err != nil {
+		httpx.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if _, err := uuid.Parse(req.OrgID); err != nil {
+		httpx.Error(w, http.StatusBadRequest, "invalid org id")
+		return
+	}
+	p := middleware.PrincipalFrom(r.Context())
+	_, err := store.MembershipRole(r.Context(), h.Pool, p.UserID, req.OrgID)
+	if err == pgx.ErrNoRows {
+		httpx.Error(w, http.StatusForbidden, "not a member of this organization")
+		return
+	}
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, "query error")
+		return
+	}
+	claims := auth.Claims{UserID: p.UserID, Roles: p.Roles, OrgID: req.OrgID, AMR: []string{auth.AMRPassword}}
+	if at, ok := r.Context().Value(middleware.CtxAuthTime).(time.Time); ok {
+		claims.AuthTime = jwt.NewNumericDate(at)
+	}
+	token, err := h.Issuer.IssueClaims(claims)
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, "failed to issue token")
+		return
+	}
+	httpx.JSON(w, http.StatusOK, models.AuthResponse{Token: token, Roles: p.Roles, OrgID: req.OrgID})
+}
+
+// registrationAllowed applies REGISTRATION_MODE and the domain lists. Invitation
+// tokens and codes are only checked for presence here; they are redeemed in the
+// registration transaction.
+func (h *AuthHandler) registrationAllowed(w http.ResponseWriter, req *models.CreateUserRequest) bool {
+	domain := ""
+	if at := strings.LastIndex(req.Email, "@"); at >= 0 {
+		domain = strings.ToLower(req.Email[at+1:])
+	}
+	for _, d := range h.Registration.DeniedDomains {
+		if d == domain {
+			httpx.ErrorCode(w, http.StatusForbidden, "email_domain_not_allowed", "registration is not allowed for this email domain")
+			return false
+		}
+	}
+	switch h.Registration.Mode {
+	case config.RegistrationDisabled:
+		httpx.ErrorCode(w, http.StatusForbidden, "registration_disabled", "registration is disabled")
+		return false
+	case config.RegistrationInvite:
+		if req.InviteToken == nil && req.InviteCode == nil {
+			httpx.ErrorCode(w, http.StatusForbidden, "invite_required", "registration requires an invitation or invite code")
+			return false
+		}
+	case config.RegistrationDomain:
+		for _, d := range h.Registration.AllowedDomains {
+			if d == domain {
+				return true
+			}
+		}
+		httpx.ErrorCode(w, http.StatusForbidden, "email_domain_not_allowed", "registration is not allowed for this email domain")
+		return false
+	}
+	return true
+}
+
 func decodeJSON(r *http.Request, v interface{}) error { return json.NewDecoder(r.Body).Decode(v) }
 
+// uniqueMessages explains violations of the unique indexes clients can run into.
+var uniqueMessages = map[string]string{
+	"idx_users_email_key":            "email is already in use",
+	"idx_users_username_key":         "username is already taken",
+	"username_blocklist_pattern_key": "pattern is already blocked",
+	"org_domains_pkey":               "domain is already claimed by this organization",
+	"idx_org_domains_verified":       "domain is verified by another organization",
+}
+
 // parsePGError trims common pgx errors to a simple message
 func parsePGError(err error) string {
+	var pe *pgconn.PgError
+	if isUniqueViolation(err) && errors.As(err, &pe) {
+		if msg, ok := uniqueMessages[pe.ConstraintName]; ok {
+			return msg
+		}
+	}
 	return err.Error()
 }
+
+// isUniqueViolation reports whether err is PostgreSQL's unique_violation.
+func isUniqueViolation(err error) bool {
+	var pe *pgconn.PgError
+	return errors.As(err, &pe) && pe.Code == "23505"
+}
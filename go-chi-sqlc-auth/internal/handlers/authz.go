@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"dev.mfr/go-chi-sqlc-auth/internal/authz"
+	"dev.mfr/go-chi-sqlc-auth/internal/httpx"
+	"dev.mfr/go-chi-sqlc-auth/internal/middleware"
+	"dev.mfr/go-chi-sqlc-auth/internal/models"
+	"dev.mfr/go-chi-sqlc-auth/internal/store"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type AuthzHandler struct {
+	Pool  *pgxpool.Pool
+	Authz *authz.Engine
+}
+
+func NewAuthzHandler(pool *pgxpool.Pool, az *authz.Engine) *AuthzHandler {
+	return &AuthzHandler{Pool: pool, Authz: az}
+}
+
+func (h *AuthzHandler) Routes() http.Handler {
+	r := chi.NewRouter()
+	r.Post("/check", h.Check)
+	return r
+}
+
+type authzCheckRequest struct {
+	Action   string         `json:"action"`
+	Resource authz.Resource `json:"resource"`
+}
+
+// Check evaluates the policy for the caller without performing the action.
+// Attributes are never taken from the client: users, organizations and roles
+// are loaded by id so the answer matches what the handlers decide.
+func (h *AuthzHandler) Check(w http.ResponseWriter, r *http.Request) {
+	var req authzCheckRequest
+	if err := decodeJSON(r, &req); err != nil {
+		httpx.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.Action == "" || req.Resource.Type == "" {
+		httpx.Error(w, http.StatusBadRequest, "action and resource.type required")
+		return
+	}
+	res, err := h.resource(r, req.Resource.Type, req.Resource.ID)
+	if errors.Is(err, pgx.ErrNoRows) || errors.Is(err, store.ErrUnknownRole) {
+		httpx.Error(w, http.StatusNotFound, "not found")
+		return
+	}
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	httpx.JSON(w, http.StatusOK, h.Authz.Check(r.Context(), req.Action, res))
+}
+
+// resource loads the attributes the handlers use for a resource of type typ.
+// Without an id, or for other types, the resource has no attributes.
+func (h *AuthzHandler) resource(r *http.Request, typ, id string) (authz.Resource, error) {
+	if id == "" {
+		return authz.Resource{Type: typ}, nil
+	}
+	// User and organization ids are UUIDs; anything else names nothing
+	if _, err := uuid.Parse(id); err != nil && (typ == "user" || typ == "org") {
+		return authz.Resource{}, pgx.ErrNoRows
+	}
+	switch typ {
+	case "user":
+		t, err := store.ManagedUser(r.Context(), h.Pool, id)
+		if err != nil {
+			return authz.Resource{}, err
+		}
+		return authz.UserResource(t), nil
+	case "org":
+		var exists bool
+		if err := h.Pool.QueryRow(r.Context(), "SELECT EXISTS (SELECT 1 FROM organizations WHERE id=$1)", id).Scan(&exists); err != nil {
+			return authz.Resource{}, err
+		}
+		if !exists {
+			return authz.Resource{}, pgx.ErrNoRows
+		}
+		uid, _ := r.Context().Value(middleware.CtxUserID).(string)
+		role, err := store.MembershipRole(r.Context(), h.Pool, uid, id)
+		if err != nil && err != pgx.ErrNoRows {
+			return authz.Resource{}, err
+		}
+		return authz.OrgResource(id, role), nil
+	case "role":
+		levels, err := store.RoleLevels(r.Context(), h.Pool, []models.Role{models.Role(id)})
+		if err != nil {
+			return authz.Resource{}, err
+		}
+		return authz.RoleResource(models.Role(id), levels[models.Role(id)]), nil
+	}
+	return authz.Resource{Type: typ, ID: id}, nil
+}
+
+// authorize writes 403 and returns false when the policy denies action on res.
+func authorize(w http.ResponseWriter, r *http.Request, az *authz.Engine, action string, res authz.Resource) bool {
+	if err := az.Authorize(r.Context(), action, res); err != nil {
+		httpx.Error(w, http.StatusForbidden, "forbidden")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"dev.mfr/go-chi-sqlc-auth/internal/avatar"
+	"dev.mfr/go-chi-sqlc-auth/internal/httpx"
+	"dev.mfr/go-chi-sqlc-auth/internal/models"
+	"dev.mfr/go-chi-sqlc-auth/internal/storage"
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+// PutAvatar replaces the user's profile picture with the image in the
+// multipart field "avatar". The thumbnails are written under a fresh key
+// before the row points at them, and the previous ones are deleted after.
+func (h *UsersHandler) PutAvatar(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if _, ok := h.authorizeUser(w, r, "users:update", id); !ok {
+		return
+	}
+	// Leave room for the multipart framing around the file
+	r.Body = http.MaxBytesReader(w, r.Body, h.AvatarMaxBytes+64<<10)
+	mr, err := r.MultipartReader()
+	if err != nil {
+		httpx.Error(w, http.StatusUnsupportedMediaType, "multipart/form-data required")
+		return
+	}
+	var data []byte
+	var declared string
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			httpx.Error(w, http.StatusBadRequest, "avatar field required")
+			return
+		}
+		var mbe *http.MaxBytesError
+		if errors.As(err, &mbe) {
+			httpx.Error(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("avatar larger than %d bytes", h.AvatarMaxBytes))
+			return
+		}
+		if err != nil {
+			httpx.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if part.FormName() != "avatar" {
+			continue
+		}
+		declared, _, _ = mime.ParseMediaType(part.Header.Get("Content-Type"))
+		data, err = io.ReadAll(io.LimitReader(part, h.AvatarMaxBytes+1))
+		if errors.As(err, &mbe) || int64(len(data)) > h.AvatarMaxBytes {
+			httpx.Error(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("avatar larger than %d bytes", h.AvatarMaxBytes))
+			return
+		}
+		if err != nil {
+			httpx.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		break
+	}
+	// The declared type must be an accepted one and agree with the bytes
+	if !contains(avatar.ContentTypes, declared) {
+		httpx.Error(w, http.StatusUnsupportedMediaType, avatar.ErrUnsupported.Error())
+		return
+	}
+	if sniffed := avatar.Sniff(data); sniffed != declared {
+		httpx.Error(w, http.StatusUnsupportedMediaType, "content is not "+declared)
+		return
+	}
+	thumbs, err := avatar.Process(data)
+	switch {
+	case errors.Is(err, avatar.ErrDimensions):
+		httpx.Error(w, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	case err != nil:
+		httpx.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		httpx.Error(w, http.StatusInternalServerError, "failed to generate key")
+		return
+	}
+	prefix := "avatars/" + id + "/" + hex.EncodeToString(b)
+	resp := models.Avatar{Sizes: map[string]string{}}
+	keys := make([]string, 0, len(thumbs))
+	for _, t := range thumbs {
+		key := fmt.Sprintf("%s/%d.%s", prefix, t.Size, t.Ext)
+		if err := h.Blobs.Put(r.Context(), key, t.ContentType, t.Data); err != nil {
+			storage.DeleteAll(r.Context(), h.Blobs, keys)
+			httpx.Error(w, http.StatusBadGateway, "failed to store avatar: "+err.Error())
+			return
+		}
+		keys = append(keys, key)
+		resp.Sizes[strconv.Itoa(t.Size)] = h.Blobs.URL(key)
+	}
+	// The largest size is the one on the user
+	resp.URL = h.Blobs.URL(keys[len(keys)-1])
+
+	var old []string
+	var version int64
+	err = h.Pool.QueryRow(r.Context(), `UPDATE users u SET avatar_url=$2, avatar_keys=$3, updated_at=now()
+		FROM (SELECT id, avatar_keys FROM users WHERE id=$1 AND deleted_at IS NULL FOR UPDATE) old
+		WHERE u.id = old.id RETURNING old.avatar_keys, u.version`, id, resp.URL, keys).Scan(&old, &version)
+	if err != nil {
+		storage.DeleteAll(r.Context(), h.Blobs, keys)
+		if err == pgx.ErrNoRows {
+			httpx.Error(w, http.StatusNotFound, "not found")
+			return
+		}
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	storage.DeleteAll(r.Context(), h.Blobs, old)
+	w.Header().Set("ETag", userETag(version))
+	httpx.JSON(w, http.StatusOK, resp)
+}
+
+// DeleteAvatar removes the user's profile picture.
+func (h *UsersHandler) DeleteAvatar(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if _, ok := h.authorizeUser(w, r, "users:update", id); !ok {
+		return
+	}
+	var old []string
+	err := h.Pool.QueryRow(r.Context(), `UPDATE users u SET avatar_url=NULL, avatar_keys='{}', updated_at=now()
+		FROM (SELECT id, avatar_keys FROM users WHERE id=$1 AND deleted_at IS NULL FOR UPDATE) old
+		WHERE u.id = old.id RETURNING old.avatar_keys`, id).Scan(&old)
+	if err == pgx.ErrNoRows {
+		httpx.Error(w, http.StatusNotFound, "not found")
+		return
+	}
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	storage.DeleteAll(r.Context(), h.Blobs, old)
+	w.WriteHeader(http.StatusNoContent)
+}
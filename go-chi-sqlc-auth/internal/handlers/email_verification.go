@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"dev.mfr/go-chi-sqlc-auth/internal/audit"
+	"dev.mfr/go-chi-sqlc-auth/internal/auth"
+	"dev.mfr/go-chi-sqlc-auth/internal/httpx"
+	"dev.mfr/go-chi-sqlc-auth/internal/mailer"
+	"dev.mfr/go-chi-sqlc-auth/internal/middleware"
+	"dev.mfr/go-chi-sqlc-auth/internal/models"
+	"dev.mfr/go-chi-sqlc-auth/internal/store"
+	"github.com/jackc/pgx/v5"
+)
+
+// emailVerification is a link created in a transaction and mailed after it commits.
+type emailVerification struct {
+	to, firstName, token string
+	expires              time.Time
+}
+
+// issueEmailVerification stores a verification link for the user's address.
+func (h *AuthHandler) issueEmailVerification(ctx context.Context, db store.DBTX, userID, email, firstName string) (emailVerification, error) {
+	token, hash, err := auth.NewOpaqueToken()
+	if err != nil {
+		return emailVerification{}, err
+	}
+	v := emailVerification{to: email, firstName: firstName, token: token, expires: time.Now().Add(h.EmailVerificationTTL)}
+	return v, store.CreateEmailVerification(ctx, db, userID, email, hash, v.expires)
+}
+
+func (h *AuthHandler) sendEmailVerification(ctx context.Context, v emailVerification) error {
+	return h.Mailer.Send(ctx, mailer.Message{
+		To:      v.to,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease confirm your email address at:\n%s/verify-email?token=%s\n\n"+
+			"The link expires on %s.\n", v.firstName, h.AppURL, v.token, v.expires.UTC().Format(time.RFC1123)),
+	})
+}
+
+// VerifyEmail redeems a verification link. The user then joins the
+// organizations that verified their email domain.
+func (h *AuthHandler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var req models.EmailVerificationRequest
+	if err := decodeJSON(r, &req); err != nil {
+		httpx.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	tx, err := h.Pool.Begin(r.Context())
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, "failed to begin transaction")
+		return
+	}
+	defer tx.Rollback(r.Context())
+	id, err := store.ConsumeEmailVerification(r.Context(), tx, auth.HashOpaqueToken(req.Token))
+	if err == store.ErrEmailVerificationInvalid {
+		httpx.ErrorCode(w, http.StatusBadRequest, "invalid_verification_token", err.Error())
+		return
+	}
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, "failed to check link")
+		return
+	}
+	joined, err := store.AutoJoinByDomain(r.Context(), tx, id)
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, "failed to join organization")
+		return
+	}
+	entry := audit.Entry{ActorID: &id, Action: "user.email_verified", TargetType: "user", TargetID: id, Data: map[string]any{"joined_orgs": joined}}
+	if _, err := h.Audit.Record(r.Context(), tx, entry); err != nil {
+		httpx.Error(w, http.StatusInternalServerError, "failed to write audit log")
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		httpx.Error(w, http.StatusInternalServerError, "failed to commit")
+		return
+	}
+	if joined == nil {
+		joined = []string{}
+	}
+	httpx.JSON(w, http.StatusOK, map[string]any{"email_verified": true, "joined_org_ids": joined})
+}
+
+// RequestEmailVerification mails the caller a new verification link.
+func (h *AuthHandler) RequestEmailVerification(w http.ResponseWriter, r *http.Request) {
+	uid, _ := r.Context().Value(middleware.CtxUserID).(string)
+	var email, firstName string
+	var verifiedAt *time.Time
+	err := h.Pool.QueryRow(r.Context(), "SELECT email, first_name, email_verified_at FROM users WHERE id=$1 AND deleted_at IS NULL", uid).
+		Scan(&email, &firstName, &verifiedAt)
+	if err == pgx.ErrNoRows {
+		httpx.Error(w, http.StatusNotFound, "user not found")
+		return
+	}
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, "query error")
+		return
+	}
+	if verifiedAt != nil {
+		httpx.ErrorCode(w, http.StatusConflict, "email_already_verified", "email is already verified")
+		return
+	}
+	v, err := h.issueEmailVerification(r.Context(), h.Pool, uid, email, firstName)
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, "failed to create link")
+		return
+	}
+	if err := h.sendEmailVerification(r.Context(), v); err != nil {
+		httpx.Error(w, http.StatusBadGateway, "verification email failed: "+err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"context"
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"dev.mfr/go-chi-sqlc-auth/internal/httpx"
+	"github.com/jackc/pgx/v5"
+)
+
+// userETag is the strong entity tag of a user row version.
+func userETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// projectedETag tags a ?fields=/?expand= representation of the user: the row
+// version plus the projection, so one shape's tag never matches another.
+// If-Match only looks at the version.
+func projectedETag(version int64, p userProjection) string {
+	if p.full() {
+		return userETag(version)
+	}
+	h := fnv.New32a()
+	h.Write([]byte(p.key()))
+	return `"` + strconv.FormatInt(version, 10) + "-" + strconv.FormatUint(uint64(h.Sum32()), 36) + `"`
+}
+
+// ifMatch reads the If-Match header as the row versions a write may apply to.
+// nil means any version; an empty slice matches nothing. When required is set
+// a missing header is rejected with 428. On failure it writes the response and
+// returns false.
+func ifMatch(w http.ResponseWriter, r *http.Request, required bool) ([]int64, bool) {
+	v := strings.TrimSpace(r.Header.Get("If-Match"))
+	if v == "" {
+		if required {
+			httpx.ErrorCode(w, http.StatusPreconditionRequired, "precondition_required", "If-Match header required")
+			return nil, false
+		}
+		return nil, true
+	}
+	if v == "*" {
+		return nil, true
+	}
+	versions := []int64{}
+	for _, tag := range strings.Split(v, ",") {
+		// If-Match uses strong comparison, so weak tags never match
+		tag = strings.TrimSpace(tag)
+		if strings.HasPrefix(tag, "W/") {
+			continue
+		}
+		version, _, _ := strings.Cut(strings.Trim(tag, `"`), "-")
+		if n, err := strconv.ParseInt(version, 10, 64); err == nil {
+			versions = append(versions, n)
+		}
+	}
+	return versions, true
+}
+
+// notModified reports whether If-None-Match matches etag (weak comparison).
+func notModified(r *http.Request, etag string) bool {
+	v := strings.TrimSpace(r.Header.Get("If-None-Match"))
+	if v == "" {
+		return false
+	}
+	if v == "*" {
+		return true
+	}
+	for _, tag := range strings.Split(v, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(tag), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func versionMatches(versions []int64, v int64) bool {
+	if versions == nil {
+		return true
+	}
+	for _, x := range versions {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+// preconditionFailed writes 412 when the user still exists and 404 when it is gone;
+// it is called after a versioned write matched no row.
+func (h *UsersHandler) preconditionFailed(ctx context.Context, w http.ResponseWriter, id string) {
+	var exists bool
+	err := h.Pool.QueryRow(ctx, "SELECT true FROM users WHERE id=$1 AND deleted_at IS NULL", id).Scan(&exists)
+	switch {
+	case err == pgx.ErrNoRows:
+		httpx.Error(w, http.StatusNotFound, "not found")
+	case err != nil:
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+	default:
+		httpx.ErrorCode(w, http.StatusPreconditionFailed, "precondition_failed", "user was modified; reload and retry")
+	}
+}
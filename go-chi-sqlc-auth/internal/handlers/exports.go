@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"dev.mfr/go-chi-sqlc-auth/internal/audit"
+	"dev.mfr/go-chi-sqlc-auth/internal/authz"
+	"dev.mfr/go-chi-sqlc-auth/internal/export"
+	"dev.mfr/go-chi-sqlc-auth/internal/httpx"
+	"dev.mfr/go-chi-sqlc-auth/internal/middleware"
+	"dev.mfr/go-chi-sqlc-auth/internal/models"
+	"dev.mfr/go-chi-sqlc-auth/internal/store"
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ExportsHandler serves personal data exports. Requests and status polling
+// are mounted under /auth/me and /users/{id}; downloads use signed URLs and
+// need no token.
+type ExportsHandler struct {
+	Pool    *pgxpool.Pool
+	Authz   *authz.Engine
+	Exports *export.Service
+	Audit   *audit.Logger
+}
+
+func NewExportsHandler(pool *pgxpool.Pool, az *authz.Engine, exports *export.Service, al *audit.Logger) *ExportsHandler {
+	return &ExportsHandler{Pool: pool, Authz: az, Exports: exports, Audit: al}
+}
+
+// Routes serves the public download endpoint.
+func (h *ExportsHandler) Routes() http.Handler {
+	r := chi.NewRouter()
+	r.Get("/{exportID}/download", h.Download)
+	return r
+}
+
+// RequestMine starts an export of the caller's own data.
+func (h *ExportsHandler) RequestMine(w http.ResponseWriter, r *http.Request) {
+	uid, _ := r.Context().Value(middleware.CtxUserID).(string)
+	h.request(w, r, uid)
+}
+
+func (h *ExportsHandler) GetMine(w http.ResponseWriter, r *http.Request) {
+	uid, _ := r.Context().Value(middleware.CtxUserID).(string)
+	h.get(w, r, uid)
+}
+
+// RequestForUser lets an admin export the data of the user in the URL.
+func (h *ExportsHandler) RequestForUser(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if !h.authorizeExport(w, r, id) {
+		return
+	}
+	h.request(w, r, id)
+}
+
+func (h *ExportsHandler) GetForUser(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if !h.authorizeExport(w, r, id) {
+		return
+	}
+	h.get(w, r, id)
+}
+
+func (h *ExportsHandler) authorizeExport(w http.ResponseWriter, r *http.Request, id string) bool {
+	t, err := store.ManagedUser(r.Context(), h.Pool, id)
+	if err == pgx.ErrNoRows {
+		httpx.Error(w, http.StatusNotFound, "not found")
+		return false
+	}
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return false
+	}
+	return authorize(w, r, h.Authz, "users:export", authz.UserResource(t))
+}
+
+func (h *ExportsHandler) request(w http.ResponseWriter, r *http.Request, userID string) {
+	req := models.DataExportRequest{Format: models.ExportJSON}
+	if r.ContentLength != 0 {
+		if err := decodeJSON(r, &req); err != nil {
+			httpx.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if req.Format != models.ExportJSON && req.Format != models.ExportZIP {
+		httpx.Error(w, http.StatusBadRequest, "format must be json or zip")
+		return
+	}
+	uid, _ := r.Context().Value(middleware.CtxUserID).(string)
+	tx, err := h.Pool.Begin(r.Context())
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer tx.Rollback(r.Context())
+	e, err := h.Exports.Request(r.Context(), tx, userID, uid, req.Format)
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	entry := audit.Entry{ActorID: &uid, Action: "user.export_requested", TargetType: "user", TargetID: userID,
+		Data: map[string]any{"export_id": e.ID, "format": string(e.Format)}}
+	if _, err := h.Audit.Record(r.Context(), tx, entry); err != nil {
+		httpx.Error(w, http.StatusInternalServerError, "failed to write audit log")
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	h.Exports.Start(e)
+	w.Header().Set("Location", r.URL.Path+"s/"+e.ID)
+	httpx.JSON(w, http.StatusAccepted, e)
+}
+
+func (h *ExportsHandler) get(w http.ResponseWriter, r *http.Request, userID string) {
+	e, err := h.Exports.Get(r.Context(), userID, chi.URLParam(r, "exportID"))
+	if err == pgx.ErrNoRows {
+		httpx.Error(w, http.StatusNotFound, "not found")
+		return
+	}
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	httpx.JSON(w, http.StatusOK, e)
+}
+
+// Download serves a ready archive to anyone holding a valid signed URL.
+func (h *ExportsHandler) Download(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "exportID")
+	q := r.URL.Query()
+	format, content, err := h.Exports.Download(r.Context(), id, q.Get("expires"), q.Get("sig"))
+	switch {
+	case errors.Is(err, export.ErrInvalidLink):
+		httpx.Error(w, http.StatusForbidden, err.Error())
+		return
+	case errors.Is(err, export.ErrLinkExpired):
+		httpx.Error(w, http.StatusGone, err.Error())
+		return
+	case err == pgx.ErrNoRows:
+		httpx.Error(w, http.StatusNotFound, "not found")
+		return
+	case err != nil:
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	ct := "application/json"
+	if format == models.ExportZIP {
+		ct = "application/zip"
+	}
+	w.Header().Set("Content-Type", ct)
+	w.Header().Set("Content-Disposition", `attachment; filename="export-`+id+`.`+string(format)+`"`)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(content)
+}
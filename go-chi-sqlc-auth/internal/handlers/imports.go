@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"slices"
+
+	"dev.mfr/go-chi-sqlc-auth/internal/httpx"
+	"dev.mfr/go-chi-sqlc-auth/internal/importer"
+	"dev.mfr/go-chi-sqlc-auth/internal/middleware"
+	"dev.mfr/go-chi-sqlc-auth/internal/models"
+	"dev.mfr/go-chi-sqlc-auth/internal/store"
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+// importFormats maps upload content types to import formats.
+var importFormats = map[string]models.ImportFormat{
+	"text/csv":             models.ImportCSV,
+	"application/x-ndjson": models.ImportNDJSON,
+	"application/ndjson":   models.ImportNDJSON,
+	"application/jsonl":    models.ImportNDJSON,
+}
+
+// Import loads users from a CSV or NDJSON upload. Small files are imported
+// right away and answered with the report; larger ones (or ?async=true) run
+// in the background and are polled at GET /users/import/{importID}.
+func (h *UsersHandler) Import(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	format := models.ImportFormat(q.Get("format"))
+	if format == "" {
+		ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		format = importFormats[ct]
+	}
+	if format != models.ImportCSV && format != models.ImportNDJSON {
+		httpx.Error(w, http.StatusUnsupportedMediaType, "upload text/csv or application/x-ndjson")
+		return
+	}
+	rows, err := importer.Parse(http.MaxBytesReader(w, r.Body, h.ImportMaxBytes), format, h.ImportMaxRows)
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge), errors.Is(err, importer.ErrTooManyRows):
+		httpx.Error(w, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	case err != nil:
+		httpx.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	// Roles are checked once for the whole file; unknown ones fail their rows later
+	seen := map[models.Role]bool{}
+	for _, row := range rows {
+		role := models.Role(row.Role)
+		if role == "" || seen[role] {
+			continue
+		}
+		seen[role] = true
+		err := h.checkRoleGrants(r, nil, []models.Role{role})
+		switch {
+		case errors.Is(err, store.ErrUnknownRole):
+		case errors.Is(err, errRoleNotGrantable):
+			httpx.Error(w, http.StatusForbidden, err.Error())
+			return
+		case err != nil:
+			httpx.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if role != models.RoleUser && !middleware.RecentlyAuthenticated(r.Context(), h.ReauthMaxAge) {
+			middleware.ReauthRequired(w, h.ReauthMaxAge)
+			return
+		}
+	}
+
+	opts := importer.Options{
+		DryRun: q.Get("dry_run") == "true",
+		Upsert: q.Get("upsert") == "true",
+		OrgID:  middleware.PrincipalFrom(r.Context()).OrgID,
+	}
+	// An upsert can replace existing users' passwords and roles, even demoting
+	// them to user, which needs a recent login like the single-user endpoints
+	if opts.Upsert && slices.ContainsFunc(rows, func(row importer.Row) bool { return row.PasswordHash != "" || row.Role != "" }) &&
+		!middleware.RecentlyAuthenticated(r.Context(), h.ReauthMaxAge) {
+		middleware.ReauthRequired(w, h.ReauthMaxAge)
+		return
+	}
+
+	uid, _ := r.Context().Value(middleware.CtxUserID).(string)
+	im, err := h.Imports.Create(r.Context(), uid, format, opts, len(rows))
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Location", "/users/import/"+im.ID)
+	if q.Get("async") == "true" || len(rows) > h.ImportSyncRows {
+		h.Imports.Start(r.Context(), im, rows, opts)
+		httpx.JSON(w, http.StatusAccepted, im)
+		return
+	}
+	im, err = h.Imports.Run(r.Context(), im, rows, opts)
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	httpx.JSON(w, http.StatusOK, im)
+}
+
+// GetImport reports the progress of an import, and its per-row results once done.
+func (h *UsersHandler) GetImport(w http.ResponseWriter, r *http.Request) {
+	uid, _ := r.Context().Value(middleware.CtxUserID).(string)
+	im, err := h.Imports.Get(r.Context(), uid, chi.URLParam(r, "importID"))
+	if err == pgx.ErrNoRows {
+		httpx.Error(w, http.StatusNotFound, "not found")
+		return
+	}
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	httpx.JSON(w, http.StatusOK, im)
+}
@@ -0,0 +1,346 @@
+package handlers
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+
+	"dev.mfr/go-chi-sqlc-auth/internal/auth"
+	"dev.mfr/go-chi-sqlc-auth/internal/authz"
+	"dev.mfr/go-chi-sqlc-auth/internal/httpx"
+	"dev.mfr/go-chi-sqlc-auth/internal/mailer"
+	"dev.mfr/go-chi-sqlc-auth/internal/middleware"
+	"dev.mfr/go-chi-sqlc-auth/internal/models"
+	"dev.mfr/go-chi-sqlc-auth/internal/store"
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+// domainVerifyPrefix is the DNS label holding the TXT record that proves domain ownership.
+const domainVerifyPrefix = "_auth-verify."
+
+const invitationColumns = "id, org_id, email, org_role, invited_by, expires_at, accepted_at, revoked_at, created_at"
+
+func scanInvitation(row pgx.Row, inv *models.Invitation) error {
+	return row.Scan(&inv.ID, &inv.OrgID, &inv.Email, &inv.OrgRole, &inv.InvitedBy, &inv.ExpiresAt, &inv.AcceptedAt, &inv.RevokedAt, &inv.CreatedAt)
+}
+
+// Invite emails a single-use invitation to join the organization with the given org role.
+func (h *OrgsHandler) Invite(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.authorizeOrg(w, r, "orgs:members:manage")
+	if !ok {
+		return
+	}
+	var req models.InvitationRequest
+	if err := decodeJSON(r, &req); err != nil {
+		httpx.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	addr, err := mail.ParseAddress(req.Email)
+	if err != nil {
+		httpx.Error(w, http.StatusBadRequest, "invalid email")
+		return
+	}
+	if req.OrgRole == "" {
+		req.OrgRole = models.OrgRoleMember
+	}
+	if !req.OrgRole.Valid() {
+		httpx.Error(w, http.StatusBadRequest, "org_role must be owner, admin or member")
+		return
+	}
+	if req.OrgRole == models.OrgRoleOwner {
+		if _, ok := h.authorizeOrg(w, r, "orgs:owners:manage"); !ok {
+			return
+		}
+	}
+	token, hash, err := auth.NewOpaqueToken()
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, "failed to generate token")
+		return
+	}
+	uid, _ := r.Context().Value(middleware.CtxUserID).(string)
+	var inv models.Invitation
+	err = scanInvitation(h.Pool.QueryRow(r.Context(), `INSERT INTO org_invitations (org_id, email, org_role, token_hash, invited_by, expires_at)
+		VALUES ($1,$2,$3,$4,$5,$6) RETURNING `+invitationColumns,
+		id, addr.Address, req.OrgRole, hash, uid, time.Now().Add(h.InviteTTL)), &inv)
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	var orgName string
+	if err := h.Pool.QueryRow(r.Context(), "SELECT name FROM organizations WHERE id=$1", id).Scan(&orgName); err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	msg := mailer.Message{
+		To:      inv.Email,
+		Subject: fmt.Sprintf("You're invited to join %s", orgName),
+		Body: fmt.Sprintf("You have been invited to join %s as %s.\n\n"+
+			"Already have an account? Sign in and accept at:\n%s/accept-invitation?token=%s\n\n"+
+			"New here? Register with this invite token:\n%s\n\n"+
+			"The invitation expires on %s.\n",
+			orgName, inv.OrgRole, h.AppURL, token, token, inv.ExpiresAt.UTC().Format(time.RFC1123)),
+	}
+	// The invitation exists either way; revoke it and invite again for a new link
+	resp := struct {
+		models.Invitation
+		Warning string `json:"warning,omitempty"`
+	}{Invitation: inv}
+	if err := h.Mailer.Send(r.Context(), msg); err != nil {
+		resp.Warning = "invitation created but email failed: " + err.Error()
+	}
+	httpx.JSON(w, http.StatusCreated, resp)
+}
+
+func (h *OrgsHandler) ListInvitations(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.authorizeOrg(w, r, "orgs:members:manage")
+	if !ok {
+		return
+	}
+	rows, err := h.Pool.Query(r.Context(), "SELECT "+invitationColumns+" FROM org_invitations WHERE org_id=$1 ORDER BY created_at DESC", id)
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+	resp := []models.Invitation{}
+	for rows.Next() {
+		var inv models.Invitation
+		if err := scanInvitation(rows, &inv); err != nil {
+			httpx.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		resp = append(resp, inv)
+	}
+	httpx.JSON(w, http.StatusOK, resp)
+}
+
+// RevokeInvitation makes a pending invitation unusable.
+func (h *OrgsHandler) RevokeInvitation(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.authorizeOrg(w, r, "orgs:members:manage")
+	if !ok {
+		return
+	}
+	var inv models.Invitation
+	err := scanInvitation(h.Pool.QueryRow(r.Context(), `UPDATE org_invitations SET revoked_at=now()
+		WHERE id=$1 AND org_id=$2 AND accepted_at IS NULL AND revoked_at IS NULL RETURNING `+invitationColumns,
+		chi.URLParam(r, "invitationID"), id), &inv)
+	if err == pgx.ErrNoRows {
+		httpx.Error(w, http.StatusNotFound, "no pending invitation")
+		return
+	}
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	httpx.JSON(w, http.StatusOK, inv)
+}
+
+// AcceptInvitation lets a signed-in user redeem an invitation sent to their email.
+func (h *OrgsHandler) AcceptInvitation(w http.ResponseWriter, r *http.Request) {
+	var req models.AcceptInvitationRequest
+	if err := decodeJSON(r, &req); err != nil {
+		httpx.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	uid, _ := r.Context().Value(middleware.CtxUserID).(string)
+	tx, err := h.Pool.Begin(r.Context())
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer tx.Rollback(r.Context())
+	var email string
+	if err := tx.QueryRow(r.Context(), "SELECT email FROM users WHERE id=$1", uid).Scan(&email); err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	orgID, err := store.AcceptInvitation(r.Context(), tx, auth.HashOpaqueToken(req.Token), uid, email)
+	switch err {
+	case nil:
+	case store.ErrInvitationInvalid:
+		httpx.Error(w, http.StatusNotFound, err.Error())
+		return
+	case store.ErrInvitationEmailMismatch:
+		httpx.Error(w, http.StatusForbidden, err.Error())
+		return
+	default:
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	// Accepting verified the address, which may admit the user to more organizations
+	if _, err := store.AutoJoinByDomain(r.Context(), tx, uid); err != nil {
+		httpx.Error(w, http.StatusInternalServerError, "failed to join organization")
+		return
+	}
+	var m models.Membership
+	if err := tx.QueryRow(r.Context(), "SELECT user_id, org_id, org_role, created_at FROM memberships WHERE user_id=$1 AND org_id=$2", uid, orgID).
+		Scan(&m.UserID, &m.OrgID, &m.OrgRole, &m.CreatedAt); err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	httpx.JSON(w, http.StatusOK, m)
+}
+
+const orgDomainColumns = "domain, org_id, verification_token, verified_at, auto_join_role, created_at"
+
+func scanOrgDomain(row pgx.Row, d *models.OrgDomain) error {
+	return row.Scan(&d.Domain, &d.OrgID, &d.VerificationToken, &d.VerifiedAt, &d.AutoJoinRole, &d.CreatedAt)
+}
+
+func (h *OrgsHandler) ListDomains(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.authorizeOrg(w, r, "orgs:domains:manage")
+	if !ok {
+		return
+	}
+	rows, err := h.Pool.Query(r.Context(), "SELECT "+orgDomainColumns+" FROM org_domains WHERE org_id=$1 ORDER BY domain", id)
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+	resp := []models.OrgDomain{}
+	for rows.Next() {
+		var d models.OrgDomain
+		if err := scanOrgDomain(rows, &d); err != nil {
+			httpx.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		resp = append(resp, d)
+	}
+	httpx.JSON(w, http.StatusOK, resp)
+}
+
+// AddDomain claims an email domain for the organization. It has no effect until
+// verified by publishing the returned token as a DNS TXT record, so other
+// organizations may claim the same domain until one of them verifies it.
+func (h *OrgsHandler) AddDomain(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.authorizeOrg(w, r, "orgs:domains:manage")
+	if !ok {
+		return
+	}
+	var req models.OrgDomainRequest
+	if err := decodeJSON(r, &req); err != nil {
+		httpx.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	domain := strings.ToLower(strings.TrimSpace(strings.TrimPrefix(req.Domain, "@")))
+	if !strings.Contains(domain, ".") || strings.ContainsAny(domain, " @/") {
+		httpx.Error(w, http.StatusBadRequest, "invalid domain")
+		return
+	}
+	if req.AutoJoinRole == "" {
+		req.AutoJoinRole = models.OrgRoleMember
+	}
+	// Higher roles are granted by people, not by an email address
+	if req.AutoJoinRole != models.OrgRoleMember {
+		httpx.Error(w, http.StatusBadRequest, "auto_join_role must be member")
+		return
+	}
+	token, _, err := auth.NewOpaqueToken()
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, "failed to generate token")
+		return
+	}
+	var d models.OrgDomain
+	var taken bool
+	err = h.Pool.QueryRow(r.Context(), "SELECT EXISTS (SELECT 1 FROM org_domains WHERE domain=$1 AND org_id<>$2 AND verified_at IS NOT NULL)", domain, id).Scan(&taken)
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if taken {
+		httpx.Error(w, http.StatusConflict, uniqueMessages["idx_org_domains_verified"])
+		return
+	}
+	err = scanOrgDomain(h.Pool.QueryRow(r.Context(), `INSERT INTO org_domains (domain, org_id, verification_token, auto_join_role)
+		VALUES ($1,$2,$3,$4) RETURNING `+orgDomainColumns, domain, id, token, req.AutoJoinRole), &d)
+	if isUniqueViolation(err) {
+		httpx.Error(w, http.StatusConflict, parsePGError(err))
+		return
+	}
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	httpx.JSON(w, http.StatusCreated, map[string]any{
+		"domain":       d,
+		"instructions": fmt.Sprintf("Create a TXT record %s%s with the value auth-verify=%s, then call verify", domainVerifyPrefix, d.Domain, d.VerificationToken),
+	})
+}
+
+// VerifyDomain checks the DNS TXT record. Platform admins can pass ?force=true
+// to mark a domain verified without DNS, e.g. in development.
+func (h *OrgsHandler) VerifyDomain(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.authorizeOrg(w, r, "orgs:domains:manage")
+	if !ok {
+		return
+	}
+	domain := strings.ToLower(chi.URLParam(r, "domain"))
+	var d models.OrgDomain
+	err := scanOrgDomain(h.Pool.QueryRow(r.Context(), "SELECT "+orgDomainColumns+" FROM org_domains WHERE domain=$1 AND org_id=$2", domain, id), &d)
+	if err == pgx.ErrNoRows {
+		httpx.Error(w, http.StatusNotFound, "not found")
+		return
+	}
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if r.URL.Query().Get("force") == "true" {
+		if !authorize(w, r, h.Authz, "orgs:domains:force-verify", authz.OrgResource(id, "")) {
+			return
+		}
+	} else if !hasVerificationRecord(domain, d.VerificationToken) {
+		httpx.Error(w, http.StatusUnprocessableEntity, fmt.Sprintf("TXT record %s%s with auth-verify=%s not found", domainVerifyPrefix, domain, d.VerificationToken))
+		return
+	}
+	err = scanOrgDomain(h.Pool.QueryRow(r.Context(), "UPDATE org_domains SET verified_at=COALESCE(verified_at, now()) WHERE domain=$1 AND org_id=$2 RETURNING "+orgDomainColumns,
+		domain, id), &d)
+	if isUniqueViolation(err) {
+		httpx.Error(w, http.StatusConflict, parsePGError(err))
+		return
+	}
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	httpx.JSON(w, http.StatusOK, d)
+}
+
+func (h *OrgsHandler) DeleteDomain(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.authorizeOrg(w, r, "orgs:domains:manage")
+	if !ok {
+		return
+	}
+	ct, err := h.Pool.Exec(r.Context(), "DELETE FROM org_domains WHERE domain=$1 AND org_id=$2", strings.ToLower(chi.URLParam(r, "domain")), id)
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if ct.RowsAffected() == 0 {
+		httpx.Error(w, http.StatusNotFound, "not found")
+		return
+	}
+	httpx.JSON(w, http.StatusOK, map[string]any{"deleted": 1})
+}
+
+func hasVerificationRecord(domain, token string) bool {
+	records, err := net.LookupTXT(domainVerifyPrefix + domain)
+	if err != nil {
+		return false
+	}
+	for _, rec := range records {
+		if strings.TrimSpace(rec) == "auth-verify="+token {
+			return true
+		}
+	}
+	return false
+}
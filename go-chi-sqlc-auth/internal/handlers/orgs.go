@@ -0,0 +1,354 @@
+package handlers
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"dev.mfr/go-chi-sqlc-auth/internal/authz"
+	"dev.mfr/go-chi-sqlc-auth/internal/httpx"
+	"dev.mfr/go-chi-sqlc-auth/internal/mailer"
+	"dev.mfr/go-chi-sqlc-auth/internal/middleware"
+	"dev.mfr/go-chi-sqlc-auth/internal/models"
+	"dev.mfr/go-chi-sqlc-auth/internal/store"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var orgSlugRe = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,62}$`)
+
+type OrgsHandler struct {
+	Pool   *pgxpool.Pool
+	Authz  *authz.Engine
+	Mailer mailer.Mailer
+	// AppURL prefixes links in invitation emails.
+	AppURL    string
+	InviteTTL time.Duration
+}
+
+func NewOrgsHandler(pool *pgxpool.Pool, az *authz.Engine, m mailer.Mailer, appURL string, inviteTTL time.Duration) *OrgsHandler {
+	return &OrgsHandler{Pool: pool, Authz: az, Mailer: m, AppURL: appURL, InviteTTL: inviteTTL}
+}
+
+func (h *OrgsHandler) Routes() http.Handler {
+	r := chi.NewRouter()
+	r.Get("/", h.List)
+	r.Post("/", h.Create)
+	r.Get("/{id}", h.Get)
+	r.Put("/{id}", h.Update)
+	r.Delete("/{id}", h.Delete)
+	r.Get("/{id}/members", h.ListMembers)
+	r.Put("/{id}/members/{userID}", h.PutMember)
+	r.Delete("/{id}/members/{userID}", h.DeleteMember)
+	r.Get("/{id}/invitations", h.ListInvitations)
+	r.Post("/{id}/invitations", h.Invite)
+	r.Delete("/{id}/invitations/{invitationID}", h.RevokeInvitation)
+	r.Get("/{id}/domains", h.ListDomains)
+	r.Post("/{id}/domains", h.AddDomain)
+	r.Post("/{id}/domains/{domain}/verify", h.VerifyDomain)
+	r.Delete("/{id}/domains/{domain}", h.DeleteDomain)
+	return r
+}
+
+// authorizeOrg looks up the caller's role in the org from the URL and asks the
+// policy whether they may perform action. On failure it writes the response.
+func (h *OrgsHandler) authorizeOrg(w http.ResponseWriter, r *http.Request, action string) (string, bool) {
+	id := chi.URLParam(r, "id")
+	parsed, err := uuid.Parse(id)
+	if err != nil {
+		httpx.Error(w, http.StatusBadRequest, "invalid org id")
+		return id, false
+	}
+	id = parsed.String()
+	var exists bool
+	if err := h.Pool.QueryRow(r.Context(), "SELECT EXISTS (SELECT 1 FROM organizations WHERE id=$1)", id).Scan(&exists); err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return id, false
+	}
+	if !exists {
+		httpx.Error(w, http.StatusNotFound, "not found")
+		return id, false
+	}
+	uid, _ := r.Context().Value(middleware.CtxUserID).(string)
+	role, err := store.MembershipRole(r.Context(), h.Pool, uid, id)
+	if err != nil && err != pgx.ErrNoRows {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return id, false
+	}
+	if !authorize(w, r, h.Authz, action, authz.OrgResource(id, role)) {
+		return id, false
+	}
+	return id, true
+}
+
+// List returns every organization to platform admins and the caller's own to everyone else.
+func (h *OrgsHandler) List(w http.ResponseWriter, r *http.Request) {
+	uid, _ := r.Context().Value(middleware.CtxUserID).(string)
+	all := h.Authz.Authorize(r.Context(), "orgs:list", authz.Resource{Type: "org"}) == nil
+	rows, err := h.Pool.Query(r.Context(), `SELECT o.id, o.name, o.slug, o.created_at, o.updated_at FROM organizations o
+		WHERE $1 OR EXISTS (SELECT 1 FROM memberships m WHERE m.org_id = o.id AND m.user_id = $2)
+		ORDER BY o.name`, all, uid)
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+	resp := []models.Organization{}
+	for rows.Next() {
+		var o models.Organization
+		if err := rows.Scan(&o.ID, &o.Name, &o.Slug, &o.CreatedAt, &o.UpdatedAt); err != nil {
+			httpx.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		resp = append(resp, o)
+	}
+	httpx.JSON(w, http.StatusOK, resp)
+}
+
+// Create makes a new organization with the caller as its owner.
+func (h *OrgsHandler) Create(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, h.Authz, "orgs:create", authz.Resource{Type: "org"}) {
+		return
+	}
+	var req models.OrgRequest
+	if err := decodeJSON(r, &req); err != nil {
+		httpx.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !validOrgRequest(w, &req) {
+		return
+	}
+	uid, _ := r.Context().Value(middleware.CtxUserID).(string)
+	tx, err := h.Pool.Begin(r.Context())
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer tx.Rollback(r.Context())
+	var o models.Organization
+	err = tx.QueryRow(r.Context(), "INSERT INTO organizations (name, slug) VALUES ($1,$2) RETURNING id, name, slug, created_at, updated_at", req.Name, req.Slug).
+		Scan(&o.ID, &o.Name, &o.Slug, &o.CreatedAt, &o.UpdatedAt)
+	if err != nil {
+		httpx.Error(w, http.StatusConflict, parsePGError(err))
+		return
+	}
+	if _, err := tx.Exec(r.Context(), "INSERT INTO memberships (user_id, org_id, org_role) VALUES ($1,$2,$3)", uid, o.ID, models.OrgRoleOwner); err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	httpx.JSON(w, http.StatusCreated, o)
+}
+
+func (h *OrgsHandler) Get(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.authorizeOrg(w, r, "orgs:read")
+	if !ok {
+		return
+	}
+	var o models.Organization
+	err := h.Pool.QueryRow(r.Context(), "SELECT id, name, slug, created_at, updated_at FROM organizations WHERE id=$1", id).
+		Scan(&o.ID, &o.Name, &o.Slug, &o.CreatedAt, &o.UpdatedAt)
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	httpx.JSON(w, http.StatusOK, o)
+}
+
+func (h *OrgsHandler) Update(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.authorizeOrg(w, r, "orgs:update")
+	if !ok {
+		return
+	}
+	var req models.OrgRequest
+	if err := decodeJSON(r, &req); err != nil {
+		httpx.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !validOrgRequest(w, &req) {
+		return
+	}
+	var o models.Organization
+	err := h.Pool.QueryRow(r.Context(), "UPDATE organizations SET name=$2, slug=$3, updated_at=now() WHERE id=$1 RETURNING id, name, slug, created_at, updated_at", id, req.Name, req.Slug).
+		Scan(&o.ID, &o.Name, &o.Slug, &o.CreatedAt, &o.UpdatedAt)
+	if err != nil {
+		httpx.Error(w, http.StatusConflict, parsePGError(err))
+		return
+	}
+	httpx.JSON(w, http.StatusOK, o)
+}
+
+func (h *OrgsHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.authorizeOrg(w, r, "orgs:delete")
+	if !ok {
+		return
+	}
+	if _, err := h.Pool.Exec(r.Context(), "DELETE FROM organizations WHERE id=$1", id); err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	httpx.JSON(w, http.StatusOK, map[string]any{"deleted": 1})
+}
+
+func (h *OrgsHandler) ListMembers(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.authorizeOrg(w, r, "orgs:read")
+	if !ok {
+		return
+	}
+	rows, err := h.Pool.Query(r.Context(), `SELECT m.user_id, m.org_id, u.username, u.email, m.org_role, m.created_at
+		FROM memberships m JOIN users u ON u.id = m.user_id
+		WHERE m.org_id=$1 AND u.deleted_at IS NULL ORDER BY m.created_at`, id)
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+	resp := []models.Membership{}
+	for rows.Next() {
+		var m models.Membership
+		if err := rows.Scan(&m.UserID, &m.OrgID, &m.Username, &m.Email, &m.OrgRole, &m.CreatedAt); err != nil {
+			httpx.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		resp = append(resp, m)
+	}
+	httpx.JSON(w, http.StatusOK, resp)
+}
+
+// PutMember changes the org role of a member. People join through an
+// accepted invitation or a verified email domain, so without their consent
+// only platform admins may add someone here. Granting or taking away
+// ownership is reserved to owners.
+func (h *OrgsHandler) PutMember(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.authorizeOrg(w, r, "orgs:members:manage")
+	if !ok {
+		return
+	}
+	userID := chi.URLParam(r, "userID")
+	var req models.MembershipRequest
+	if err := decodeJSON(r, &req); err != nil {
+		httpx.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.OrgRole == "" {
+		req.OrgRole = models.OrgRoleMember
+	}
+	if !req.OrgRole.Valid() {
+		httpx.Error(w, http.StatusBadRequest, "org_role must be owner, admin or member")
+		return
+	}
+	current, err := store.MembershipRole(r.Context(), h.Pool, userID, id)
+	if err != nil && err != pgx.ErrNoRows {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	member := err == nil
+	if !member && h.Authz.Authorize(r.Context(), "orgs:members:add", authz.OrgResource(id, "")) != nil {
+		httpx.Error(w, http.StatusNotFound, "not a member; invite the user instead")
+		return
+	}
+	if req.OrgRole == models.OrgRoleOwner || current == models.OrgRoleOwner {
+		if _, ok := h.authorizeOrg(w, r, "orgs:owners:manage"); !ok {
+			return
+		}
+		if current == models.OrgRoleOwner && req.OrgRole != models.OrgRoleOwner && !h.hasOtherOwner(w, r, id, userID) {
+			return
+		}
+	}
+	var m models.Membership
+	query := `UPDATE memberships SET org_role=$3 WHERE user_id=$1 AND org_id=$2
+		RETURNING user_id, org_id, org_role, created_at`
+	if !member {
+		query = `INSERT INTO memberships (user_id, org_id, org_role) VALUES ($1,$2,$3)
+		ON CONFLICT (user_id, org_id) DO UPDATE SET org_role = EXCLUDED.org_role
+		RETURNING user_id, org_id, org_role, created_at`
+	}
+	err = h.Pool.QueryRow(r.Context(), query, userID, id, req.OrgRole).Scan(&m.UserID, &m.OrgID, &m.OrgRole, &m.CreatedAt)
+	if err == pgx.ErrNoRows {
+		// They left in the meantime
+		httpx.Error(w, http.StatusNotFound, "not a member; invite the user instead")
+		return
+	}
+	if err != nil {
+		httpx.Error(w, http.StatusBadRequest, parsePGError(err))
+		return
+	}
+	httpx.JSON(w, http.StatusOK, m)
+}
+
+// DeleteMember removes a user from the organization. Members may always leave.
+func (h *OrgsHandler) DeleteMember(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	userID := chi.URLParam(r, "userID")
+	uid, _ := r.Context().Value(middleware.CtxUserID).(string)
+	if uid != userID {
+		if _, ok := h.authorizeOrg(w, r, "orgs:members:manage"); !ok {
+			return
+		}
+	}
+	current, err := store.MembershipRole(r.Context(), h.Pool, userID, id)
+	if err == pgx.ErrNoRows {
+		httpx.Error(w, http.StatusNotFound, "not found")
+		return
+	}
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if current == models.OrgRoleOwner {
+		if uid != userID {
+			if _, ok := h.authorizeOrg(w, r, "orgs:owners:manage"); !ok {
+				return
+			}
+		}
+		if !h.hasOtherOwner(w, r, id, userID) {
+			return
+		}
+	}
+	if _, err := h.Pool.Exec(r.Context(), "DELETE FROM memberships WHERE user_id=$1 AND org_id=$2", userID, id); err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	httpx.JSON(w, http.StatusOK, map[string]any{"deleted": 1})
+}
+
+// hasOtherOwner keeps an organization from losing its last owner.
+func (h *OrgsHandler) hasOtherOwner(w http.ResponseWriter, r *http.Request, orgID, userID string) bool {
+	var others int
+	err := h.Pool.QueryRow(r.Context(), "SELECT COUNT(1) FROM memberships WHERE org_id=$1 AND org_role='owner' AND user_id<>$2", orgID, userID).Scan(&others)
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return false
+	}
+	if others == 0 {
+		httpx.Error(w, http.StatusConflict, "organization must keep at least one owner")
+		return false
+	}
+	return true
+}
+
+func validOrgRequest(w http.ResponseWriter, req *models.OrgRequest) bool {
+	req.Name = strings.TrimSpace(req.Name)
+	req.Slug = strings.ToLower(strings.TrimSpace(req.Slug))
+	if req.Name == "" {
+		httpx.Error(w, http.StatusBadRequest, "name required")
+		return false
+	}
+	// The name ends up in email subjects, see mailer.render
+	if strings.ContainsFunc(req.Name, unicode.IsControl) {
+		httpx.Error(w, http.StatusBadRequest, "name must not contain control characters")
+		return false
+	}
+	if !orgSlugRe.MatchString(req.Slug) {
+		httpx.Error(w, http.StatusBadRequest, "slug must be 2-63 lowercase letters, digits or '-'")
+		return false
+	}
+	return true
+}
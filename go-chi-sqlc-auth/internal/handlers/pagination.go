@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 100
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// pageCursor is the position after (or, with Prev, before) a row in the
+// list's sort order: the sort key's value plus the id as a tie-breaker.
+// Clients treat it as opaque.
+type pageCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    string `json:"id"`
+	Prev  bool   `json:"p,omitempty"`
+}
+
+func (c pageCursor) String() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (pageCursor, error) {
+	var c pageCursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || json.Unmarshal(b, &c) != nil || c.Sort == "" {
+		return c, errInvalidCursor
+	}
+	// uuid.Parse also takes forms Postgres doesn't, like urn:uuid:...
+	id, err := uuid.Parse(c.ID)
+	if err != nil {
+		return c, errInvalidCursor
+	}
+	c.ID = id.String()
+	return c, nil
+}
+
+// sortKey is a whitelisted ORDER BY column. Rows are ordered by the column
+// and then by id, so the order is total and cursors are unambiguous.
+type sortKey struct {
+	Name   string // as given in ?sort=, e.g. "-created_at"
+	Column string
+	Cast   string // SQL type of the column, for the cursor value
+	Desc   bool
+}
+
+// orderBy returns the ORDER BY clause, reversed when walking backwards.
+func (k sortKey) orderBy(backwards bool) string {
+	dir := " ASC"
+	if k.Desc != backwards {
+		dir = " DESC"
+	}
+	return " ORDER BY " + k.Column + dir + ", u.id" + dir
+}
+
+// validValue reports whether the cursor's value can be cast to the column's
+// type, so a tampered cursor is a bad request rather than a failed query.
+func (k sortKey) validValue(c pageCursor) bool {
+	switch k.Cast {
+	case "timestamptz":
+		_, err := time.Parse(time.RFC3339Nano, c.Value)
+		return err == nil
+	case "text":
+		return !strings.ContainsRune(c.Value, 0)
+	}
+	return false
+}
+
+// after returns the condition selecting the rows past c in the walking direction.
+func (k sortKey) after(c pageCursor, arg func(any) string) string {
+	cmp := ">"
+	if k.Desc != c.Prev {
+		cmp = "<"
+	}
+	return "(" + k.Column + ", u.id) " + cmp + " (" + arg(c.Value) + "::" + k.Cast + ", " + arg(c.ID) + "::uuid)"
+}
+
+// Page is the envelope of cursor-paginated lists. Total is only set when
+// the client asks for it with include_total=true.
+type Page[T any] struct {
+	Data       []T    `json:"data"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	PrevCursor string `json:"prev_cursor,omitempty"`
+	Total      *int64 `json:"total,omitempty"`
+}
+
+// pageParams reads limit and cursor from the query string.
+func pageParams(r *http.Request) (limit int, cursor *pageCursor, err error) {
+	limit = defaultPageSize
+	if q := r.URL.Query().Get("limit"); q != "" {
+		limit, err = strconv.Atoi(q)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			return 0, nil, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+		}
+	}
+	if q := r.URL.Query().Get("cursor"); q != "" {
+		c, err := decodeCursor(q)
+		if err != nil {
+			return 0, nil, err
+		}
+		cursor = &c
+	}
+	return limit, cursor, nil
+}
+
+// setPageLinks adds RFC 8288 Link headers for the neighbouring pages, keeping
+// the other query parameters of the request.
+func setPageLinks(w http.ResponseWriter, r *http.Request, next, prev string) {
+	var links []string
+	link := func(cursor, rel string) {
+		q := r.URL.Query()
+		q.Set("cursor", cursor)
+		links = append(links, fmt.Sprintf(`<%s?%s>; rel="%s"`, r.URL.Path, q.Encode(), rel))
+	}
+	if next != "" {
+		link(next, "next")
+	}
+	if prev != "" {
+		link(prev, "prev")
+	}
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+}
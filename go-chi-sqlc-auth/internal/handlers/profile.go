@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"dev.mfr/go-chi-sqlc-auth/internal/audit"
+	"dev.mfr/go-chi-sqlc-auth/internal/httpx"
+	"dev.mfr/go-chi-sqlc-auth/internal/middleware"
+	"dev.mfr/go-chi-sqlc-auth/internal/models"
+	"dev.mfr/go-chi-sqlc-auth/internal/store"
+	"dev.mfr/go-chi-sqlc-auth/internal/validate"
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ProfileHandler manages the custom profile attribute definitions. The values
+// are read and written per user under /users/{id}/attributes.
+type ProfileHandler struct {
+	Pool  *pgxpool.Pool
+	Audit *audit.Logger
+}
+
+func NewProfileHandler(pool *pgxpool.Pool, al *audit.Logger) *ProfileHandler {
+	return &ProfileHandler{Pool: pool, Audit: al}
+}
+
+func (h *ProfileHandler) Routes() http.Handler {
+	r := chi.NewRouter()
+	r.Get("/attributes", h.ListAttributes)
+	r.Get("/attributes/{name}", h.GetAttribute)
+	r.Group(func(mr chi.Router) {
+		mr.Use(middleware.RequirePermission(models.PermProfileAttrs))
+		mr.Post("/attributes", h.CreateAttribute)
+		mr.Put("/attributes/{name}", h.UpdateAttribute)
+		mr.Delete("/attributes/{name}", h.DeleteAttribute)
+	})
+	return r
+}
+
+// ListAttributes returns the attribute definitions, so clients can render
+// profile forms. Admin-only attributes are listed for attribute managers only.
+func (h *ProfileHandler) ListAttributes(w http.ResponseWriter, r *http.Request) {
+	defs, err := store.ProfileAttributes(r.Context(), h.Pool)
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	p := middleware.PrincipalFrom(r.Context())
+	resp := []models.ProfileAttribute{}
+	for _, a := range defs {
+		if a.Visibility != models.VisibilityAdmin || p.Can(models.PermProfileAttrs) {
+			resp = append(resp, a)
+		}
+	}
+	httpx.JSON(w, http.StatusOK, resp)
+}
+
+func (h *ProfileHandler) GetAttribute(w http.ResponseWriter, r *http.Request) {
+	a, err := store.ProfileAttribute(r.Context(), h.Pool, chi.URLParam(r, "name"))
+	if err == nil && a.Visibility == models.VisibilityAdmin && !middleware.PrincipalFrom(r.Context()).Can(models.PermProfileAttrs) {
+		err = pgx.ErrNoRows
+	}
+	if err == pgx.ErrNoRows {
+		httpx.Error(w, http.StatusNotFound, "not found")
+		return
+	}
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	httpx.JSON(w, http.StatusOK, a)
+}
+
+func (h *ProfileHandler) CreateAttribute(w http.ResponseWriter, r *http.Request) {
+	var req models.ProfileAttribute
+	if err := decodeJSON(r, &req); err != nil {
+		httpx.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.Visibility == "" {
+		req.Visibility = models.VisibilityPrivate
+	}
+	if err := validate.ProfileAttribute(req); err != nil {
+		httpx.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	tx, err := h.Pool.Begin(r.Context())
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer tx.Rollback(r.Context())
+	a, err := store.CreateProfileAttribute(r.Context(), tx, req)
+	if err != nil {
+		httpx.Error(w, http.StatusConflict, parsePGError(err))
+		return
+	}
+	if !h.commit(w, r, tx, "profile.attribute_created", a.Name, map[string]any{"attribute": a}) {
+		return
+	}
+	w.Header().Set("Location", "/profile/attributes/"+a.Name)
+	httpx.JSON(w, http.StatusCreated, a)
+}
+
+// UpdateAttribute changes an attribute's label, rules and visibility; fields
+// left out keep their value. The
+// type is fixed because stored values would no longer fit; values already
+// stored are not revalidated against new rules.
+func (h *ProfileHandler) UpdateAttribute(w http.ResponseWriter, r *http.Request) {
+	current, err := store.ProfileAttribute(r.Context(), h.Pool, chi.URLParam(r, "name"))
+	if err == pgx.ErrNoRows {
+		httpx.Error(w, http.StatusNotFound, "not found")
+		return
+	}
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	req := current
+	if err := decodeJSON(r, &req); err != nil {
+		httpx.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.Name != current.Name {
+		httpx.Error(w, http.StatusBadRequest, "name cannot be changed")
+		return
+	}
+	if req.Type != current.Type {
+		httpx.Error(w, http.StatusConflict, "type cannot be changed; delete the attribute and create it again")
+		return
+	}
+	if err := validate.ProfileAttribute(req); err != nil {
+		httpx.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	tx, err := h.Pool.Begin(r.Context())
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer tx.Rollback(r.Context())
+	a, err := store.UpdateProfileAttribute(r.Context(), tx, req)
+	if err == pgx.ErrNoRows {
+		httpx.Error(w, http.StatusNotFound, "not found")
+		return
+	}
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !h.commit(w, r, tx, "profile.attribute_updated", a.Name, map[string]any{"attribute": a}) {
+		return
+	}
+	httpx.JSON(w, http.StatusOK, a)
+}
+
+// DeleteAttribute removes the definition together with every user's value.
+func (h *ProfileHandler) DeleteAttribute(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	tx, err := h.Pool.Begin(r.Context())
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer tx.Rollback(r.Context())
+	n, err := store.DeleteProfileAttribute(r.Context(), tx, name)
+	if errors.Is(err, pgx.ErrNoRows) {
+		httpx.Error(w, http.StatusNotFound, "not found")
+		return
+	}
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !h.commit(w, r, tx, "profile.attribute_deleted", name, map[string]any{"values_removed": n}) {
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// commit audits a change to a definition and commits it. On failure it
+// writes the response and returns false.
+func (h *ProfileHandler) commit(w http.ResponseWriter, r *http.Request, tx pgx.Tx, action, name string, data map[string]any) bool {
+	uid, _ := r.Context().Value(middleware.CtxUserID).(string)
+	entry := audit.Entry{ActorID: &uid, Action: action, TargetType: "profile_attribute", TargetID: name, Data: data}
+	if _, err := h.Audit.Record(r.Context(), tx, entry); err != nil {
+		httpx.Error(w, http.StatusInternalServerError, "failed to write audit log")
+		return false
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return false
+	}
+	return true
+}
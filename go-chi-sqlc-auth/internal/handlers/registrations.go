@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"dev.mfr/go-chi-sqlc-auth/internal/auth"
+	"dev.mfr/go-chi-sqlc-auth/internal/authz"
+	"dev.mfr/go-chi-sqlc-auth/internal/httpx"
+	"dev.mfr/go-chi-sqlc-auth/internal/mailer"
+	"dev.mfr/go-chi-sqlc-auth/internal/middleware"
+	"dev.mfr/go-chi-sqlc-auth/internal/models"
+	"dev.mfr/go-chi-sqlc-auth/internal/store"
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// RegistrationsHandler is the admin side of registration: the approval queue
+// and invite-only registration codes.
+type RegistrationsHandler struct {
+	Pool   *pgxpool.Pool
+	Authz  *authz.Engine
+	Mailer mailer.Mailer
+}
+
+func NewRegistrationsHandler(pool *pgxpool.Pool, az *authz.Engine, m mailer.Mailer) *RegistrationsHandler {
+	return &RegistrationsHandler{Pool: pool, Authz: az, Mailer: m}
+}
+
+func (h *RegistrationsHandler) Routes() http.Handler {
+	r := chi.NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if authorize(w, r, h.Authz, "registrations:manage", authz.Resource{Type: "registration"}) {
+				next.ServeHTTP(w, r)
+			}
+		})
+	})
+	r.Get("/", h.ListPending)
+	r.Post("/{id}/approve", h.Approve)
+	r.Post("/{id}/reject", h.Reject)
+	r.Get("/codes", h.ListCodes)
+	r.Post("/codes", h.CreateCode)
+	r.Delete("/codes/{id}", h.RevokeCode)
+	return r
+}
+
+// ListPending returns accounts waiting for approval, oldest first.
+func (h *RegistrationsHandler) ListPending(w http.ResponseWriter, r *http.Request) {
+	rows, err := h.Pool.Query(r.Context(), "SELECT id, username, email, first_name, last_name, created_at FROM users WHERE status=$1 AND deleted_at IS NULL ORDER BY created_at", models.StatusPending)
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+	type pending struct {
+		ID        string    `json:"id"`
+		Username  string    `json:"username"`
+		Email     string    `json:"email"`
+		FirstName string    `json:"first_name"`
+		LastName  string    `json:"last_name"`
+		CreatedAt time.Time `json:"created_at"`
+	}
+	resp := []pending{}
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.ID, &p.Username, &p.Email, &p.FirstName, &p.LastName, &p.CreatedAt); err != nil {
+			httpx.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		resp = append(resp, p)
+	}
+	httpx.JSON(w, http.StatusOK, resp)
+}
+
+func (h *RegistrationsHandler) Approve(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	var email string
+	err := h.Pool.QueryRow(r.Context(), "SELECT email FROM users WHERE id=$1 AND status=$2 AND deleted_at IS NULL", id, models.StatusPending).Scan(&email)
+	if err == pgx.ErrNoRows {
+		httpx.Error(w, http.StatusNotFound, "no pending registration")
+		return
+	}
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	uid, _ := r.Context().Value(middleware.CtxUserID).(string)
+	_, err = store.TransitionStatus(r.Context(), h.Pool, id, models.StatusActive, "registration approved", uid)
+	if errors.Is(err, store.ErrStatusConflict) {
+		httpx.Error(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	msg := mailer.Message{To: email, Subject: "Your account has been approved", Body: "Your account has been approved. You can now sign in.\n"}
+	if err := h.Mailer.Send(r.Context(), msg); err != nil {
+		httpx.Error(w, http.StatusBadGateway, "approved but notification email failed: "+err.Error())
+		return
+	}
+	httpx.JSON(w, http.StatusOK, map[string]any{"id": id, "status": models.StatusActive})
+}
+
+// Reject deletes a pending account so its email and username can be used again.
+func (h *RegistrationsHandler) Reject(w http.ResponseWriter, r *http.Request) {
+	ct, err := h.Pool.Exec(r.Context(), "DELETE FROM users WHERE id=$1 AND status=$2 AND deleted_at IS NULL", chi.URLParam(r, "id"), models.StatusPending)
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if ct.RowsAffected() == 0 {
+		httpx.Error(w, http.StatusNotFound, "no pending registration")
+		return
+	}
+	httpx.JSON(w, http.StatusOK, map[string]any{"deleted": 1})
+}
+
+const registrationCodeColumns = "id, note, max_uses, uses, expires_at, revoked_at, created_by, created_at"
+
+func scanRegistrationCode(row pgx.Row, c *models.RegistrationCode) error {
+	return row.Scan(&c.ID, &c.Note, &c.MaxUses, &c.Uses, &c.ExpiresAt, &c.RevokedAt, &c.CreatedBy, &c.CreatedAt)
+}
+
+func (h *RegistrationsHandler) ListCodes(w http.ResponseWriter, r *http.Request) {
+	rows, err := h.Pool.Query(r.Context(), "SELECT "+registrationCodeColumns+" FROM registration_codes ORDER BY created_at DESC")
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+	resp := []models.RegistrationCode{}
+	for rows.Next() {
+		var c models.RegistrationCode
+		if err := scanRegistrationCode(rows, &c); err != nil {
+			httpx.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		resp = append(resp, c)
+	}
+	httpx.JSON(w, http.StatusOK, resp)
+}
+
+// CreateCode issues a registration code. The code is only returned in this response.
+func (h *RegistrationsHandler) CreateCode(w http.ResponseWriter, r *http.Request) {
+	var req models.RegistrationCodeRequest
+	if err := decodeJSON(r, &req); err != nil {
+		httpx.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.MaxUses != nil && *req.MaxUses < 1 {
+		httpx.Error(w, http.StatusBadRequest, "max_uses must be positive")
+		return
+	}
+	code, hash, err := auth.NewOpaqueToken()
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, "failed to generate code")
+		return
+	}
+	uid, _ := r.Context().Value(middleware.CtxUserID).(string)
+	var c models.RegistrationCode
+	err = scanRegistrationCode(h.Pool.QueryRow(r.Context(), `INSERT INTO registration_codes (code_hash, note, max_uses, expires_at, created_by)
+		VALUES ($1,$2,$3,$4,$5) RETURNING `+registrationCodeColumns, hash, req.Note, req.MaxUses, req.ExpiresAt, uid), &c)
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.Code = code
+	httpx.JSON(w, http.StatusCreated, c)
+}
+
+func (h *RegistrationsHandler) RevokeCode(w http.ResponseWriter, r *http.Request) {
+	var c models.RegistrationCode
+	err := scanRegistrationCode(h.Pool.QueryRow(r.Context(), "UPDATE registration_codes SET revoked_at=now() WHERE id=$1 AND revoked_at IS NULL RETURNING "+registrationCodeColumns,
+		chi.URLParam(r, "id")), &c)
+	if err == pgx.ErrNoRows {
+		httpx.Error(w, http.StatusNotFound, "not found")
+		return
+	}
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	httpx.JSON(w, http.StatusOK, c)
+}
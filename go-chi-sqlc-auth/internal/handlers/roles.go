@@ -0,0 +1,256 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+
+	"dev.mfr/go-chi-sqlc-auth/internal/authz"
+	"dev.mfr/go-chi-sqlc-auth/internal/httpx"
+	"dev.mfr/go-chi-sqlc-auth/internal/middleware"
+	"dev.mfr/go-chi-sqlc-auth/internal/models"
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var roleNameRe = regexp.MustCompile(`^[a-z][a-z0-9_-]{1,31}$`)
+
+var errUnknownPermission = errors.New("unknown permission")
+
+type RolesHandler struct {
+	Pool  *pgxpool.Pool
+	Authz *authz.Engine
+}
+
+func NewRolesHandler(pool *pgxpool.Pool, az *authz.Engine) *RolesHandler {
+	return &RolesHandler{Pool: pool, Authz: az}
+}
+
+func (h *RolesHandler) Routes() http.Handler {
+	r := chi.NewRouter()
+	r.Group(func(rr chi.Router) {
+		rr.Use(middleware.RequirePermission(models.PermRolesRead))
+		rr.Get("/", h.List)
+		rr.Get("/permissions", h.ListPermissions)
+		rr.Get("/{id}", h.Get)
+	})
+	r.Group(func(mr chi.Router) {
+		mr.Use(middleware.RequirePermission(models.PermRolesManage))
+		mr.Post("/", h.Create)
+		mr.Put("/{id}", h.Update)
+		mr.Delete("/{id}", h.Delete)
+	})
+	return r
+}
+
+const roleSelectSQL = `SELECT r.id, r.name, r.description, r.built_in, r.level,
+	COALESCE((SELECT array_agg(rp.permission ORDER BY rp.permission) FROM role_permissions rp WHERE rp.role_id = r.id), '{}'),
+	r.created_at, r.updated_at
+	FROM roles r`
+
+func scanRole(row pgx.Row, d *models.RoleDefinition) error {
+	return row.Scan(&d.ID, &d.Name, &d.Description, &d.BuiltIn, &d.Level, &d.Permissions, &d.CreatedAt, &d.UpdatedAt)
+}
+
+func (h *RolesHandler) List(w http.ResponseWriter, r *http.Request) {
+	rows, err := h.Pool.Query(r.Context(), roleSelectSQL+" ORDER BY r.name")
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+	resp := []models.RoleDefinition{}
+	for rows.Next() {
+		var d models.RoleDefinition
+		if err := scanRole(rows, &d); err != nil {
+			httpx.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		resp = append(resp, d)
+	}
+	httpx.JSON(w, http.StatusOK, resp)
+}
+
+func (h *RolesHandler) ListPermissions(w http.ResponseWriter, r *http.Request) {
+	rows, err := h.Pool.Query(r.Context(), "SELECT name, description FROM permissions ORDER BY name")
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+	resp := []models.PermissionDefinition{}
+	for rows.Next() {
+		var p models.PermissionDefinition
+		if err := rows.Scan(&p.Name, &p.Description); err != nil {
+			httpx.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		resp = append(resp, p)
+	}
+	httpx.JSON(w, http.StatusOK, resp)
+}
+
+func (h *RolesHandler) Get(w http.ResponseWriter, r *http.Request) {
+	var d models.RoleDefinition
+	err := scanRole(h.Pool.QueryRow(r.Context(), roleSelectSQL+" WHERE r.id=$1", chi.URLParam(r, "id")), &d)
+	if err == pgx.ErrNoRows {
+		httpx.Error(w, http.StatusNotFound, "not found")
+		return
+	}
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	httpx.JSON(w, http.StatusOK, d)
+}
+
+func (h *RolesHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var req models.RoleRequest
+	if err := decodeJSON(r, &req); err != nil {
+		httpx.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !roleNameRe.MatchString(string(req.Name)) {
+		httpx.Error(w, http.StatusBadRequest, "name must be 2-32 lowercase letters, digits, '-' or '_'")
+		return
+	}
+	if !authorize(w, r, h.Authz, "roles:edit", authz.RoleResource(req.Name, req.Level)) {
+		return
+	}
+	tx, err := h.Pool.Begin(r.Context())
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer tx.Rollback(r.Context())
+	var id string
+	if err := tx.QueryRow(r.Context(), "INSERT INTO roles (name, description, level) VALUES ($1,$2,$3) RETURNING id", req.Name, req.Description, req.Level).Scan(&id); err != nil {
+		httpx.Error(w, http.StatusConflict, parsePGError(err))
+		return
+	}
+	if err := setRolePermissions(r, tx, id, req.Permissions); err != nil {
+		httpx.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var d models.RoleDefinition
+	if err := scanRole(tx.QueryRow(r.Context(), roleSelectSQL+" WHERE r.id=$1", id), &d); err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	httpx.JSON(w, http.StatusCreated, d)
+}
+
+// Update changes a role's description and permissions. Names are immutable
+// because role names are what clients assign and tokens carry.
+func (h *RolesHandler) Update(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	var req models.RoleRequest
+	if err := decodeJSON(r, &req); err != nil {
+		httpx.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	tx, err := h.Pool.Begin(r.Context())
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer tx.Rollback(r.Context())
+	var (
+		name     models.Role
+		oldLevel int
+	)
+	err = tx.QueryRow(r.Context(), "SELECT name, level FROM roles WHERE id=$1 FOR UPDATE", id).Scan(&name, &oldLevel)
+	if err == pgx.ErrNoRows {
+		httpx.Error(w, http.StatusNotFound, "not found")
+		return
+	}
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	// The admin role always keeps every permission so the system can't be locked out
+	if name == models.RoleAdmin {
+		httpx.Error(w, http.StatusBadRequest, "admin role permissions cannot be changed")
+		return
+	}
+	// Only roles below the caller's level can be edited, and not raised to or above it
+	if !authorize(w, r, h.Authz, "roles:edit", authz.RoleResource(name, oldLevel)) ||
+		!authorize(w, r, h.Authz, "roles:edit", authz.RoleResource(name, req.Level)) {
+		return
+	}
+	if _, err := tx.Exec(r.Context(), "UPDATE roles SET description=$2, level=$3, updated_at=now() WHERE id=$1", id, req.Description, req.Level); err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := setRolePermissions(r, tx, id, req.Permissions); err != nil {
+		httpx.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var d models.RoleDefinition
+	if err := scanRole(tx.QueryRow(r.Context(), roleSelectSQL+" WHERE r.id=$1", id), &d); err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	httpx.JSON(w, http.StatusOK, d)
+}
+
+func (h *RolesHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	var (
+		name    models.Role
+		builtIn bool
+		level   int
+	)
+	err := h.Pool.QueryRow(r.Context(), "SELECT name, built_in, level FROM roles WHERE id=$1", id).Scan(&name, &builtIn, &level)
+	if err == pgx.ErrNoRows {
+		httpx.Error(w, http.StatusNotFound, "not found")
+		return
+	}
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if builtIn {
+		httpx.Error(w, http.StatusBadRequest, "built-in roles cannot be deleted")
+		return
+	}
+	if !authorize(w, r, h.Authz, "roles:edit", authz.RoleResource(name, level)) {
+		return
+	}
+	if _, err := h.Pool.Exec(r.Context(), "DELETE FROM roles WHERE id=$1", id); err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	httpx.JSON(w, http.StatusOK, map[string]any{"deleted": 1})
+}
+
+func setRolePermissions(r *http.Request, tx pgx.Tx, roleID string, perms []models.Permission) error {
+	names := make([]string, 0, len(perms))
+	seen := map[models.Permission]struct{}{}
+	for _, p := range perms {
+		if _, ok := seen[p]; !ok {
+			seen[p] = struct{}{}
+			names = append(names, string(p))
+		}
+	}
+	if _, err := tx.Exec(r.Context(), "DELETE FROM role_permissions WHERE role_id=$1", roleID); err != nil {
+		return err
+	}
+	ct, err := tx.Exec(r.Context(), "INSERT INTO role_permissions (role_id, permission) SELECT $1, name FROM permissions WHERE name = ANY($2)", roleID, names)
+	if err != nil {
+		return err
+	}
+	if int(ct.RowsAffected()) != len(names) {
+		return errUnknownPermission
+	}
+	return nil
+}
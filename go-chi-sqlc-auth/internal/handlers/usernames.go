@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"net/http"
+
+	"dev.mfr/go-chi-sqlc-auth/internal/authz"
+	"dev.mfr/go-chi-sqlc-auth/internal/httpx"
+	"dev.mfr/go-chi-sqlc-auth/internal/middleware"
+	"dev.mfr/go-chi-sqlc-auth/internal/models"
+	"dev.mfr/go-chi-sqlc-auth/internal/usernames"
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// UsernamesHandler is the moderation side of usernames: the configured
+// reserved names and the blocklist admins manage.
+type UsernamesHandler struct {
+	Pool      *pgxpool.Pool
+	Authz     *authz.Engine
+	Usernames *usernames.Policy
+}
+
+func NewUsernamesHandler(pool *pgxpool.Pool, az *authz.Engine, names *usernames.Policy) *UsernamesHandler {
+	return &UsernamesHandler{Pool: pool, Authz: az, Usernames: names}
+}
+
+func (h *UsernamesHandler) Routes() http.Handler {
+	r := chi.NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if authorize(w, r, h.Authz, "usernames:manage", authz.Resource{Type: "username"}) {
+				next.ServeHTTP(w, r)
+			}
+		})
+	})
+	r.Get("/reserved", h.ListReserved)
+	r.Get("/blocked", h.ListBlocked)
+	r.Post("/blocked", h.Block)
+	r.Delete("/blocked/{id}", h.Unblock)
+	return r
+}
+
+// ListReserved returns the reserved usernames; they are changed in configuration.
+func (h *UsernamesHandler) ListReserved(w http.ResponseWriter, r *http.Request) {
+	httpx.JSON(w, http.StatusOK, map[string]any{"usernames": h.Usernames.Reserved()})
+}
+
+const blockedUsernameColumns = "id, pattern, note, created_by, created_at"
+
+func scanBlockedUsername(row pgx.Row, b *models.BlockedUsername) error {
+	return row.Scan(&b.ID, &b.Pattern, &b.Note, &b.CreatedBy, &b.CreatedAt)
+}
+
+func (h *UsernamesHandler) ListBlocked(w http.ResponseWriter, r *http.Request) {
+	rows, err := h.Pool.Query(r.Context(), "SELECT "+blockedUsernameColumns+" FROM username_blocklist ORDER BY pattern")
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+	resp := []models.BlockedUsername{}
+	for rows.Next() {
+		var b models.BlockedUsername
+		if err := scanBlockedUsername(rows, &b); err != nil {
+			httpx.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		resp = append(resp, b)
+	}
+	httpx.JSON(w, http.StatusOK, resp)
+}
+
+// Block adds a pattern to the blocklist. It applies to usernames chosen from
+// now on; users who already have a matching name keep it.
+func (h *UsernamesHandler) Block(w http.ResponseWriter, r *http.Request) {
+	var req models.BlockedUsernameRequest
+	if err := decodeJSON(r, &req); err != nil {
+		httpx.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	pattern, err := usernames.NormalizePattern(req.Pattern)
+	if err != nil {
+		httpx.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	uid, _ := r.Context().Value(middleware.CtxUserID).(string)
+	var b models.BlockedUsername
+	err = scanBlockedUsername(h.Pool.QueryRow(r.Context(), `INSERT INTO username_blocklist (pattern, note, created_by)
+		VALUES ($1,$2,$3) RETURNING `+blockedUsernameColumns, pattern, req.Note, uid), &b)
+	if isUniqueViolation(err) {
+		httpx.Error(w, http.StatusConflict, parsePGError(err))
+		return
+	}
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	httpx.JSON(w, http.StatusCreated, b)
+}
+
+func (h *UsernamesHandler) Unblock(w http.ResponseWriter, r *http.Request) {
+	ct, err := h.Pool.Exec(r.Context(), "DELETE FROM username_blocklist WHERE id=$1", chi.URLParam(r, "id"))
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if ct.RowsAffected() == 0 {
+		httpx.Error(w, http.StatusNotFound, "not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
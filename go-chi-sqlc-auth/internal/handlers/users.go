@@ -1,109 +1,407 @@
 package handlers
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
 	"net/http"
-	"strconv"
+	"strings"
 	"time"
 
+	"dev.mfr/go-chi-sqlc-auth/internal/audit"
+	"dev.mfr/go-chi-sqlc-auth/internal/auth"
+	"dev.mfr/go-chi-sqlc-auth/internal/authz"
+	"dev.mfr/go-chi-sqlc-auth/internal/config"
+	"dev.mfr/go-chi-sqlc-auth/internal/confusables"
 	"dev.mfr/go-chi-sqlc-auth/internal/httpx"
+	"dev.mfr/go-chi-sqlc-auth/internal/importer"
+	"dev.mfr/go-chi-sqlc-auth/internal/jsonpatch"
+	"dev.mfr/go-chi-sqlc-auth/internal/mailer"
 	"dev.mfr/go-chi-sqlc-auth/internal/middleware"
 	"dev.mfr/go-chi-sqlc-auth/internal/models"
+	"dev.mfr/go-chi-sqlc-auth/internal/storage"
+	"dev.mfr/go-chi-sqlc-auth/internal/store"
+	"dev.mfr/go-chi-sqlc-auth/internal/usernames"
+	"dev.mfr/go-chi-sqlc-auth/internal/validate"
 	"github.com/go-chi/chi/v5"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// userRolesSQL aggregates a user's role names; it expects the users table aliased as u.
+const userRolesSQL = `COALESCE((SELECT array_agg(r.name ORDER BY r.name) FROM user_roles ur JOIN roles r ON r.id = ur.role_id WHERE ur.user_id = u.id), '{}')`
+
+// errRoleNotGrantable is returned when a role being added or removed is not below the caller's level.
+var errRoleNotGrantable = errors.New("role is not below your level")
+
+// userPhoneSQL builds the models.Phone object, NULL without a number; it expects the users table aliased as u.
+const userPhoneSQL = `CASE WHEN u.phone_number IS NOT NULL THEN jsonb_build_object('number', u.phone_number,
+	'region', COALESCE(u.phone_region, ''), 'verified', u.phone_verified_at IS NOT NULL, 'verified_at', u.phone_verified_at) END`
+
+// userColumns is the select list scanned by scanUser; it expects the users table aliased as u.
+const userColumns = "u.id, u.username, u.email, u.first_name, u.last_name, " + userPhoneSQL +
+	", u.address, u.legacy_phone_number, u.legacy_address, u.avatar_url, " + userRolesSQL +
+	", u.status, u.status_reason, u.status_changed_at, u.deleted_at, u.version, u.created_at, u.updated_at"
+
+func scanUser(row pgx.Row, u *models.User) error {
+	return row.Scan(&u.ID, &u.Username, &u.Email, &u.FirstName, &u.LastName, &u.Phone, &u.Address, &u.LegacyPhoneNumber, &u.LegacyAddress, &u.AvatarURL, &u.Roles,
+		&u.Status, &u.StatusReason, &u.StatusChangedAt, &u.DeletedAt, &u.Version, &u.CreatedAt, &u.UpdatedAt)
+}
+
 type UsersHandler struct {
-	Pool *pgxpool.Pool
+	Pool   *pgxpool.Pool
+	Authz  *authz.Engine
+	Audit  *audit.Logger
+	Mailer mailer.Mailer
+	// AppURL prefixes the set-password links in emails.
+	AppURL string
+	// ReauthMaxAge is how recent a login must be for delete, role and password changes.
+	ReauthMaxAge time.Duration
+	// RequireIfMatch rejects PUT, PATCH and DELETE without an If-Match header.
+	RequireIfMatch bool
+	// PasswordSetupTTL is how long the set-password link of a created user works.
+	PasswordSetupTTL time.Duration
+	Imports          *importer.Service
+	// Imports with more rows than ImportSyncRows run in the background.
+	ImportSyncRows int
+	ImportMaxRows  int
+	ImportMaxBytes int64
+	// Blobs holds the avatar thumbnails; uploads over AvatarMaxBytes are refused.
+	Blobs          storage.BlobStore
+	AvatarMaxBytes int64
+	// Usernames are checked when a user is renamed; admins creating users may pick reserved ones.
+	Usernames *usernames.Policy
 }
 
-func NewUsersHandler(pool *pgxpool.Pool) *UsersHandler {
-	return &UsersHandler{Pool: pool}
+func NewUsersHandler(pool *pgxpool.Pool, az *authz.Engine, al *audit.Logger, mail mailer.Mailer, imports *importer.Service,
+	blobs storage.BlobStore, names *usernames.Policy, appURL string, reauthMaxAge time.Duration, cfg config.UsersConfig) *UsersHandler {
+	return &UsersHandler{Pool: pool, Authz: az, Audit: al, Mailer: mail, AppURL: appURL, ReauthMaxAge: reauthMaxAge,
+		RequireIfMatch: cfg.RequireIfMatch, PasswordSetupTTL: time.Duration(cfg.PasswordSetupHours) * time.Hour,
+		Imports: imports, ImportSyncRows: cfg.ImportSyncRows, ImportMaxRows: cfg.ImportMaxRows, ImportMaxBytes: int64(cfg.ImportMaxMB) << 20,
+		Blobs: blobs, AvatarMaxBytes: int64(cfg.AvatarMaxMB) << 20, Usernames: names}
 }
 
 func (h *UsersHandler) Routes() http.Handler {
 	r := chi.NewRouter()
+	stepUp := middleware.RequireRecentAuth(h.ReauthMaxAge)
 	r.Get("/", h.List)
+	r.Post("/", h.Create)
+	r.With(middleware.RequirePermission(models.PermUsersCreate)).Post("/import", h.Import)
+	r.Get("/import/{importID}", h.GetImport)
+	r.Get("/export", h.Export)
+	r.Post("/batch", h.Batch)
 	r.Get("/{id}", h.Get)
 	r.Put("/{id}", h.Update)
-	r.Delete("/{id}", h.Delete)
-	r.Post("/{id}/password", h.UpdatePassword)
+	r.Patch("/{id}", h.Patch)
+	r.With(stepUp).Delete("/{id}", h.Delete)
+	r.Post("/{id}/restore", h.Restore)
+	r.Put("/{id}/avatar", h.PutAvatar)
+	r.Delete("/{id}/avatar", h.DeleteAvatar)
+	r.Get("/{id}/attributes", h.GetAttributes)
+	r.Patch("/{id}/attributes", h.PatchAttributes)
+	r.Get("/{id}/preferences", h.GetPreferences)
+	r.Put("/{id}/preferences", h.PutPreferences)
+	r.Put("/{id}/phone/verification", h.VerifyPhone)
+	r.Delete("/{id}/phone/verification", h.UnverifyPhone)
+	r.With(stepUp).Post("/{id}/password", h.UpdatePassword)
+	r.Get("/{id}/status", h.GetStatus)
+	r.Post("/{id}/suspend", h.setStatus(models.StatusSuspended))
+	r.Post("/{id}/lock", h.setStatus(models.StatusLocked))
+	r.Post("/{id}/deactivate", h.setStatus(models.StatusDeactivated))
+	r.Post("/{id}/reactivate", h.setStatus(models.StatusActive))
+	r.Get("/{id}/scopes", h.GetScopes)
+	r.With(stepUp).Put("/{id}/scopes", h.SetScopes)
 	return r
 }
 
+// loadUser returns the user with their roles, or pgx.ErrNoRows if they don't exist or are deleted.
+func (h *UsersHandler) loadUser(ctx context.Context, id string) (models.User, error) {
+	var u models.User
+	err := scanUser(h.Pool.QueryRow(ctx, "SELECT "+userColumns+" FROM users u WHERE u.id=$1 AND u.deleted_at IS NULL", id), &u)
+	return u, err
+}
+
+// authorizeUser loads the user in the URL and asks the policy whether the caller
+// may perform action on it. On failure it writes the response and returns false.
+// Deleted users are not found.
+func (h *UsersHandler) authorizeUser(w http.ResponseWriter, r *http.Request, action, id string) (models.ManagedUser, bool) {
+	return h.authorizeTarget(w, r, action, id, store.ManagedUser)
+}
+
+func (h *UsersHandler) authorizeTarget(w http.ResponseWriter, r *http.Request, action, id string,
+	load func(context.Context, store.DBTX, string) (models.ManagedUser, error)) (models.ManagedUser, bool) {
+	t, err := load(r.Context(), h.Pool, id)
+	if err == pgx.ErrNoRows {
+		httpx.Error(w, http.StatusNotFound, "not found")
+		return t, false
+	}
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return t, false
+	}
+	if !authorize(w, r, h.Authz, action, authz.UserResource(t)) {
+		return t, false
+	}
+	return t, true
+}
+
+// List returns the users the caller may see, filtered and sorted by the query
+// string and paginated with opaque cursors over (sort column, id).
 func (h *UsersHandler) List(w http.ResponseWriter, r *http.Request) {
-	// Only admin
-	role, _ := r.Context().Value(middleware.CtxRole).(models.Role)
-	if role != models.RoleAdmin {
-		httpx.Error(w, http.StatusForbidden, "forbidden")
+	if !authorize(w, r, h.Authz, "users:list", authz.Resource{Type: "user"}) {
 		return
 	}
-	limit := 50
-	offset := 0
-	if q := r.URL.Query().Get("limit"); q != "" {
-		if v, err := strconv.Atoi(q); err == nil {
-			limit = v
-		}
+	limit, cursor, err := pageParams(r)
+	if err != nil {
+		httpx.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	key, value, err := userSort(r)
+	if err != nil {
+		httpx.Error(w, http.StatusBadRequest, err.Error())
+		return
 	}
-	if q := r.URL.Query().Get("offset"); q != "" {
-		if v, err := strconv.Atoi(q); err == nil {
-			offset = v
+	// A cursor only makes sense in the order it was issued for
+	if cursor != nil && cursor.Sort != key.Name {
+		httpx.Error(w, http.StatusBadRequest, "cursor does not match sort")
+		return
+	}
+	if cursor != nil && !key.validValue(*cursor) {
+		httpx.Error(w, http.StatusBadRequest, errInvalidCursor.Error())
+		return
+	}
+	proj, err := parseProjection(r)
+	if err != nil {
+		httpx.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !proj.authorize(w, r, h.Authz, authz.Resource{Type: "user"}) {
+		return
+	}
+	q, ok := h.userListQuery(w, r)
+	if !ok {
+		return
+	}
+
+	resp := Page[any]{Data: []any{}}
+	if r.URL.Query().Get("include_total") == "true" {
+		var total int64
+		if err := h.Pool.QueryRow(r.Context(), "SELECT count(*) FROM users u"+q.whereSQL(), q.args...).Scan(&total); err != nil {
+			httpx.Error(w, http.StatusInternalServerError, err.Error())
+			return
 		}
+		resp.Total = &total
 	}
-	rows, err := h.Pool.Query(r.Context(), "SELECT id, username, email, first_name, last_name, phone_number, address, role, created_at, updated_at FROM users ORDER BY created_at DESC LIMIT $1 OFFSET $2", limit, offset)
+	// Walking backwards flips the comparison and the order; the rows are reversed below
+	backwards := cursor != nil && cursor.Prev
+	if cursor != nil {
+		q.and(key.after(*cursor, q.arg))
+	}
+	// One extra row tells whether there is another page
+	cols, dest := proj.columns(key.Column)
+	rows, err := h.Pool.Query(r.Context(), "SELECT "+cols+" FROM users u"+q.whereSQL()+key.orderBy(backwards)+" LIMIT "+q.arg(limit+1), q.args...)
 	if err != nil {
 		httpx.Error(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 	defer rows.Close()
-	type user struct {
-		ID          string      `json:"id"`
-		Username    string      `json:"username"`
-		Email       string      `json:"email"`
-		FirstName   string      `json:"first_name"`
-		LastName    string      `json:"last_name"`
-		PhoneNumber *string     `json:"phone_number"`
-		Address     *string     `json:"address"`
-		Role        models.Role `json:"role"`
-		CreatedAt   time.Time   `json:"created_at"`
-		UpdatedAt   time.Time   `json:"updated_at"`
-	}
-	var resp []user
+	users := []models.User{}
 	for rows.Next() {
-		var u user
-		if err := rows.Scan(&u.ID, &u.Username, &u.Email, &u.FirstName, &u.LastName, &u.PhoneNumber, &u.Address, &u.Role, &u.CreatedAt, &u.UpdatedAt); err != nil {
+		var u models.User
+		if err := rows.Scan(dest(&u)...); err != nil {
 			httpx.Error(w, http.StatusInternalServerError, err.Error())
 			return
 		}
-		resp = append(resp, u)
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	more := len(users) > limit
+	if more {
+		users = users[:limit]
 	}
+	if backwards {
+		for a, b := 0, len(users)-1; a < b; a, b = a+1, b-1 {
+			users[a], users[b] = users[b], users[a]
+		}
+	}
+	ids := make([]string, len(users))
+	for i, u := range users {
+		ids[i] = u.ID
+	}
+	related, err := proj.expandUsers(r.Context(), h.Pool, ids)
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	for i := range users {
+		resp.Data = append(resp.Data, proj.render(&users[i], related[users[i].ID]))
+	}
+	if n := len(users); n > 0 {
+		first, last := users[0], users[n-1]
+		if more || backwards {
+			resp.NextCursor = pageCursor{Sort: key.Name, Value: value(last), ID: last.ID}.String()
+		}
+		if (more && backwards) || (cursor != nil && !backwards) {
+			resp.PrevCursor = pageCursor{Sort: key.Name, Value: value(first), ID: first.ID, Prev: true}.String()
+		}
+	}
+	setPageLinks(w, r, resp.NextCursor, resp.PrevCursor)
 	httpx.JSON(w, http.StatusOK, resp)
 }
 
+// Create lets an admin open an account for someone else. The account has no
+// password; the user chooses one through an emailed single-use link.
+func (h *UsersHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var req models.AdminCreateUserRequest
+	if err := decodeJSON(r, &req); err != nil {
+		httpx.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	req.Username, req.Email = validate.NormalizeUsername(req.Username), validate.NormalizeEmail(req.Email)
+	err := validate.User(validate.UserFields{Username: req.Username, Email: req.Email, FirstName: req.FirstName,
+		LastName: req.LastName, Phone: req.Phone, Address: req.Address})
+	if err != nil {
+		httpx.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	target := models.ManagedUser{EmailDomain: strings.ToLower(req.Email[strings.LastIndex(req.Email, "@")+1:])}
+	if !authorize(w, r, h.Authz, "users:create", authz.UserResource(target)) {
+		return
+	}
+	if len(req.Roles) == 0 {
+		req.Roles = []models.Role{models.RoleUser}
+	}
+	// Handing out roles at creation is checked like assigning them later
+	if len(req.Roles) != 1 || req.Roles[0] != models.RoleUser {
+		if !authorize(w, r, h.Authz, "users:role:assign", authz.UserResource(target)) {
+			return
+		}
+		err := h.checkRoleGrants(r, nil, req.Roles)
+		switch {
+		case errors.Is(err, store.ErrUnknownRole):
+			httpx.Error(w, http.StatusBadRequest, err.Error())
+			return
+		case errors.Is(err, errRoleNotGrantable):
+			httpx.Error(w, http.StatusForbidden, err.Error())
+			return
+		case err != nil:
+			httpx.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if !middleware.RecentlyAuthenticated(r.Context(), h.ReauthMaxAge) {
+			middleware.ReauthRequired(w, h.ReauthMaxAge)
+			return
+		}
+	}
+	token, hash, err := auth.NewOpaqueToken()
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, "failed to generate token")
+		return
+	}
+	uid, _ := r.Context().Value(middleware.CtxUserID).(string)
+	p := middleware.PrincipalFrom(r.Context())
+	expires := time.Now().Add(h.PasswordSetupTTL)
+
+	tx, err := h.Pool.Begin(r.Context())
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer tx.Rollback(r.Context())
+	if !checkUsername(w, r, tx, "", req.Username) {
+		return
+	}
+	// The empty password hash never matches, so the account can't be used until the link is redeemed
+	var id string
+	number, region := phoneColumns(req.Phone)
+	err = tx.QueryRow(r.Context(), `INSERT INTO users (username, username_skeleton, email, password_hash, first_name, last_name, phone_number, phone_region, address)
+		VALUES ($1,$2,$3,'',$4,$5,$6,$7,$8) RETURNING id`,
+		req.Username, confusables.Skeleton(req.Username), req.Email, req.FirstName, req.LastName, number, region, req.Address).Scan(&id)
+	if err != nil {
+		httpx.Error(w, http.StatusBadRequest, parsePGError(err))
+		return
+	}
+	err = store.SetUserRoles(r.Context(), tx, id, req.Roles)
+	if errors.Is(err, store.ErrUnknownRole) {
+		httpx.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	// The new user joins the creator's active organization, so they can see and manage them
+	if p.OrgID != "" {
+		if _, err := tx.Exec(r.Context(), "INSERT INTO memberships (user_id, org_id) VALUES ($1,$2) ON CONFLICT DO NOTHING", id, p.OrgID); err != nil {
+			httpx.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	if err := store.CreatePasswordSetup(r.Context(), tx, id, req.Email, uid, hash, expires); err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	entry := audit.Entry{ActorID: &uid, Action: "user.created", TargetType: "user", TargetID: id,
+		Data: map[string]any{"roles": req.Roles, "org_id": p.OrgID}}
+	if _, err := h.Audit.Record(r.Context(), tx, entry); err != nil {
+		httpx.Error(w, http.StatusInternalServerError, "failed to write audit log")
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	u, err := h.loadUser(r.Context(), id)
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Location", "/users/"+id)
+	w.Header().Set("ETag", userETag(u.Version))
+	msg := mailer.Message{
+		To:      u.Email,
+		Subject: "Your account has been created",
+		Body: fmt.Sprintf("Hi %s,\n\nAn account with the username %s has been created for you.\n\n"+
+			"Choose your password at:\n%s/set-password?token=%s\n\n"+
+			"The link works once and expires on %s.\n",
+			u.FirstName, u.Username, h.AppURL, token, expires.UTC().Format(time.RFC1123)),
+	}
+	// The user exists either way; an admin can resend the link with a forced password reset
+	resp := struct {
+		models.User
+		Warning string `json:"warning,omitempty"`
+	}{User: u}
+	if err := h.Mailer.Send(r.Context(), msg); err != nil {
+		resp.Warning = "user created but email failed: " + err.Error()
+	}
+	httpx.JSON(w, http.StatusCreated, resp)
+}
+
 func (h *UsersHandler) Get(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
-	// Allow admin or self
-	role, _ := r.Context().Value(middleware.CtxRole).(models.Role)
-	uid, _ := r.Context().Value(middleware.CtxUserID).(string)
-	if role != models.RoleAdmin && uid != id {
-		httpx.Error(w, http.StatusForbidden, "forbidden")
-		return
-	}
-	var u struct {
-		ID          string      `json:"id"`
-		Username    string      `json:"username"`
-		Email       string      `json:"email"`
-		FirstName   string      `json:"first_name"`
-		LastName    string      `json:"last_name"`
-		PhoneNumber *string     `json:"phone_number"`
-		Address     *string     `json:"address"`
-		Role        models.Role `json:"role"`
-		CreatedAt   time.Time   `json:"created_at"`
-		UpdatedAt   time.Time   `json:"updated_at"`
-	}
-	err := h.Pool.QueryRow(r.Context(), "SELECT id, username, email, first_name, last_name, phone_number, address, role, created_at, updated_at FROM users WHERE id=$1", id).Scan(&u.ID, &u.Username, &u.Email, &u.FirstName, &u.LastName, &u.PhoneNumber, &u.Address, &u.Role, &u.CreatedAt, &u.UpdatedAt)
+	t, ok := h.authorizeUser(w, r, "users:read", id)
+	if !ok {
+		return
+	}
+	proj, err := parseProjection(r)
+	if err != nil {
+		httpx.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !proj.authorize(w, r, h.Authz, authz.UserResource(t)) {
+		return
+	}
+	cols, dest := proj.columns()
+	var u models.User
+	err = h.Pool.QueryRow(r.Context(), "SELECT "+cols+" FROM users u WHERE u.id=$1 AND u.deleted_at IS NULL", id).Scan(dest(&u)...)
 	if err == pgx.ErrNoRows {
 		httpx.Error(w, http.StatusNotFound, "not found")
 		return
@@ -112,16 +410,29 @@ func (h *UsersHandler) Get(w http.ResponseWriter, r *http.Request) {
 		httpx.Error(w, http.StatusInternalServerError, err.Error())
 		return
 	}
-	httpx.JSON(w, http.StatusOK, u)
+	etag := projectedETag(u.Version, proj)
+	w.Header().Set("ETag", etag)
+	// Expansions can change without the user's version changing
+	if len(proj.expand) == 0 && notModified(r, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	related, err := proj.expandUsers(r.Context(), h.Pool, []string{u.ID})
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	httpx.JSON(w, http.StatusOK, proj.render(&u, related[u.ID]))
 }
 
 func (h *UsersHandler) Update(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
-	// Allow admin or self
-	role, _ := r.Context().Value(middleware.CtxRole).(models.Role)
-	uid, _ := r.Context().Value(middleware.CtxUserID).(string)
-	if role != models.RoleAdmin && uid != id {
-		httpx.Error(w, http.StatusForbidden, "forbidden")
+	t, ok := h.authorizeUser(w, r, "users:update", id)
+	if !ok {
+		return
+	}
+	versions, ok := ifMatch(w, r, h.RequireIfMatch)
+	if !ok {
 		return
 	}
 	var req models.UpdateUserRequest
@@ -129,18 +440,29 @@ func (h *UsersHandler) Update(w http.ResponseWriter, r *http.Request) {
 		httpx.Error(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	// Only admin can change role; for non-admin keep existing role
-	var roleToSet *models.Role
-	if role == models.RoleAdmin {
-		roleToSet = req.Role
+	version, ok := h.save(w, r, t, req, versions)
+	if !ok {
+		return
 	}
-	// Build update; if roleToSet is nil, keep current role
-	var err error
-	if roleToSet != nil {
-		err = h.Pool.QueryRow(r.Context(), `UPDATE users SET username=$2, email=$3, first_name=$4, last_name=$5, phone_number=$6, address=$7, role=$8, updated_at=now() WHERE id=$1 RETURNING id`, id, req.Username, req.Email, req.FirstName, req.LastName, req.PhoneNumber, req.Address, *roleToSet).Scan(&id)
-	} else {
-		err = h.Pool.QueryRow(r.Context(), `UPDATE users SET username=$2, email=$3, first_name=$4, last_name=$5, phone_number=$6, address=$7, updated_at=now() WHERE id=$1 RETURNING id`, id, req.Username, req.Email, req.FirstName, req.LastName, req.PhoneNumber, req.Address).Scan(&id)
+	w.Header().Set("ETag", userETag(version))
+	httpx.JSON(w, http.StatusOK, map[string]string{"id": id})
+}
+
+// Patch updates only the fields present in the body, which is either a JSON
+// Merge Patch (RFC 7396) or, with Content-Type application/json-patch+json,
+// a JSON Patch (RFC 6902). The patched user is validated as a whole and
+// returned in full.
+func (h *UsersHandler) Patch(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	t, ok := h.authorizeUser(w, r, "users:update", id)
+	if !ok {
+		return
 	}
+	versions, ok := ifMatch(w, r, h.RequireIfMatch)
+	if !ok {
+		return
+	}
+	cur, err := h.loadUser(r.Context(), id)
 	if err == pgx.ErrNoRows {
 		httpx.Error(w, http.StatusNotFound, "not found")
 		return
@@ -149,16 +471,176 @@ func (h *UsersHandler) Update(w http.ResponseWriter, r *http.Request) {
 		httpx.Error(w, http.StatusInternalServerError, err.Error())
 		return
 	}
-	httpx.JSON(w, http.StatusOK, map[string]string{"id": id})
+	if !versionMatches(versions, cur.Version) {
+		httpx.ErrorCode(w, http.StatusPreconditionFailed, "precondition_failed", "user was modified; reload and retry")
+		return
+	}
+	// The patchable document: the editable fields as PUT takes them
+	var doc any = map[string]any{
+		"username": cur.Username, "email": cur.Email, "first_name": cur.FirstName, "last_name": cur.LastName,
+		"phone": jsonValue(cur.Phone), "address": jsonValue(cur.Address), "roles": rolesToAny(cur.Roles),
+	}
+	mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mt {
+	case "application/json-patch+json":
+		var ops []jsonpatch.Operation
+		if err := json.NewDecoder(r.Body).Decode(&ops); err != nil {
+			httpx.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		doc, err = jsonpatch.Apply(doc, ops)
+		if errors.Is(err, jsonpatch.ErrTestFailed) {
+			httpx.Error(w, http.StatusConflict, err.Error())
+			return
+		}
+		if err != nil {
+			httpx.Error(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+	case "application/merge-patch+json", "application/json", "":
+		var patch any
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+			httpx.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if _, ok := patch.(map[string]any); !ok {
+			httpx.Error(w, http.StatusBadRequest, "merge patch must be a JSON object")
+			return
+		}
+		doc = jsonpatch.Merge(doc, patch)
+	default:
+		w.Header().Set("Accept-Patch", "application/merge-patch+json, application/json-patch+json")
+		httpx.Error(w, http.StatusUnsupportedMediaType, "unsupported patch format")
+		return
+	}
+	patched, _ := json.Marshal(doc)
+	var req models.UpdateUserRequest
+	dec := json.NewDecoder(bytes.NewReader(patched))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		httpx.Error(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	// Only a changed role set goes through the role-assignment checks
+	if len(roleDiff(cur.Roles, req.Roles)) == 0 {
+		req.Roles = nil
+	} else if req.Roles == nil {
+		req.Roles = []models.Role{}
+	}
+	// The patch was computed from cur, so it may only apply to that version
+	if _, ok := h.save(w, r, t, req, []int64{cur.Version}); !ok {
+		return
+	}
+	u, err := h.loadUser(r.Context(), id)
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("ETag", userETag(u.Version))
+	httpx.JSON(w, http.StatusOK, u)
+}
+
+// save validates req and writes it to user t, replacing roles when req.Roles
+// is set, provided the row is at one of versions (nil for any). It returns the
+// new row version. On failure it writes the response and returns false.
+func (h *UsersHandler) save(w http.ResponseWriter, r *http.Request, t models.ManagedUser, req models.UpdateUserRequest, versions []int64) (int64, bool) {
+	id := t.ID
+	req.Username, req.Email = validate.NormalizeUsername(req.Username), validate.NormalizeEmail(req.Email)
+	err := validate.User(validate.UserFields{Username: req.Username, Email: req.Email, FirstName: req.FirstName,
+		LastName: req.LastName, Phone: req.Phone, Address: req.Address})
+	if err != nil {
+		httpx.Error(w, http.StatusBadRequest, err.Error())
+		return 0, false
+	}
+	// Roles are only replaced when supplied, and only by callers allowed to assign them
+	if req.Roles != nil {
+		if !authorize(w, r, h.Authz, "users:role:assign", authz.UserResource(t)) {
+			return 0, false
+		}
+		err := h.checkRoleGrants(r, t.Roles, req.Roles)
+		switch {
+		case errors.Is(err, store.ErrUnknownRole):
+			httpx.Error(w, http.StatusBadRequest, err.Error())
+			return 0, false
+		case errors.Is(err, errRoleNotGrantable):
+			httpx.Error(w, http.StatusForbidden, err.Error())
+			return 0, false
+		case err != nil:
+			httpx.Error(w, http.StatusInternalServerError, err.Error())
+			return 0, false
+		}
+		if !middleware.RecentlyAuthenticated(r.Context(), h.ReauthMaxAge) {
+			middleware.ReauthRequired(w, h.ReauthMaxAge)
+			return 0, false
+		}
+	}
+	tx, err := h.Pool.Begin(r.Context())
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return 0, false
+	}
+	defer tx.Rollback(r.Context())
+	if !checkUsername(w, r, tx, id, req.Username) {
+		return 0, false
+	}
+	// Only a new name is checked, so users whose name was reserved or blocked
+	// later can still save their profile
+	var renamed bool
+	err = tx.QueryRow(r.Context(), "SELECT username_key <> lower(normalize($2, NFKC)) FROM users WHERE id=$1", id, req.Username).Scan(&renamed)
+	if err != nil && err != pgx.ErrNoRows {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return 0, false
+	}
+	if renamed && !usernameAllowed(w, r, tx, h.Usernames, req.Username) {
+		return 0, false
+	}
+	// Checking the version in the WHERE clause makes the precondition atomic with
+	// the write. A phone stays verified only while its number is unchanged, and
+	// legacy free text goes once a structured value replaces it.
+	var version int64
+	number, region := phoneColumns(req.Phone)
+	err = tx.QueryRow(r.Context(), `UPDATE users SET username=$2, email=$3, first_name=$4, last_name=$5,
+			phone_number=$6, phone_region=$7, phone_verified_at = CASE WHEN phone_number = $6 THEN phone_verified_at END,
+			legacy_phone_number = CASE WHEN $6::text IS NULL THEN legacy_phone_number END,
+			address=$8, legacy_address = CASE WHEN $8::jsonb IS NULL THEN legacy_address END,
+			username_skeleton=$10, updated_at=now(),
+			email_verified_at = CASE WHEN email_key = lower(normalize($3, NFKC)) THEN email_verified_at END
+		WHERE id=$1 AND deleted_at IS NULL AND ($9::bigint[] IS NULL OR version = ANY($9)) RETURNING version`,
+		id, req.Username, req.Email, req.FirstName, req.LastName, number, region, req.Address, versions,
+		confusables.Skeleton(req.Username)).Scan(&version)
+	if err == pgx.ErrNoRows {
+		h.preconditionFailed(r.Context(), w, id)
+		return 0, false
+	}
+	if isUniqueViolation(err) {
+		httpx.Error(w, http.StatusConflict, parsePGError(err))
+		return 0, false
+	}
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return 0, false
+	}
+	if req.Roles != nil {
+		err = store.SetUserRoles(r.Context(), tx, id, req.Roles)
+		if errors.Is(err, store.ErrUnknownRole) {
+			httpx.Error(w, http.StatusBadRequest, err.Error())
+			return 0, false
+		}
+		if err != nil {
+			httpx.Error(w, http.StatusInternalServerError, err.Error())
+			return 0, false
+		}
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return 0, false
+	}
+	return version, true
 }
 
 func (h *UsersHandler) UpdatePassword(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
-	// Allow admin or self
-	role, _ := r.Context().Value(middleware.CtxRole).(models.Role)
-	uid, _ := r.Context().Value(middleware.CtxUserID).(string)
-	if role != models.RoleAdmin && uid != id {
-		httpx.Error(w, http.StatusForbidden, "forbidden")
+	if _, ok := h.authorizeUser(w, r, "users:password:update", id); !ok {
 		return
 	}
 	var req models.UpdatePasswordRequest
@@ -166,12 +648,16 @@ func (h *UsersHandler) UpdatePassword(w http.ResponseWriter, r *http.Request) {
 		httpx.Error(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	if err := validate.Password(req.Password); err != nil {
+		httpx.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
 	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 	if err != nil {
 		httpx.Error(w, http.StatusInternalServerError, "hash error")
 		return
 	}
-	err = h.Pool.QueryRow(r.Context(), `UPDATE users SET password_hash=$2, updated_at=now() WHERE id=$1 RETURNING id`, id, string(hash)).Scan(&id)
+	err = h.Pool.QueryRow(r.Context(), `UPDATE users SET password_hash=$2, updated_at=now() WHERE id=$1 AND deleted_at IS NULL RETURNING id`, id, string(hash)).Scan(&id)
 	if err == pgx.ErrNoRows {
 		httpx.Error(w, http.StatusNotFound, "not found")
 		return
@@ -184,14 +670,35 @@ func (h *UsersHandler) UpdatePassword(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *UsersHandler) Delete(w http.ResponseWriter, r *http.Request) {
-	// Only admin
-	role, _ := r.Context().Value(middleware.CtxRole).(models.Role)
-	if role != models.RoleAdmin {
-		httpx.Error(w, http.StatusForbidden, "forbidden")
+	id := chi.URLParam(r, "id")
+	if _, ok := h.authorizeUser(w, r, "users:delete", id); !ok {
 		return
 	}
+	versions, ok := ifMatch(w, r, h.RequireIfMatch)
+	if !ok {
+		return
+	}
+	// Soft delete: the purger removes the row after the retention period
+	uid, _ := r.Context().Value(middleware.CtxUserID).(string)
+	ct, err := h.Pool.Exec(r.Context(), "UPDATE users SET deleted_at=now(), deleted_by=$2, updated_at=now() WHERE id=$1 AND deleted_at IS NULL AND ($3::bigint[] IS NULL OR version = ANY($3))", id, uid, versions)
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if ct.RowsAffected() == 0 {
+		h.preconditionFailed(r.Context(), w, id)
+		return
+	}
+	httpx.JSON(w, http.StatusOK, map[string]any{"deleted": 1})
+}
+
+// Restore undoes a soft delete that has not been purged yet.
+func (h *UsersHandler) Restore(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
-	ct, err := h.Pool.Exec(r.Context(), "DELETE FROM users WHERE id=$1", id)
+	if _, ok := h.authorizeTarget(w, r, "users:restore", id, store.DeletedManagedUser); !ok {
+		return
+	}
+	ct, err := h.Pool.Exec(r.Context(), "UPDATE users SET deleted_at=NULL, deleted_by=NULL, updated_at=now() WHERE id=$1 AND deleted_at IS NOT NULL AND erased_at IS NULL", id)
 	if err != nil {
 		httpx.Error(w, http.StatusInternalServerError, err.Error())
 		return
@@ -200,5 +707,253 @@ func (h *UsersHandler) Delete(w http.ResponseWriter, r *http.Request) {
 		httpx.Error(w, http.StatusNotFound, "not found")
 		return
 	}
-	httpx.JSON(w, http.StatusOK, map[string]any{"deleted": 1})
+	httpx.JSON(w, http.StatusOK, map[string]any{"restored": 1})
+}
+
+// GetStatus returns the user's account status with its history.
+func (h *UsersHandler) GetStatus(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if _, ok := h.authorizeUser(w, r, "users:read", id); !ok {
+		return
+	}
+	var resp struct {
+		Status    models.UserStatus     `json:"status"`
+		Reason    string                `json:"reason"`
+		ChangedAt time.Time             `json:"changed_at"`
+		ChangedBy *string               `json:"changed_by,omitempty"`
+		History   []models.StatusChange `json:"history"`
+	}
+	err := h.Pool.QueryRow(r.Context(), "SELECT status, status_reason, status_changed_at, status_changed_by FROM users WHERE id=$1 AND deleted_at IS NULL", id).
+		Scan(&resp.Status, &resp.Reason, &resp.ChangedAt, &resp.ChangedBy)
+	if err == pgx.ErrNoRows {
+		httpx.Error(w, http.StatusNotFound, "not found")
+		return
+	}
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if resp.History, err = store.StatusHistory(r.Context(), h.Pool, id); err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	httpx.JSON(w, http.StatusOK, resp)
+}
+
+// setStatus returns a handler moving the user to status to. Pending accounts
+// are approved through /admin/registrations, not reactivated here.
+func (h *UsersHandler) setStatus(to models.UserStatus) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		if _, ok := h.authorizeUser(w, r, "users:status:update", id); !ok {
+			return
+		}
+		uid, _ := r.Context().Value(middleware.CtxUserID).(string)
+		if id == uid {
+			httpx.Error(w, http.StatusForbidden, "cannot change your own status")
+			return
+		}
+		var req models.StatusChangeRequest
+		if r.ContentLength != 0 {
+			if err := decodeJSON(r, &req); err != nil {
+				httpx.Error(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+		var current models.UserStatus
+		err := h.Pool.QueryRow(r.Context(), "SELECT status FROM users WHERE id=$1 AND deleted_at IS NULL", id).Scan(&current)
+		if err == pgx.ErrNoRows {
+			httpx.Error(w, http.StatusNotFound, "not found")
+			return
+		}
+		if err != nil {
+			httpx.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if current == models.StatusPending && to == models.StatusActive {
+			httpx.Error(w, http.StatusConflict, "pending accounts are approved through /admin/registrations")
+			return
+		}
+		from, err := store.TransitionStatus(r.Context(), h.Pool, id, to, strings.TrimSpace(req.Reason), uid)
+		switch {
+		case errors.Is(err, store.ErrInvalidTransition), errors.Is(err, store.ErrStatusConflict):
+			httpx.Error(w, http.StatusConflict, err.Error())
+			return
+		case err == pgx.ErrNoRows:
+			httpx.Error(w, http.StatusNotFound, "not found")
+			return
+		case err != nil:
+			httpx.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		httpx.JSON(w, http.StatusOK, map[string]any{"id": id, "from": from, "status": to})
+	}
+}
+
+// GetScopes returns the email domains a delegated admin is limited to.
+func (h *UsersHandler) GetScopes(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if _, ok := h.authorizeUser(w, r, "users:scopes:read", id); !ok {
+		return
+	}
+	domains, err := store.AdminScopes(r.Context(), h.Pool, id)
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	httpx.JSON(w, http.StatusOK, models.AdminScopesRequest{Domains: domains})
+}
+
+// SetScopes limits a delegated admin to users in the given email domains.
+// A scoped caller can only hand out domains from their own scope.
+func (h *UsersHandler) SetScopes(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if _, ok := h.authorizeUser(w, r, "users:scopes:update", id); !ok {
+		return
+	}
+	p := middleware.PrincipalFrom(r.Context())
+	var req models.AdminScopesRequest
+	if err := decodeJSON(r, &req); err != nil {
+		httpx.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	domains := make([]string, 0, len(req.Domains))
+	seen := map[string]bool{}
+	for _, d := range req.Domains {
+		d = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(d, "@")))
+		if d == "" || seen[d] {
+			continue
+		}
+		seen[d] = true
+		if !p.InScope(models.ManagedUser{EmailDomain: d}) {
+			httpx.Error(w, http.StatusForbidden, "domain outside your scope: "+d)
+			return
+		}
+		domains = append(domains, d)
+	}
+	// An unrestricted scope is wider than the caller's own
+	if len(domains) == 0 && len(p.Scopes) > 0 {
+		httpx.Error(w, http.StatusForbidden, "scoped admins cannot grant an unrestricted scope")
+		return
+	}
+	// An empty scope means unrestricted, so a half-applied change must never be committed
+	tx, err := h.Pool.Begin(r.Context())
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer tx.Rollback(r.Context())
+	if err := store.SetAdminScopes(r.Context(), tx, id, domains); err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	uid, _ := r.Context().Value(middleware.CtxUserID).(string)
+	entry := audit.Entry{ActorID: &uid, Action: "user.scopes_changed", TargetType: "user", TargetID: id, Data: map[string]any{"domains": domains}}
+	if _, err := h.Audit.Record(r.Context(), tx, entry); err != nil {
+		httpx.Error(w, http.StatusInternalServerError, "failed to write audit log")
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	httpx.JSON(w, http.StatusOK, models.AdminScopesRequest{Domains: domains})
+}
+
+// checkRoleGrants verifies the caller may grant every added role and revoke every removed one.
+func (h *UsersHandler) checkRoleGrants(r *http.Request, current, next []models.Role) error {
+	changed := roleDiff(current, next)
+	if len(changed) == 0 {
+		return nil
+	}
+	levels, err := store.RoleLevels(r.Context(), h.Pool, changed)
+	if err != nil {
+		return err
+	}
+	for _, role := range changed {
+		if h.Authz.Authorize(r.Context(), "roles:grant", authz.RoleResource(role, levels[role])) != nil {
+			return fmt.Errorf("%w: %s", errRoleNotGrantable, role)
+		}
+	}
+	return nil
+}
+
+// roleDiff returns the roles present in exactly one of a and b.
+func roleDiff(a, b []models.Role) []models.Role {
+	in := func(list []models.Role, r models.Role) bool {
+		for _, x := range list {
+			if x == r {
+				return true
+			}
+		}
+		return false
+	}
+	var out []models.Role
+	for _, r := range a {
+		if !in(b, r) && !in(out, r) {
+			out = append(out, r)
+		}
+	}
+	for _, r := range b {
+		if !in(a, r) && !in(out, r) {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// checkUsername rejects a username that looks like another user's, see
+// store.CheckUsername. On failure it writes the response and returns false.
+func checkUsername(w http.ResponseWriter, r *http.Request, tx pgx.Tx, id, username string) bool {
+	err := store.CheckUsername(r.Context(), tx, id, username, confusables.Skeleton(username))
+	if errors.Is(err, store.ErrUsernameConfusable) {
+		httpx.ErrorCode(w, http.StatusConflict, "username_confusable", err.Error())
+		return false
+	}
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return false
+	}
+	return true
+}
+
+// usernameAllowed rejects reserved and blocked usernames. On failure it
+// writes the response and returns false.
+func usernameAllowed(w http.ResponseWriter, r *http.Request, db store.DBTX, names *usernames.Policy, username string) bool {
+	err := names.Check(r.Context(), db, username)
+	switch {
+	case errors.Is(err, usernames.ErrReserved):
+		httpx.ErrorCode(w, http.StatusConflict, "username_reserved", err.Error())
+	case errors.Is(err, usernames.ErrBlocked):
+		httpx.ErrorCode(w, http.StatusConflict, "username_blocked", err.Error())
+	case err != nil:
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+	default:
+		return true
+	}
+	return false
+}
+
+// phoneColumns splits p into the phone_number and phone_region parameters.
+func phoneColumns(p *models.Phone) (number, region *string) {
+	if p == nil {
+		return nil, nil
+	}
+	return &p.Number, &p.Region
+}
+
+// jsonValue converts v to the generic JSON form patches are applied to.
+func jsonValue(v any) any {
+	b, _ := json.Marshal(v)
+	var out any
+	_ = json.Unmarshal(b, &out)
+	return out
+}
+
+func rolesToAny(roles []models.Role) []any {
+	out := make([]any, len(roles))
+	for i, r := range roles {
+		out[i] = string(r)
+	}
+	return out
 }
@@ -0,0 +1,287 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"dev.mfr/go-chi-sqlc-auth/internal/audit"
+	"dev.mfr/go-chi-sqlc-auth/internal/auth"
+	"dev.mfr/go-chi-sqlc-auth/internal/authz"
+	"dev.mfr/go-chi-sqlc-auth/internal/httpx"
+	"dev.mfr/go-chi-sqlc-auth/internal/mailer"
+	"dev.mfr/go-chi-sqlc-auth/internal/middleware"
+	"dev.mfr/go-chi-sqlc-auth/internal/models"
+	"dev.mfr/go-chi-sqlc-auth/internal/store"
+	"github.com/jackc/pgx/v5"
+)
+
+// batchMaxItems caps the user operations in one batch request.
+const batchMaxItems = 1000
+
+// batchError is why one item of a batch failed, with the status the
+// single-user endpoint would have answered.
+type batchError struct {
+	code int
+	msg  string
+}
+
+func (e *batchError) Error() string { return e.msg }
+
+// passwordReset is a set-password link to email once the batch has committed.
+type passwordReset struct {
+	email, firstName, username, token string
+	expires                           time.Time
+}
+
+// Batch applies role changes, suspensions, deletions and forced password
+// resets to many users in one transaction. Every item is authorized like the
+// matching single-user endpoint and runs in its own savepoint: an atomic batch
+// is rolled back at the first failure, a best_effort batch keeps the items
+// that succeeded. The response reports the outcome of each item.
+func (h *UsersHandler) Batch(w http.ResponseWriter, r *http.Request) {
+	var req models.BatchRequest
+	if err := decodeJSON(r, &req); err != nil {
+		httpx.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.Mode == "" {
+		req.Mode = models.BatchAtomic
+	}
+	if req.Mode != models.BatchAtomic && req.Mode != models.BatchBestEffort {
+		httpx.Error(w, http.StatusBadRequest, "mode must be atomic or best_effort")
+		return
+	}
+	if len(req.Operations) == 0 {
+		httpx.Error(w, http.StatusBadRequest, "operations required")
+		return
+	}
+	items, stepUp := 0, false
+	for i, op := range req.Operations {
+		switch op.Op {
+		case models.BatchSetRoles:
+			if len(op.Roles) == 0 {
+				httpx.Error(w, http.StatusBadRequest, fmt.Sprintf("operations[%d]: roles required", i))
+				return
+			}
+			stepUp = true
+		case models.BatchDelete, models.BatchForcePasswordReset:
+			stepUp = true
+		case models.BatchSuspend:
+		default:
+			httpx.Error(w, http.StatusBadRequest, fmt.Sprintf("operations[%d]: unknown op %q", i, op.Op))
+			return
+		}
+		if len(op.IDs) == 0 {
+			httpx.Error(w, http.StatusBadRequest, fmt.Sprintf("operations[%d]: ids required", i))
+			return
+		}
+		items += len(op.IDs)
+	}
+	if items > batchMaxItems {
+		httpx.Error(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("at most %d items per batch", batchMaxItems))
+		return
+	}
+	// The single-user endpoints for these operations want a recent login too
+	if stepUp && !middleware.RecentlyAuthenticated(r.Context(), h.ReauthMaxAge) {
+		middleware.ReauthRequired(w, h.ReauthMaxAge)
+		return
+	}
+
+	tx, err := h.Pool.Begin(r.Context())
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	resp := models.BatchResponse{Mode: req.Mode, Results: make([]models.BatchItemResult, 0, items)}
+	applied := map[string][]string{}
+	var resets []passwordReset
+	failed := false
+	for i, op := range req.Operations {
+		for _, id := range op.IDs {
+			res := models.BatchItemResult{Operation: i, Op: op.Op, ID: id}
+			if failed && req.Mode == models.BatchAtomic {
+				res.Status = models.BatchItemSkipped
+				resp.Results = append(resp.Results, res)
+				continue
+			}
+			reset, err := h.batchItem(r, tx, op, id)
+			if err != nil {
+				var be *batchError
+				if !errors.As(err, &be) {
+					be = &batchError{code: http.StatusInternalServerError, msg: err.Error()}
+				}
+				res.Status, res.Code, res.Error = models.BatchItemFailed, be.code, be.msg
+				resp.Failed++
+				failed = true
+			} else {
+				res.Status, res.Code = models.BatchItemOK, http.StatusOK
+				resp.Succeeded++
+				applied[op.Op] = append(applied[op.Op], id)
+				if reset != nil {
+					resets = append(resets, *reset)
+				}
+			}
+			resp.Results = append(resp.Results, res)
+		}
+	}
+
+	if failed && req.Mode == models.BatchAtomic {
+		for i := range resp.Results {
+			if resp.Results[i].Status == models.BatchItemOK {
+				resp.Results[i].Status = models.BatchItemRolledBack
+			}
+		}
+		resp.Succeeded = 0
+		httpx.JSON(w, http.StatusOK, resp)
+		return
+	}
+	if resp.Succeeded > 0 {
+		uid, _ := r.Context().Value(middleware.CtxUserID).(string)
+		entry := audit.Entry{ActorID: &uid, Action: "users.batch", TargetType: "user",
+			Data: map[string]any{"mode": req.Mode, "applied": applied, "failed": resp.Failed}}
+		if _, err := h.Audit.Record(r.Context(), tx, entry); err != nil {
+			httpx.Error(w, http.StatusInternalServerError, "failed to write audit log")
+			return
+		}
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	resp.Committed = true
+
+	// The links are only valid once committed, so mail goes out last. A failed
+	// email leaves the reset in place and is reported on the item.
+	sent := 0
+	for i := range resp.Results {
+		res := &resp.Results[i]
+		if res.Op != models.BatchForcePasswordReset || res.Status != models.BatchItemOK {
+			continue
+		}
+		pr := resets[sent]
+		sent++
+		msg := mailer.Message{
+			To:      pr.email,
+			Subject: "Your password has been reset",
+			Body: fmt.Sprintf("Hi %s,\n\nAn administrator has reset the password of your account %s.\n\n"+
+				"Choose a new password at:\n%s/set-password?token=%s\n\n"+
+				"The link works once and expires on %s.\n",
+				pr.firstName, pr.username, h.AppURL, pr.token, pr.expires.UTC().Format(time.RFC1123)),
+		}
+		if err := h.Mailer.Send(r.Context(), msg); err != nil {
+			res.Error = "password reset but email failed: " + err.Error()
+		}
+	}
+	httpx.JSON(w, http.StatusOK, resp)
+}
+
+// batchItem applies op to user id inside a savepoint of tx, checking the
+// same policy actions and rules as the single-user endpoint. Forced password
+// resets return the link to email after commit.
+func (h *UsersHandler) batchItem(r *http.Request, tx pgx.Tx, op models.BatchOperation, id string) (*passwordReset, error) {
+	ctx := r.Context()
+	sp, err := tx.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reset, err := h.applyBatchItem(r, sp, op, id)
+	if err != nil {
+		if rbErr := sp.Rollback(ctx); rbErr != nil {
+			return nil, rbErr
+		}
+		return nil, err
+	}
+	return reset, sp.Commit(ctx)
+}
+
+func (h *UsersHandler) applyBatchItem(r *http.Request, tx pgx.Tx, op models.BatchOperation, id string) (*passwordReset, error) {
+	ctx := r.Context()
+	action := map[string]string{
+		models.BatchSetRoles:           "users:role:assign",
+		models.BatchSuspend:            "users:status:update",
+		models.BatchDelete:             "users:delete",
+		models.BatchForcePasswordReset: "users:password:update",
+	}[op.Op]
+	t, err := store.ManagedUser(ctx, tx, id)
+	if err == pgx.ErrNoRows {
+		return nil, &batchError{http.StatusNotFound, "not found"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if h.Authz.Authorize(ctx, action, authz.UserResource(t)) != nil {
+		return nil, &batchError{http.StatusForbidden, "forbidden"}
+	}
+	uid, _ := ctx.Value(middleware.CtxUserID).(string)
+
+	switch op.Op {
+	case models.BatchSetRoles:
+		err := h.checkRoleGrants(r, t.Roles, op.Roles)
+		switch {
+		case errors.Is(err, store.ErrUnknownRole):
+			return nil, &batchError{http.StatusBadRequest, err.Error()}
+		case errors.Is(err, errRoleNotGrantable):
+			return nil, &batchError{http.StatusForbidden, err.Error()}
+		case err != nil:
+			return nil, err
+		}
+		err = store.SetUserRoles(ctx, tx, id, op.Roles)
+		if errors.Is(err, store.ErrUnknownRole) {
+			return nil, &batchError{http.StatusBadRequest, err.Error()}
+		}
+		if err != nil {
+			return nil, err
+		}
+		_, err = tx.Exec(ctx, "UPDATE users SET updated_at=now() WHERE id=$1", id)
+		return nil, err
+
+	case models.BatchSuspend:
+		if id == uid {
+			return nil, &batchError{http.StatusForbidden, "cannot change your own status"}
+		}
+		_, err := store.TransitionStatus(ctx, tx, id, models.StatusSuspended, strings.TrimSpace(op.Reason), uid)
+		switch {
+		case errors.Is(err, store.ErrInvalidTransition), errors.Is(err, store.ErrStatusConflict):
+			return nil, &batchError{http.StatusConflict, err.Error()}
+		case err == pgx.ErrNoRows:
+			return nil, &batchError{http.StatusNotFound, "not found"}
+		}
+		return nil, err
+
+	case models.BatchDelete:
+		ct, err := tx.Exec(ctx, "UPDATE users SET deleted_at=now(), deleted_by=$2, updated_at=now() WHERE id=$1 AND deleted_at IS NULL", id, uid)
+		if err != nil {
+			return nil, err
+		}
+		if ct.RowsAffected() == 0 {
+			return nil, &batchError{http.StatusNotFound, "not found"}
+		}
+		return nil, nil
+
+	case models.BatchForcePasswordReset:
+		token, hash, err := auth.NewOpaqueToken()
+		if err != nil {
+			return nil, err
+		}
+		// Like a created user, the account can't sign in until the emailed link is redeemed
+		pr := passwordReset{token: token, expires: time.Now().Add(h.PasswordSetupTTL)}
+		err = tx.QueryRow(ctx, "UPDATE users SET password_hash='', updated_at=now() WHERE id=$1 AND deleted_at IS NULL RETURNING email, first_name, username", id).
+			Scan(&pr.email, &pr.firstName, &pr.username)
+		if err == pgx.ErrNoRows {
+			return nil, &batchError{http.StatusNotFound, "not found"}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := store.CreatePasswordSetup(ctx, tx, id, pr.email, uid, hash, pr.expires); err != nil {
+			return nil, err
+		}
+		return &pr, nil
+	}
+	return nil, fmt.Errorf("unknown op %q", op.Op)
+}
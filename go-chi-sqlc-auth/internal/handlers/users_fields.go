@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"dev.mfr/go-chi-sqlc-auth/internal/authz"
+	"dev.mfr/go-chi-sqlc-auth/internal/models"
+	"dev.mfr/go-chi-sqlc-auth/internal/store"
+)
+
+// userField is a user attribute that can be picked with ?fields=: its SQL
+// expression (users aliased as u) and where it is scanned to in models.User.
+type userField struct {
+	Name string
+	SQL  string
+	Dest func(u *models.User) any
+	// OmitEmpty mirrors the omitempty JSON tag when all fields are returned.
+	OmitEmpty bool
+}
+
+// userFields lists the selectable fields in response order. password_hash is
+// deliberately not one of them.
+var userFields = []userField{
+	{Name: "id", SQL: "u.id", Dest: func(u *models.User) any { return &u.ID }},
+	{Name: "username", SQL: "u.username", Dest: func(u *models.User) any { return &u.Username }},
+	{Name: "email", SQL: "u.email", Dest: func(u *models.User) any { return &u.Email }},
+	{Name: "first_name", SQL: "u.first_name", Dest: func(u *models.User) any { return &u.FirstName }},
+	{Name: "last_name", SQL: "u.last_name", Dest: func(u *models.User) any { return &u.LastName }},
+	{Name: "phone", SQL: userPhoneSQL, Dest: func(u *models.User) any { return &u.Phone }},
+	{Name: "address", SQL: "u.address", Dest: func(u *models.User) any { return &u.Address }},
+	{Name: "legacy_phone_number", SQL: "u.legacy_phone_number", Dest: func(u *models.User) any { return &u.LegacyPhoneNumber }, OmitEmpty: true},
+	{Name: "legacy_address", SQL: "u.legacy_address", Dest: func(u *models.User) any { return &u.LegacyAddress }, OmitEmpty: true},
+	{Name: "avatar_url", SQL: "u.avatar_url", Dest: func(u *models.User) any { return &u.AvatarURL }},
+	{Name: "roles", SQL: userRolesSQL, Dest: func(u *models.User) any { return &u.Roles }},
+	{Name: "status", SQL: "u.status", Dest: func(u *models.User) any { return &u.Status }},
+	{Name: "status_reason", SQL: "u.status_reason", Dest: func(u *models.User) any { return &u.StatusReason }, OmitEmpty: true},
+	{Name: "status_changed_at", SQL: "u.status_changed_at", Dest: func(u *models.User) any { return &u.StatusChangedAt }},
+	{Name: "deleted_at", SQL: "u.deleted_at", Dest: func(u *models.User) any { return &u.DeletedAt }, OmitEmpty: true},
+	{Name: "created_at", SQL: "u.created_at", Dest: func(u *models.User) any { return &u.CreatedAt }},
+	{Name: "updated_at", SQL: "u.updated_at", Dest: func(u *models.User) any { return &u.UpdatedAt }},
+}
+
+// userExpansions are the related resources ?expand= can embed. Each needs
+// the users:expand:<name> permission from the policy, which is where the
+// per-role allowlist lives.
+var userExpansions = map[string]bool{"organizations": true}
+
+// unavailableExpansions are asked for but have nothing behind them yet; they
+// are refused with the reason rather than as unknown.
+var unavailableExpansions = map[string]string{
+	"sessions": "there are no server-side sessions; access tokens are stateless JWTs",
+}
+
+// userProjection is the shape a client asked for with ?fields= and ?expand=.
+// The zero value means the full models.User.
+type userProjection struct {
+	fields map[string]bool // nil means all
+	expand []string
+}
+
+// parseProjection reads ?fields= and ?expand=, rejecting unknown names.
+func parseProjection(r *http.Request) (userProjection, error) {
+	var p userProjection
+	if names := listParam(r.URL.Query()["fields"]); len(names) > 0 {
+		p.fields = map[string]bool{}
+		for _, n := range names {
+			if !knownUserField(n) {
+				return p, fmt.Errorf("unknown field %q", n)
+			}
+			p.fields[n] = true
+		}
+	}
+	for _, e := range listParam(r.URL.Query()["expand"]) {
+		if reason, ok := unavailableExpansions[e]; ok {
+			return p, fmt.Errorf("cannot expand %q: %s", e, reason)
+		}
+		if !userExpansions[e] {
+			return p, fmt.Errorf("cannot expand %q", e)
+		}
+		p.expand = append(p.expand, e)
+	}
+	return p, nil
+}
+
+func knownUserField(name string) bool {
+	for _, f := range userFields {
+		if f.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// key is the projection in a canonical form: fields in response order, then
+// the sorted expansions.
+func (p userProjection) key() string {
+	var names []string
+	for _, f := range userFields {
+		if p.fields == nil || p.fields[f.Name] {
+			names = append(names, f.Name)
+		}
+	}
+	expand := slices.Clone(p.expand)
+	slices.Sort(expand)
+	return strings.Join(names, ",") + ";" + strings.Join(slices.Compact(expand), ",")
+}
+
+// full reports whether the client gets the plain models.User.
+func (p userProjection) full() bool { return p.fields == nil && len(p.expand) == 0 }
+
+// authorize checks the caller may see every requested expansion of res.
+func (p userProjection) authorize(w http.ResponseWriter, r *http.Request, az *authz.Engine, res authz.Resource) bool {
+	for _, e := range p.expand {
+		if !authorize(w, r, az, "users:expand:"+e, res) {
+			return false
+		}
+	}
+	return true
+}
+
+// columns returns the select list for the projection plus the always-needed
+// fields (id, version and whatever extra columns the caller names), and a
+// function returning the matching scan targets.
+func (p userProjection) columns(extra ...string) (string, func(u *models.User) []any) {
+	var cols []string
+	var fields []userField
+	for _, f := range userFields {
+		if p.fields == nil || p.fields[f.Name] || f.Name == "id" || contains(extra, f.SQL) {
+			cols = append(cols, f.SQL)
+			fields = append(fields, f)
+		}
+	}
+	cols = append(cols, "u.version")
+	return strings.Join(cols, ", "), func(u *models.User) []any {
+		dest := make([]any, 0, len(fields)+1)
+		for _, f := range fields {
+			dest = append(dest, f.Dest(u))
+		}
+		return append(dest, &u.Version)
+	}
+}
+
+// render returns the response body for u: the user itself when nothing was
+// projected, otherwise an object with just the requested fields and expansions.
+func (p userProjection) render(u *models.User, related map[string]any) any {
+	if p.full() {
+		return u
+	}
+	out := map[string]any{}
+	for _, f := range userFields {
+		if p.fields != nil && !p.fields[f.Name] {
+			continue
+		}
+		v := f.Dest(u)
+		if p.fields == nil && f.OmitEmpty && isZero(v) {
+			continue
+		}
+		out[f.Name] = v
+	}
+	for k, v := range related {
+		out[k] = v
+	}
+	return out
+}
+
+func isZero(v any) bool {
+	switch x := v.(type) {
+	case *string:
+		return *x == ""
+	case **string:
+		return *x == nil
+	case **time.Time:
+		return *x == nil
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// expandUsers loads the requested expansions for the users, keyed by user id
+// and then by expansion name.
+func (p userProjection) expandUsers(ctx context.Context, db store.DBTX, ids []string) (map[string]map[string]any, error) {
+	out := make(map[string]map[string]any, len(ids))
+	for _, id := range ids {
+		out[id] = map[string]any{}
+	}
+	for _, e := range p.expand {
+		switch e {
+		case "organizations":
+			orgs, err := store.UserOrganizations(ctx, db, ids)
+			if err != nil {
+				return nil, err
+			}
+			for _, id := range ids {
+				list := orgs[id]
+				if list == nil {
+					list = []models.UserOrganization{}
+				}
+				out[id][e] = list
+			}
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"net/http"
+
+	"dev.mfr/go-chi-sqlc-auth/internal/authz"
+	"dev.mfr/go-chi-sqlc-auth/internal/httpx"
+	"dev.mfr/go-chi-sqlc-auth/internal/middleware"
+	"dev.mfr/go-chi-sqlc-auth/internal/models"
+	"dev.mfr/go-chi-sqlc-auth/internal/store"
+	"dev.mfr/go-chi-sqlc-auth/internal/validate"
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+// attributeAccess decides which custom attributes of user t the caller sees
+// and edits: public ones are seen by every reader, private ones by the user
+// and their admins, admin ones by admins only.
+type attributeAccess struct {
+	self, manage bool
+}
+
+func (h *UsersHandler) attributeAccess(r *http.Request, t models.ManagedUser) attributeAccess {
+	uid, _ := r.Context().Value(middleware.CtxUserID).(string)
+	return attributeAccess{
+		self:   t.ID == uid,
+		manage: h.Authz.Authorize(r.Context(), "users:attributes:manage", authz.UserResource(t)) == nil,
+	}
+}
+
+func (a attributeAccess) visible(def models.ProfileAttribute) bool {
+	switch def.Visibility {
+	case models.VisibilityPublic:
+		return true
+	case models.VisibilityPrivate:
+		return a.self || a.manage
+	}
+	return a.manage
+}
+
+// writable is asked only of callers allowed to update the user at all.
+func (a attributeAccess) writable(def models.ProfileAttribute) bool {
+	return def.Visibility != models.VisibilityAdmin || a.manage
+}
+
+// filter returns the values of attrs the caller may see.
+func (a attributeAccess) filter(defs []models.ProfileAttribute, attrs map[string]any) map[string]any {
+	out := map[string]any{}
+	for _, d := range defs {
+		if v, ok := attrs[d.Name]; ok && a.visible(d) {
+			out[d.Name] = v
+		}
+	}
+	return out
+}
+
+// GetAttributes returns the user's custom attribute values visible to the caller.
+func (h *UsersHandler) GetAttributes(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	t, ok := h.authorizeUser(w, r, "users:read", id)
+	if !ok {
+		return
+	}
+	defs, err := store.ProfileAttributes(r.Context(), h.Pool)
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	attrs, err := store.UserAttributes(r.Context(), h.Pool, id)
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	httpx.JSON(w, http.StatusOK, h.attributeAccess(r, t).filter(defs, attrs))
+}
+
+// PatchAttributes merges the body into the user's custom attributes like a
+// JSON Merge Patch: null removes a value. Every written value is checked
+// against its definition, and required attributes the caller can edit must
+// have a value afterwards.
+func (h *UsersHandler) PatchAttributes(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	t, ok := h.authorizeUser(w, r, "users:update", id)
+	if !ok {
+		return
+	}
+	var patch map[string]any
+	if err := decodeJSON(r, &patch); err != nil {
+		httpx.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	defs, err := store.ProfileAttributes(r.Context(), h.Pool)
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	byName := make(map[string]models.ProfileAttribute, len(defs))
+	for _, d := range defs {
+		byName[d.Name] = d
+	}
+	access := h.attributeAccess(r, t)
+
+	tx, err := h.Pool.Begin(r.Context())
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer tx.Rollback(r.Context())
+	attrs := map[string]any{}
+	err = tx.QueryRow(r.Context(), "SELECT attributes FROM users WHERE id=$1 AND deleted_at IS NULL FOR UPDATE", id).Scan(&attrs)
+	if err == pgx.ErrNoRows {
+		httpx.Error(w, http.StatusNotFound, "not found")
+		return
+	}
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	for name, v := range patch {
+		d, ok := byName[name]
+		if !ok {
+			httpx.Error(w, http.StatusBadRequest, "unknown attribute "+name)
+			return
+		}
+		if !access.writable(d) {
+			httpx.Error(w, http.StatusForbidden, "attribute "+name+" is managed by admins")
+			return
+		}
+		if v == nil {
+			delete(attrs, name)
+			continue
+		}
+		if err := validate.AttributeValue(d, v); err != nil {
+			httpx.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		attrs[name] = v
+	}
+	for _, d := range defs {
+		if _, ok := attrs[d.Name]; d.Required && !ok && access.writable(d) {
+			httpx.Error(w, http.StatusBadRequest, "attributes."+d.Name+": required")
+			return
+		}
+	}
+	var version int64
+	if err := tx.QueryRow(r.Context(), "UPDATE users SET attributes=$2, updated_at=now() WHERE id=$1 RETURNING version", id, attrs).Scan(&version); err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("ETag", userETag(version))
+	httpx.JSON(w, http.StatusOK, access.filter(defs, attrs))
+}
+
+// GetPreferences returns the user's locale, timezone and notification
+// settings, with defaults for anything never saved.
+func (h *UsersHandler) GetPreferences(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if _, ok := h.authorizeUser(w, r, "users:preferences:read", id); !ok {
+		return
+	}
+	p, err := store.UserPreferences(r.Context(), h.Pool, id)
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	httpx.JSON(w, http.StatusOK, p)
+}
+
+// PutPreferences saves the user's preferences; fields left out keep their value.
+func (h *UsersHandler) PutPreferences(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if _, ok := h.authorizeUser(w, r, "users:preferences:update", id); !ok {
+		return
+	}
+	p, err := store.UserPreferences(r.Context(), h.Pool, id)
+	if err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := decodeJSON(r, &p); err != nil {
+		httpx.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := validate.Preferences(p); err != nil {
+		httpx.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if p, err = store.SetUserPreferences(r.Context(), h.Pool, id, p); err != nil {
+		httpx.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	httpx.JSON(w, http.StatusOK, p)
+}
@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"dev.mfr/go-chi-sqlc-auth/internal/authz"
+	"dev.mfr/go-chi-sqlc-auth/internal/httpx"
+	"dev.mfr/go-chi-sqlc-auth/internal/middleware"
+	"dev.mfr/go-chi-sqlc-auth/internal/models"
+)
+
+// userQuery collects the WHERE conditions and arguments of a user list query.
+type userQuery struct {
+	where []string
+	args  []any
+}
+
+// arg adds a query argument and returns its placeholder.
+func (q *userQuery) arg(v any) string {
+	q.args = append(q.args, v)
+	return "$" + strconv.Itoa(len(q.args))
+}
+
+func (q *userQuery) and(cond string) { q.where = append(q.where, cond) }
+
+func (q *userQuery) whereSQL() string {
+	if len(q.where) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(q.where, " AND ")
+}
+
+// userSorts are the columns GET /users can be sorted by, with how to read
+// each one's cursor value from a user.
+var userSorts = map[string]struct {
+	column, cast string
+	value        func(models.User) string
+}{
+	"created_at": {"u.created_at", "timestamptz", func(u models.User) string { return u.CreatedAt.Format(time.RFC3339Nano) }},
+	"updated_at": {"u.updated_at", "timestamptz", func(u models.User) string { return u.UpdatedAt.Format(time.RFC3339Nano) }},
+	"username":   {"u.username", "text", func(u models.User) string { return u.Username }},
+	"email":      {"u.email", "text", func(u models.User) string { return u.Email }},
+	"last_name":  {"u.last_name", "text", func(u models.User) string { return u.LastName }},
+}
+
+// userSort parses ?sort=, a column name optionally prefixed with "-" for
+// descending order. The default is newest first.
+func userSort(r *http.Request) (sortKey, func(models.User) string, error) {
+	name := r.URL.Query().Get("sort")
+	if name == "" {
+		name = "-created_at"
+	}
+	s, ok := userSorts[strings.TrimPrefix(name, "-")]
+	if !ok {
+		return sortKey{}, nil, fmt.Errorf("cannot sort by %q", name)
+	}
+	return sortKey{Name: name, Column: s.column, Cast: s.cast, Desc: strings.HasPrefix(name, "-")}, s.value, nil
+}
+
+// userListQuery builds the conditions shared by the user list and export:
+// what the caller may see plus the filters in the query string. On failure
+// it writes the response and returns false.
+func (h *UsersHandler) userListQuery(w http.ResponseWriter, r *http.Request) (*userQuery, bool) {
+	q := &userQuery{}
+	v := r.URL.Query()
+	// Deleted users are only listed on request, to callers who could restore them
+	includeDeleted := v.Get("include_deleted") == "true"
+	if includeDeleted && !authorize(w, r, h.Authz, "users:list:deleted", authz.Resource{Type: "user"}) {
+		return nil, false
+	}
+	if !includeDeleted {
+		q.and("u.deleted_at IS NULL")
+	}
+	// Delegated admins only see users in their scope
+	p := middleware.PrincipalFrom(r.Context())
+	if len(p.Scopes) > 0 {
+		q.and("lower(split_part(u.email, '@', 2)) = ANY(" + q.arg(p.Scopes) + ")")
+	}
+	// Everyone but platform admins only sees members of their active organization
+	if h.Authz.Authorize(r.Context(), "users:list:all", authz.Resource{Type: "user"}) != nil {
+		if p.OrgID == "" {
+			q.and("false")
+		} else {
+			q.and("EXISTS (SELECT 1 FROM memberships m WHERE m.user_id = u.id AND m.org_id = " + q.arg(p.OrgID) + ")")
+		}
+	}
+
+	if roles := listParam(v["role"]); len(roles) > 0 {
+		q.and("EXISTS (SELECT 1 FROM user_roles ur JOIN roles r ON r.id = ur.role_id WHERE ur.user_id = u.id AND r.name = ANY(" + q.arg(roles) + "))")
+	}
+	if statuses := listParam(v["status"]); len(statuses) > 0 {
+		for _, s := range statuses {
+			if !models.UserStatus(s).Valid() {
+				httpx.Error(w, http.StatusBadRequest, "unknown status "+s)
+				return nil, false
+			}
+		}
+		q.and("u.status = ANY(" + q.arg(statuses) + ")")
+	}
+	if domains := listParam(v["email_domain"]); len(domains) > 0 {
+		for i, d := range domains {
+			domains[i] = strings.ToLower(strings.TrimPrefix(d, "@"))
+		}
+		q.and("lower(split_part(u.email, '@', 2)) = ANY(" + q.arg(domains) + ")")
+	}
+	ranges := []struct{ param, cond string }{
+		{"created_after", "u.created_at >= "},
+		{"created_before", "u.created_at < "},
+		{"updated_after", "u.updated_at >= "},
+		{"updated_before", "u.updated_at < "},
+	}
+	for _, rg := range ranges {
+		s := v.Get(rg.param)
+		if s == "" {
+			continue
+		}
+		t, err := parseDateParam(s)
+		if err != nil {
+			httpx.Error(w, http.StatusBadRequest, rg.param+" must be an RFC 3339 time or a YYYY-MM-DD date")
+			return nil, false
+		}
+		q.and(rg.cond + q.arg(t))
+	}
+	// Full-text match on whole words, or a fuzzy/substring match for typos and fragments
+	if s := strings.TrimSpace(v.Get("q")); s != "" {
+		term := q.arg(s)
+		q.and("(u.search_vector @@ websearch_to_tsquery('simple', " + term + ")" +
+			" OR lower(" + term + ") <% u.search_text" +
+			" OR u.search_text LIKE '%' || " + q.arg(likeEscape(strings.ToLower(s))) + " || '%')")
+	}
+	return q, true
+}
+
+// listParam accepts both repeated (?role=a&role=b) and comma-separated (?role=a,b) values.
+func listParam(values []string) []string {
+	var out []string
+	for _, v := range values {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				out = append(out, s)
+			}
+		}
+	}
+	return out
+}
+
+func parseDateParam(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, s)
+}
+
+// likeEscape escapes the LIKE wildcards in s (backslash is the default escape character).
+func likeEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
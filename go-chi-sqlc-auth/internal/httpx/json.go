@@ -14,3 +14,8 @@ func JSON(w http.ResponseWriter, status int, v interface{}) {
 func Error(w http.ResponseWriter, status int, msg string) {
 	JSON(w, status, map[string]string{"error": msg})
 }
+
+// ErrorCode writes an error with a machine-readable code clients can branch on.
+func ErrorCode(w http.ResponseWriter, status int, code, msg string) {
+	JSON(w, status, map[string]string{"error": msg, "code": code})
+}
@@ -0,0 +1,180 @@
+// Package importer loads users in bulk from CSV or NDJSON files.
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"dev.mfr/go-chi-sqlc-auth/internal/models"
+)
+
+// Columns are the accepted CSV header names and NDJSON keys. Phone numbers
+// without a country code are read in phone_region, or else address_country.
+var Columns = []string{"username", "email", "first_name", "last_name", "phone_number", "phone_region",
+	"address_line1", "address_line2", "address_city", "address_region", "address_postal_code", "address_country",
+	"role", "password_hash"}
+
+var ErrTooManyRows = errors.New("too many rows")
+
+// Row is one user from the input. Err is set when the row could not be
+// read; the other rows are still imported.
+type Row struct {
+	Line         int    `json:"-"`
+	Username     string `json:"username"`
+	Email        string `json:"email"`
+	FirstName    string `json:"first_name"`
+	LastName     string `json:"last_name"`
+	PhoneNumber  string `json:"phone_number"`
+	PhoneRegion  string `json:"phone_region"`
+	AddressLine1 string `json:"address_line1"`
+	AddressLine2 string `json:"address_line2"`
+	City         string `json:"address_city"`
+	Region       string `json:"address_region"`
+	PostalCode   string `json:"address_postal_code"`
+	Country      string `json:"address_country"`
+	Role         string `json:"role"`
+	PasswordHash string `json:"password_hash"`
+	Err          string `json:"-"`
+}
+
+// Contact assembles the row's phone number and address, nil when the row
+// has none.
+func (r Row) Contact() (*models.Phone, *models.Address) {
+	var p *models.Phone
+	var a *models.Address
+	if r.PhoneNumber != "" {
+		p = &models.Phone{Number: r.PhoneNumber, Region: r.PhoneRegion}
+	}
+	if r.AddressLine1 != "" || r.AddressLine2 != "" || r.City != "" || r.Region != "" || r.PostalCode != "" || r.Country != "" {
+		a = &models.Address{Lines: []string{r.AddressLine1, r.AddressLine2}, City: r.City, Region: r.Region,
+			PostalCode: r.PostalCode, Country: r.Country}
+	}
+	return p, a
+}
+
+// Parse reads every row of the input. It fails on input it can't make
+// sense of at all, such as a bad CSV header, or on more than maxRows rows.
+func Parse(r io.Reader, format models.ImportFormat, maxRows int) ([]Row, error) {
+	switch format {
+	case models.ImportCSV:
+		return parseCSV(r, maxRows)
+	case models.ImportNDJSON:
+		return parseNDJSON(r, maxRows)
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+func parseCSV(r io.Reader, maxRows int) ([]Row, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, errors.New("empty file")
+	}
+	if err != nil {
+		return nil, err
+	}
+	index := map[string]int{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if !known(name) {
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+		if _, dup := index[name]; dup {
+			return nil, fmt.Errorf("duplicate column %q", name)
+		}
+		index[name] = i
+	}
+	for _, name := range []string{"username", "email", "first_name", "last_name"} {
+		if _, ok := index[name]; !ok {
+			return nil, fmt.Errorf("missing column %q", name)
+		}
+	}
+	var rows []Row
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if len(rows) == maxRows {
+			return nil, fmt.Errorf("%w: at most %d", ErrTooManyRows, maxRows)
+		}
+		// A record with the wrong number of fields only fails itself
+		var pe *csv.ParseError
+		if errors.As(err, &pe) && errors.Is(pe.Err, csv.ErrFieldCount) {
+			rows = append(rows, Row{Line: pe.StartLine, Err: "wrong number of fields"})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := cr.FieldPos(0)
+		get := func(name string) string {
+			if i, ok := index[name]; ok {
+				return strings.TrimSpace(rec[i])
+			}
+			return ""
+		}
+		rows = append(rows, Row{
+			Line:         line,
+			Username:     get("username"),
+			Email:        get("email"),
+			FirstName:    get("first_name"),
+			LastName:     get("last_name"),
+			PhoneNumber:  get("phone_number"),
+			PhoneRegion:  get("phone_region"),
+			AddressLine1: get("address_line1"),
+			AddressLine2: get("address_line2"),
+			City:         get("address_city"),
+			Region:       get("address_region"),
+			PostalCode:   get("address_postal_code"),
+			Country:      get("address_country"),
+			Role:         get("role"),
+			PasswordHash: get("password_hash"),
+		})
+	}
+}
+
+func parseNDJSON(r io.Reader, maxRows int) ([]Row, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	var rows []Row
+	for line := 1; sc.Scan(); line++ {
+		b := bytes.TrimSpace(sc.Bytes())
+		if len(b) == 0 {
+			continue
+		}
+		if len(rows) == maxRows {
+			return nil, fmt.Errorf("%w: at most %d", ErrTooManyRows, maxRows)
+		}
+		row := Row{Line: line}
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&row); err != nil {
+			row = Row{Line: line, Err: "invalid JSON: " + err.Error()}
+		}
+		rows = append(rows, row)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, errors.New("empty file")
+	}
+	return rows, nil
+}
+
+func known(column string) bool {
+	for _, c := range Columns {
+		if c == column {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,420 @@
+package importer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"dev.mfr/go-chi-sqlc-auth/internal/audit"
+	"dev.mfr/go-chi-sqlc-auth/internal/auth"
+	"dev.mfr/go-chi-sqlc-auth/internal/authz"
+	"dev.mfr/go-chi-sqlc-auth/internal/confusables"
+	"dev.mfr/go-chi-sqlc-auth/internal/models"
+	"dev.mfr/go-chi-sqlc-auth/internal/store"
+	"dev.mfr/go-chi-sqlc-auth/internal/usernames"
+	"dev.mfr/go-chi-sqlc-auth/internal/validate"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	// runTimeout bounds the work for one import.
+	runTimeout = 30 * time.Minute
+	// progressEvery is how many rows are checked between progress updates.
+	progressEvery = 500
+)
+
+// Service runs imports and keeps their reports for polling.
+type Service struct {
+	Pool  *pgxpool.Pool
+	Authz *authz.Engine
+	Audit *audit.Logger
+	// Usernames is checked when an update renames a user, as on PUT /users/{id}
+	Usernames *usernames.Policy
+	// Retention is how long a finished import's report is kept.
+	Retention time.Duration
+}
+
+func New(pool *pgxpool.Pool, az *authz.Engine, al *audit.Logger, names *usernames.Policy, retention time.Duration) *Service {
+	return &Service{Pool: pool, Authz: az, Audit: al, Usernames: names, Retention: retention}
+}
+
+// Options control how rows are written.
+type Options struct {
+	DryRun bool
+	// Upsert updates users whose email already exists instead of skipping them.
+	Upsert bool
+	// OrgID is the organization new users join, normally the importer's active one.
+	OrgID string
+}
+
+const importColumns = "id, requested_by, format, dry_run, upsert, status, error, total, processed, created, updated, skipped, failed, report, created_at, completed_at, expires_at"
+
+func scanImport(row pgx.Row, im *models.UserImport) error {
+	var report []byte
+	err := row.Scan(&im.ID, &im.RequestedBy, &im.Format, &im.DryRun, &im.Upsert, &im.Status, &im.Error, &im.Total, &im.Processed,
+		&im.Created, &im.Updated, &im.Skipped, &im.Failed, &report, &im.CreatedAt, &im.CompletedAt, &im.ExpiresAt)
+	if err != nil || report == nil {
+		return err
+	}
+	return json.Unmarshal(report, &im.Rows)
+}
+
+// Create records a pending import of total rows.
+func (s *Service) Create(ctx context.Context, requestedBy string, format models.ImportFormat, opts Options, total int) (models.UserImport, error) {
+	var im models.UserImport
+	err := scanImport(s.Pool.QueryRow(ctx, `INSERT INTO user_imports (requested_by, format, dry_run, upsert, total, expires_at)
+		VALUES (NULLIF($1, '')::uuid, $2, $3, $4, $5, $6) RETURNING `+importColumns,
+		requestedBy, format, opts.DryRun, opts.Upsert, total, time.Now().Add(s.Retention)), &im)
+	return im, err
+}
+
+// Start runs the import in the background. ctx must carry the importer's
+// principal, which every row is authorized against; its cancellation is ignored.
+func (s *Service) Start(ctx context.Context, im models.UserImport, rows []Row, opts Options) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), runTimeout)
+		defer cancel()
+		if _, err := s.Run(ctx, im, rows, opts); err != nil {
+			log.Printf("import %s: %v", im.ID, err)
+		}
+	}()
+}
+
+// Run imports the rows and stores the report on the import. A failure of the
+// import as a whole is recorded too and returned.
+func (s *Service) Run(ctx context.Context, im models.UserImport, rows []Row, opts Options) (models.UserImport, error) {
+	if _, err := s.Pool.Exec(ctx, "UPDATE user_imports SET status=$2 WHERE id=$1", im.ID, models.ImportRunning); err != nil {
+		return im, err
+	}
+	results, err := s.run(ctx, im, rows, opts)
+	if err != nil {
+		if _, uerr := s.Pool.Exec(ctx, "UPDATE user_imports SET status=$2, error=$3, completed_at=now() WHERE id=$1",
+			im.ID, models.ImportFailed, err.Error()); uerr != nil {
+			log.Printf("import %s: %v", im.ID, uerr)
+		}
+		return im, err
+	}
+	counts := map[models.ImportRowStatus]int{}
+	for _, res := range results {
+		counts[res.Status]++
+	}
+	report, err := json.Marshal(results)
+	if err != nil {
+		return im, err
+	}
+	err = scanImport(s.Pool.QueryRow(ctx, `UPDATE user_imports SET status=$2, processed=total, created=$3, updated=$4,
+			skipped=$5, failed=$6, report=$7, completed_at=now()
+		WHERE id=$1 RETURNING `+importColumns, im.ID, models.ImportDone, counts[models.ImportRowCreated], counts[models.ImportRowUpdated],
+		counts[models.ImportRowSkipped], counts[models.ImportRowFailed], report), &im)
+	return im, err
+}
+
+func (s *Service) progress(ctx context.Context, id string, processed int) {
+	if _, err := s.Pool.Exec(ctx, "UPDATE user_imports SET processed=$2 WHERE id=$1", id, processed); err != nil {
+		log.Printf("import %s: %v", id, err)
+	}
+}
+
+func (s *Service) run(ctx context.Context, im models.UserImport, rows []Row, opts Options) ([]models.ImportRowResult, error) {
+	results := make([]models.ImportRowResult, len(rows))
+	byLine := make(map[int]*models.ImportRowResult, len(rows))
+	fail := func(i int, msg string) {
+		results[i].Status, results[i].Error = models.ImportRowFailed, msg
+	}
+	knownRoles, err := s.roles(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check every row on its own first; only rows that pass reach the database
+	seenEmail, seenUsername, seenSkeleton := map[string]int{}, map[string]int{}, map[string]int{}
+	phones, addrs := make([]*models.Phone, len(rows)), make([]*models.Address, len(rows))
+	skeletons := make([]string, len(rows))
+	for i := range rows {
+		rows[i].Username, rows[i].Email = validate.NormalizeUsername(rows[i].Username), validate.NormalizeEmail(rows[i].Email)
+		row := rows[i]
+		results[i] = models.ImportRowResult{Line: row.Line, Email: row.Email}
+		byLine[row.Line] = &results[i]
+		if i > 0 && i%progressEvery == 0 {
+			s.progress(ctx, im.ID, i)
+		}
+		if row.Err != "" {
+			fail(i, row.Err)
+			continue
+		}
+		phones[i], addrs[i] = row.Contact()
+		err := validate.User(validate.UserFields{Username: row.Username, Email: row.Email, FirstName: row.FirstName,
+			LastName: row.LastName, Phone: phones[i], Address: addrs[i]})
+		if err != nil {
+			fail(i, err.Error())
+			continue
+		}
+		if row.Role != "" && !knownRoles[row.Role] {
+			fail(i, "unknown role "+row.Role)
+			continue
+		}
+		if row.PasswordHash != "" && !auth.ValidPasswordHash(row.PasswordHash) {
+			fail(i, "password_hash must be a bcrypt or argon2id hash")
+			continue
+		}
+		if prev, ok := seenEmail[strings.ToLower(row.Email)]; ok {
+			fail(i, fmt.Sprintf("duplicate of line %d", rows[prev].Line))
+			continue
+		}
+		if prev, ok := seenUsername[strings.ToLower(row.Username)]; ok {
+			fail(i, fmt.Sprintf("username duplicates line %d", rows[prev].Line))
+			continue
+		}
+		skeletons[i] = confusables.Skeleton(row.Username)
+		if prev, ok := seenSkeleton[skeletons[i]]; ok {
+			fail(i, fmt.Sprintf("username looks too much like line %d", rows[prev].Line))
+			continue
+		}
+		seenEmail[strings.ToLower(row.Email)], seenUsername[strings.ToLower(row.Username)], seenSkeleton[skeletons[i]] = i, i, i
+		domain := strings.ToLower(row.Email[strings.LastIndex(row.Email, "@")+1:])
+		if s.Authz.Authorize(ctx, "users:create", authz.UserResource(models.ManagedUser{EmailDomain: domain})) != nil {
+			fail(i, "not allowed to create users in "+domain)
+		}
+	}
+
+	tx, err := s.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+	if _, err := tx.Exec(ctx, `CREATE TEMP TABLE import_users (
+			line INT PRIMARY KEY, username TEXT, username_skeleton TEXT, email TEXT, first_name TEXT, last_name TEXT,
+			phone_number TEXT, phone_region TEXT, address JSONB, role TEXT, password_hash TEXT
+		) ON COMMIT DROP`); err != nil {
+		return nil, err
+	}
+	var copyRows [][]any
+	for i, row := range rows {
+		if results[i].Status == "" {
+			var number, region *string
+			if p := phones[i]; p != nil {
+				number, region = &p.Number, &p.Region
+			}
+			copyRows = append(copyRows, []any{row.Line, row.Username, skeletons[i], row.Email, row.FirstName, row.LastName,
+				number, region, addrs[i], row.Role, row.PasswordHash})
+		}
+	}
+	_, err = tx.CopyFrom(ctx, pgx.Identifier{"import_users"},
+		[]string{"line", "username", "username_skeleton", "email", "first_name", "last_name", "phone_number", "phone_region", "address", "role", "password_hash"},
+		pgx.CopyFromRows(copyRows))
+	if err != nil {
+		return nil, err
+	}
+
+	// Lookalike usernames are checked as in store.CheckUsername, under the same
+	// locks, taken in one order so concurrent imports can't deadlock
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext('username:' || s))
+		FROM (SELECT DISTINCT username_skeleton AS s FROM import_users ORDER BY 1) k`); err != nil {
+		return nil, err
+	}
+	// Rows that collide with existing users are skipped, fail, or become updates
+	conflicts, err := tx.Query(ctx, `SELECT t.line, t.role, t.username, t.password_hash <> '', e.id, e.deleted_at IS NOT NULL,
+			e.username_key IS DISTINCT FROM lower(normalize(t.username, NFKC)), n.id, c.id IS NOT NULL
+		FROM import_users t
+		LEFT JOIN users e ON e.email_key = lower(normalize(t.email, NFKC))
+		LEFT JOIN users n ON n.username_key = lower(normalize(t.username, NFKC))
+		LEFT JOIN LATERAL (SELECT s.id FROM users s WHERE s.username_skeleton = t.username_skeleton
+			AND s.username_key <> lower(normalize(t.username, NFKC)) AND s.id IS DISTINCT FROM e.id
+			AND e.username_skeleton IS DISTINCT FROM t.username_skeleton LIMIT 1) c ON true
+		WHERE e.id IS NOT NULL OR n.id IS NOT NULL OR c.id IS NOT NULL`)
+	if err != nil {
+		return nil, err
+	}
+	type conflict struct {
+		line                         int
+		role, username               string
+		hash                         bool
+		byEmail, byName              *string
+		deleted, renamed, confusable bool
+	}
+	var found []conflict
+	for conflicts.Next() {
+		var c conflict
+		var deleted, renamed *bool
+		if err := conflicts.Scan(&c.line, &c.role, &c.username, &c.hash, &c.byEmail, &deleted, &renamed, &c.byName, &c.confusable); err != nil {
+			conflicts.Close()
+			return nil, err
+		}
+		c.deleted = deleted != nil && *deleted
+		c.renamed = renamed != nil && *renamed
+		found = append(found, c)
+	}
+	conflicts.Close()
+	if err := conflicts.Err(); err != nil {
+		return nil, err
+	}
+	var drop []int
+	for _, c := range found {
+		res := byLine[c.line]
+		switch {
+		case c.byName != nil && (c.byEmail == nil || *c.byName != *c.byEmail):
+			res.Status, res.Error = models.ImportRowFailed, "username already taken"
+		case c.confusable:
+			res.Status, res.Error = models.ImportRowFailed, "username looks too much like an existing one"
+		case c.deleted:
+			res.Status, res.Error = models.ImportRowFailed, "email belongs to a deleted user"
+		case !opts.Upsert:
+			res.Status, res.Error = models.ImportRowSkipped, "email already exists"
+		default:
+			// Updating someone is checked like PUT /users/{id}
+			t, err := store.ManagedUser(ctx, tx, *c.byEmail)
+			if err != nil {
+				return nil, err
+			}
+			if s.Authz.Authorize(ctx, "users:update", authz.UserResource(t)) != nil ||
+				(c.role != "" && s.Authz.Authorize(ctx, "users:role:assign", authz.UserResource(t)) != nil) {
+				res.Status, res.Error = models.ImportRowFailed, "not allowed to update this user"
+				break
+			}
+			// A password hash replaces the user's password, as PUT /users/{id}/password would
+			if c.hash && s.Authz.Authorize(ctx, "users:password:update", authz.UserResource(t)) != nil {
+				res.Status, res.Error = models.ImportRowFailed, "not allowed to set this user's password"
+				break
+			}
+			if !c.renamed {
+				break
+			}
+			err = s.Usernames.Check(ctx, tx, c.username)
+			switch {
+			case errors.Is(err, usernames.ErrReserved):
+				res.Status, res.Error, res.Code = models.ImportRowFailed, err.Error(), "username_reserved"
+			case errors.Is(err, usernames.ErrBlocked):
+				res.Status, res.Error, res.Code = models.ImportRowFailed, err.Error(), "username_blocked"
+			case err != nil:
+				return nil, err
+			}
+		}
+		if res.Status != "" {
+			drop = append(drop, c.line)
+		}
+	}
+	if len(drop) > 0 {
+		if _, err := tx.Exec(ctx, "DELETE FROM import_users WHERE line = ANY($1)", drop); err != nil {
+			return nil, err
+		}
+	}
+
+	// Rows without a password get an empty hash, which never matches
+	// An update keeps the phone's verification only while the number stays
+	written, err := tx.Query(ctx, `WITH w AS (
+		INSERT INTO users (username, username_skeleton, email, password_hash, first_name, last_name, phone_number, phone_region, address)
+		SELECT username, username_skeleton, email, password_hash, first_name, last_name, phone_number, phone_region, address
+		FROM import_users ORDER BY line
+		ON CONFLICT (email_key) DO UPDATE SET
+			username = EXCLUDED.username, username_skeleton = EXCLUDED.username_skeleton, first_name = EXCLUDED.first_name, last_name = EXCLUDED.last_name,
+			phone_number = EXCLUDED.phone_number, phone_region = EXCLUDED.phone_region,
+			phone_verified_at = CASE WHEN users.phone_number = EXCLUDED.phone_number THEN users.phone_verified_at END,
+			legacy_phone_number = CASE WHEN EXCLUDED.phone_number IS NULL THEN users.legacy_phone_number END,
+			address = EXCLUDED.address, legacy_address = CASE WHEN EXCLUDED.address IS NULL THEN users.legacy_address END,
+			password_hash = CASE WHEN EXCLUDED.password_hash <> '' THEN EXCLUDED.password_hash ELSE users.password_hash END,
+			updated_at = now()
+		RETURNING id, email_key, xmax = 0 AS inserted)
+		SELECT w.id, t.line, w.inserted FROM w JOIN import_users t ON lower(normalize(t.email, NFKC)) = w.email_key`)
+	if err != nil {
+		return nil, err
+	}
+	var created []string
+	for written.Next() {
+		var id string
+		var line int
+		var inserted bool
+		if err := written.Scan(&id, &line, &inserted); err != nil {
+			written.Close()
+			return nil, err
+		}
+		res := byLine[line]
+		res.Status, res.UserID = models.ImportRowUpdated, id
+		if inserted {
+			res.Status = models.ImportRowCreated
+			created = append(created, id)
+		}
+	}
+	written.Close()
+	if err := written.Err(); err != nil {
+		return nil, err
+	}
+
+	// New users get the row's role or the default; updates only change roles when the row names one
+	if _, err := tx.Exec(ctx, `DELETE FROM user_roles ur USING import_users t, users u
+		WHERE t.role <> '' AND u.email_key = lower(normalize(t.email, NFKC)) AND ur.user_id = u.id`); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(ctx, `INSERT INTO user_roles (user_id, role_id)
+		SELECT u.id, r.id FROM import_users t
+		JOIN users u ON u.email_key = lower(normalize(t.email, NFKC))
+		JOIN roles r ON r.name = COALESCE(NULLIF(t.role, ''), $1)
+		WHERE t.role <> '' OR u.id = ANY($2)
+		ON CONFLICT DO NOTHING`, string(models.RoleUser), created); err != nil {
+		return nil, err
+	}
+	if opts.OrgID != "" {
+		if _, err := tx.Exec(ctx, "INSERT INTO memberships (user_id, org_id) SELECT unnest($1::uuid[]), $2::uuid ON CONFLICT DO NOTHING",
+			created, opts.OrgID); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.DryRun {
+		// Nothing is written, so the report must not point at user ids that don't exist
+		for i := range results {
+			results[i].UserID = ""
+		}
+		return results, nil
+	}
+	counts := map[models.ImportRowStatus]int{}
+	for _, res := range results {
+		counts[res.Status]++
+	}
+	entry := audit.Entry{ActorID: im.RequestedBy, Action: "users.imported", TargetType: "user_import", TargetID: im.ID,
+		Data: map[string]any{"format": string(im.Format), "upsert": opts.Upsert, "total": len(rows),
+			"created": counts[models.ImportRowCreated], "updated": counts[models.ImportRowUpdated],
+			"skipped": counts[models.ImportRowSkipped], "failed": counts[models.ImportRowFailed]}}
+	if _, err := s.Audit.Record(ctx, tx, entry); err != nil {
+		return nil, err
+	}
+	return results, tx.Commit(ctx)
+}
+
+func (s *Service) roles(ctx context.Context) (map[string]bool, error) {
+	rows, err := s.Pool.Query(ctx, "SELECT name FROM roles")
+	if err != nil {
+		return nil, err
+	}
+	names, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]bool, len(names))
+	for _, n := range names {
+		out[n] = true
+	}
+	return out, nil
+}
+
+// Get loads an import started by requestedBy, with its report once done.
+// It returns pgx.ErrNoRows when there is no such import or it has expired.
+func (s *Service) Get(ctx context.Context, requestedBy, id string) (models.UserImport, error) {
+	var im models.UserImport
+	err := scanImport(s.Pool.QueryRow(ctx, "SELECT "+importColumns+" FROM user_imports WHERE id=$1 AND requested_by=$2 AND expires_at > now()",
+		id, requestedBy), &im)
+	return im, err
+}
+
+// PurgeExpired deletes imports past their retention and returns how many were removed.
+func PurgeExpired(ctx context.Context, db store.DBTX, now time.Time) (int64, error) {
+	ct, err := db.Exec(ctx, "DELETE FROM user_imports WHERE expires_at <= $1", now)
+	if err != nil {
+		return 0, err
+	}
+	return ct.RowsAffected(), nil
+}
@@ -0,0 +1,90 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"dev.mfr/go-chi-sqlc-auth/internal/audit"
+	"dev.mfr/go-chi-sqlc-auth/internal/storage"
+	"dev.mfr/go-chi-sqlc-auth/internal/store"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Eraser erases accounts whose self-service deletion grace period has ended
+// and writes a signed erasure receipt to the audit log for each.
+type Eraser struct {
+	Pool     *pgxpool.Pool
+	Audit    *audit.Logger
+	Blobs    storage.BlobStore // holds the avatars
+	Interval time.Duration
+}
+
+func NewEraser(pool *pgxpool.Pool, al *audit.Logger, blobs storage.BlobStore, interval time.Duration) *Eraser {
+	return &Eraser{Pool: pool, Audit: al, Blobs: blobs, Interval: interval}
+}
+
+// Run erases due accounts once immediately and then every Interval until ctx is done.
+// A zero Interval disables erasure.
+func (e *Eraser) Run(ctx context.Context) {
+	if e.Interval <= 0 {
+		return
+	}
+	t := time.NewTicker(e.Interval)
+	defer t.Stop()
+	for {
+		e.eraseDue(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func (e *Eraser) eraseDue(ctx context.Context) {
+	ids, err := store.DueErasures(ctx, e.Pool, time.Now())
+	if err != nil {
+		log.Printf("erase: %v", err)
+		return
+	}
+	for _, id := range ids {
+		if err := e.erase(ctx, id); err != nil && err != pgx.ErrNoRows {
+			log.Printf("erase %s: %v", id, err)
+		}
+	}
+}
+
+// erase runs the erasure and its receipt in one transaction. pgx.ErrNoRows
+// means the user logged in and cancelled the deletion in the meantime.
+func (e *Eraser) erase(ctx context.Context, userID string) error {
+	tx, err := e.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	er, err := store.EraseUser(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+	_, err = e.Audit.Record(ctx, tx, audit.Entry{
+		Action:     "user.erased",
+		TargetType: "user",
+		TargetID:   userID,
+		Data: map[string]any{
+			"scheduled_at": er.ScheduledAt.UTC().Format(time.RFC3339),
+			"erased_at":    er.ErasedAt.UTC().Format(time.RFC3339),
+			"fields":       []string{"username", "email", "password_hash", "first_name", "last_name", "phone_number", "phone_region", "address", "legacy_phone_number", "legacy_address", "avatar_url", "attributes"},
+			"removed":      er.Removed,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	storage.DeleteAll(ctx, e.Blobs, er.AvatarKeys)
+	return nil
+}
@@ -0,0 +1,61 @@
+package jobs
+
+import (
+	"context"
+	"log"
+
+	"dev.mfr/go-chi-sqlc-auth/internal/phone"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// MigrateLegacyPhones converts phone numbers saved as free text before
+// numbers were normalized. International numbers convert as they are,
+// national ones are read in the user's address country or else region.
+// Numbers that still don't parse stay in legacy_phone_number for the user to
+// re-enter. It runs once; converted rows drop out of the next run.
+func MigrateLegacyPhones(ctx context.Context, pool *pgxpool.Pool, region string) {
+	rows, err := pool.Query(ctx, `SELECT id, legacy_phone_number, COALESCE(address->>'country', '') FROM users
+		WHERE legacy_phone_number IS NOT NULL AND phone_number IS NULL AND erased_at IS NULL`)
+	if err != nil {
+		log.Printf("legacy phones: %v", err)
+		return
+	}
+	type legacy struct{ id, number, country string }
+	var found []legacy
+	for rows.Next() {
+		var l legacy
+		if err := rows.Scan(&l.id, &l.number, &l.country); err != nil {
+			rows.Close()
+			log.Printf("legacy phones: %v", err)
+			return
+		}
+		found = append(found, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		log.Printf("legacy phones: %v", err)
+		return
+	}
+	converted := 0
+	for _, l := range found {
+		hint := l.country
+		if hint == "" {
+			hint = region
+		}
+		number, numberRegion, err := phone.Normalize(l.number, hint)
+		if err != nil {
+			continue
+		}
+		// The user may have saved a new number since the SELECT
+		ct, err := pool.Exec(ctx, `UPDATE users SET phone_number=$2, phone_region=$3, legacy_phone_number=NULL
+			WHERE id=$1 AND phone_number IS NULL AND legacy_phone_number=$4`, l.id, number, numberRegion, l.number)
+		if err != nil {
+			log.Printf("legacy phones: %v", err)
+			return
+		}
+		converted += int(ct.RowsAffected())
+	}
+	if len(found) > 0 {
+		log.Printf("legacy phones: converted %d of %d free-text phone numbers", converted, len(found))
+	}
+}
@@ -0,0 +1,67 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"dev.mfr/go-chi-sqlc-auth/internal/export"
+	"dev.mfr/go-chi-sqlc-auth/internal/importer"
+	"dev.mfr/go-chi-sqlc-auth/internal/storage"
+	"dev.mfr/go-chi-sqlc-auth/internal/store"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Purger periodically hard-deletes users whose soft delete is older than
+// Retention along with their avatars, and personal data exports and import
+// reports past their own retention.
+type Purger struct {
+	Pool      *pgxpool.Pool
+	Blobs     storage.BlobStore
+	Retention time.Duration
+	Interval  time.Duration
+}
+
+func NewPurger(pool *pgxpool.Pool, blobs storage.BlobStore, retention, interval time.Duration) *Purger {
+	return &Purger{Pool: pool, Blobs: blobs, Retention: retention, Interval: interval}
+}
+
+// Run purges once immediately and then every Interval until ctx is done.
+// A zero Interval disables purging.
+func (p *Purger) Run(ctx context.Context) {
+	if p.Interval <= 0 {
+		return
+	}
+	t := time.NewTicker(p.Interval)
+	defer t.Stop()
+	for {
+		p.purge(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func (p *Purger) purge(ctx context.Context) {
+	n, avatars, err := store.PurgeDeletedUsers(ctx, p.Pool, time.Now().Add(-p.Retention))
+	if err != nil {
+		log.Printf("purge: %v", err)
+	} else if n > 0 {
+		log.Printf("purge: removed %d deleted users", n)
+	}
+	storage.DeleteAll(ctx, p.Blobs, avatars)
+	n, err = export.PurgeExpired(ctx, p.Pool, time.Now())
+	if err != nil {
+		log.Printf("purge: %v", err)
+	} else if n > 0 {
+		log.Printf("purge: removed %d expired data exports", n)
+	}
+	n, err = importer.PurgeExpired(ctx, p.Pool, time.Now())
+	if err != nil {
+		log.Printf("purge: %v", err)
+	} else if n > 0 {
+		log.Printf("purge: removed %d expired user imports", n)
+	}
+}
@@ -2,21 +2,38 @@ package middleware
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"dev.mfr/go-chi-sqlc-auth/internal/auth"
+	"dev.mfr/go-chi-sqlc-auth/internal/httpx"
 	"dev.mfr/go-chi-sqlc-auth/internal/models"
+	"github.com/jackc/pgx/v5"
 )
 
 type ctxKey string
 
 const (
-	CtxUserID ctxKey = "uid"
-	CtxRole   ctxKey = "role"
+	CtxUserID    ctxKey = "uid"
+	CtxPrincipal ctxKey = "principal"
+	CtxAuthTime  ctxKey = "auth_time"
 )
 
-func JWT(issuer auth.JWTIssuer) func(http.Handler) http.Handler {
+// PrincipalSource resolves the roles and permissions of an authenticated user
+// and their role in the token's active organization.
+type PrincipalSource interface {
+	Principal(ctx context.Context, userID, orgID string) (*models.Principal, error)
+}
+
+// ErrCodeReauthRequired is returned when an operation needs a fresher login.
+const ErrCodeReauthRequired = "reauthentication_required"
+
+// JWT authenticates the bearer token and loads the caller's current permissions,
+// so role changes apply without waiting for the token to expire.
+func JWT(issuer auth.JWTIssuer, principals PrincipalSource) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			ah := r.Header.Get("Authorization")
@@ -30,22 +47,63 @@ func JWT(issuer auth.JWTIssuer) func(http.Handler) http.Handler {
 				http.Error(w, "invalid token", http.StatusUnauthorized)
 				return
 			}
+			p, err := principals.Principal(r.Context(), claims.UserID, claims.OrgID)
+			if errors.Is(err, pgx.ErrNoRows) {
+				http.Error(w, "invalid token", http.StatusUnauthorized)
+				return
+			}
+			if err != nil {
+				http.Error(w, "failed to load user", http.StatusInternalServerError)
+				return
+			}
+			// Suspending or locking an account takes effect on the next request
+			if p.Status != models.StatusActive {
+				AccountInactive(w, p.Status)
+				return
+			}
 			ctx := context.WithValue(r.Context(), CtxUserID, claims.UserID)
-			ctx = context.WithValue(ctx, CtxRole, claims.Role)
+			ctx = context.WithValue(ctx, CtxPrincipal, p)
+			if claims.AuthTime != nil {
+				ctx = context.WithValue(ctx, CtxAuthTime, claims.AuthTime.Time)
+			}
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
 
-func RequireRoles(roles ...models.Role) func(http.Handler) http.Handler {
-	allowed := map[models.Role]struct{}{}
-	for _, r := range roles {
-		allowed[r] = struct{}{}
+var inactiveMessages = map[models.UserStatus]string{
+	models.StatusPending:     "account is waiting for approval",
+	models.StatusSuspended:   "account is suspended",
+	models.StatusLocked:      "account is locked",
+	models.StatusDeactivated: "account is deactivated",
+}
+
+// AccountInactive rejects a caller whose account is not active, with the
+// code account_<status> so clients can tell the cases apart.
+func AccountInactive(w http.ResponseWriter, status models.UserStatus) {
+	msg, ok := inactiveMessages[status]
+	if !ok {
+		msg = "account is not active"
 	}
+	httpx.ErrorCode(w, http.StatusForbidden, "account_"+string(status), msg)
+}
+
+// PrincipalFrom returns the caller loaded by JWT, or nil on unauthenticated routes.
+func PrincipalFrom(ctx context.Context) *models.Principal {
+	p, _ := ctx.Value(CtxPrincipal).(*models.Principal)
+	return p
+}
+
+// Can reports whether the caller holds perm.
+func Can(ctx context.Context, perm models.Permission) bool {
+	return PrincipalFrom(ctx).Can(perm)
+}
+
+// RequirePermission rejects callers that do not hold perm.
+func RequirePermission(perm models.Permission) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			role, _ := r.Context().Value(CtxRole).(models.Role)
-			if _, ok := allowed[role]; !ok {
+			if !Can(r.Context(), perm) {
 				http.Error(w, "forbidden", http.StatusForbidden)
 				return
 			}
@@ -53,3 +111,33 @@ func RequireRoles(roles ...models.Role) func(http.Handler) http.Handler {
 		})
 	}
 }
+
+// RecentlyAuthenticated reports whether the caller's last password login is within maxAge.
+// Tokens without an auth_time claim are never considered fresh.
+func RecentlyAuthenticated(ctx context.Context, maxAge time.Duration) bool {
+	at, ok := ctx.Value(CtxAuthTime).(time.Time)
+	if !ok {
+		return false
+	}
+	return time.Since(at) <= maxAge
+}
+
+// ReauthRequired writes the step-up challenge (RFC 9470) telling the client to
+// prompt for credentials and call POST /auth/reauthenticate.
+func ReauthRequired(w http.ResponseWriter, maxAge time.Duration) {
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_user_authentication", error_description="recent authentication required", max_age=%d`, int(maxAge.Seconds())))
+	httpx.ErrorCode(w, http.StatusUnauthorized, ErrCodeReauthRequired, "recent authentication required")
+}
+
+// RequireRecentAuth rejects requests whose last strong authentication is older than maxAge.
+func RequireRecentAuth(maxAge time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !RecentlyAuthenticated(r.Context(), maxAge) {
+				ReauthRequired(w, maxAge)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package models
+
+// Batch operations on users.
+const (
+	BatchSetRoles           = "set_roles"
+	BatchSuspend            = "suspend"
+	BatchDelete             = "delete"
+	BatchForcePasswordReset = "force_password_reset"
+)
+
+// Batch modes: atomic applies everything or nothing, best_effort keeps what succeeded.
+const (
+	BatchAtomic     = "atomic"
+	BatchBestEffort = "best_effort"
+)
+
+type BatchRequest struct {
+	// Mode is atomic (the default) or best_effort.
+	Mode       string           `json:"mode"`
+	Operations []BatchOperation `json:"operations"`
+}
+
+// BatchOperation applies one operation to each of the users in IDs.
+type BatchOperation struct {
+	Op  string   `json:"op"`
+	IDs []string `json:"ids"`
+	// Roles is the new role set for set_roles.
+	Roles []Role `json:"roles,omitempty"`
+	// Reason is recorded in the status history by suspend.
+	Reason string `json:"reason,omitempty"`
+}
+
+// BatchItemStatus is the outcome of one operation on one user.
+type BatchItemStatus string
+
+const (
+	BatchItemOK BatchItemStatus = "ok"
+	// BatchItemFailed items were not applied; Code and Error say why.
+	BatchItemFailed BatchItemStatus = "failed"
+	// BatchItemRolledBack items succeeded but were undone because an atomic batch failed.
+	BatchItemRolledBack BatchItemStatus = "rolled_back"
+	// BatchItemSkipped items were not attempted because an atomic batch had already failed.
+	BatchItemSkipped BatchItemStatus = "skipped"
+)
+
+type BatchItemResult struct {
+	Operation int             `json:"operation"` // index into operations
+	Op        string          `json:"op"`
+	ID        string          `json:"id"`
+	Status    BatchItemStatus `json:"status"`
+	Code      int             `json:"code,omitempty"` // HTTP status the single-user endpoint would have answered
+	Error     string          `json:"error,omitempty"`
+}
+
+type BatchResponse struct {
+	Mode      string            `json:"mode"`
+	Committed bool              `json:"committed"`
+	Succeeded int               `json:"succeeded"`
+	Failed    int               `json:"failed"`
+	Results   []BatchItemResult `json:"results"`
+}
@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+// Phone is a phone number in E.164. On input Number may be written in any
+// common format; Region (ISO 3166 alpha-2) says how to read numbers without a
+// country code and is replaced by the region the number belongs to.
+// Verified and VerifiedAt are read-only.
+type Phone struct {
+	Number     string     `json:"number"`
+	Region     string     `json:"region"`
+	Verified   bool       `json:"verified"` // confirmed by SMS since the number last changed
+	VerifiedAt *time.Time `json:"verified_at,omitempty"`
+}
+
+// Address is a postal address. The postal code is checked against the
+// country's format.
+type Address struct {
+	Lines      []string `json:"lines"` // street, number, unit; 1-3 lines
+	City       string   `json:"city"`
+	Region     string   `json:"region,omitempty"` // state, province or county
+	PostalCode string   `json:"postal_code,omitempty"`
+	Country    string   `json:"country"` // ISO 3166 alpha-2
+}
+
+// PhoneVerificationRequest records that the user confirmed Number by SMS.
+// Number must be the user's current one, so a verification can't carry over
+// to a number changed in the meantime.
+type PhoneVerificationRequest struct {
+	Number string `json:"number"`
+}
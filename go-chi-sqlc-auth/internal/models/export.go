@@ -0,0 +1,36 @@
+package models
+
+import "time"
+
+type ExportFormat string
+
+const (
+	ExportJSON ExportFormat = "json"
+	ExportZIP  ExportFormat = "zip"
+)
+
+type ExportStatus string
+
+const (
+	ExportPending ExportStatus = "pending"
+	ExportReady   ExportStatus = "ready"
+	ExportFailed  ExportStatus = "failed"
+)
+
+// DataExport is a personal data export job. DownloadURL is only set while it is ready.
+type DataExport struct {
+	ID          string       `json:"id"`
+	UserID      string       `json:"user_id"`
+	RequestedBy *string      `json:"requested_by,omitempty"`
+	Format      ExportFormat `json:"format"`
+	Status      ExportStatus `json:"status"`
+	Error       string       `json:"error,omitempty"`
+	DownloadURL string       `json:"download_url,omitempty"`
+	CreatedAt   time.Time    `json:"created_at"`
+	CompletedAt *time.Time   `json:"completed_at,omitempty"`
+	ExpiresAt   time.Time    `json:"expires_at"`
+}
+
+type DataExportRequest struct {
+	Format ExportFormat `json:"format"`
+}
@@ -0,0 +1,63 @@
+package models
+
+import "time"
+
+type ImportFormat string
+
+const (
+	ImportCSV    ImportFormat = "csv"
+	ImportNDJSON ImportFormat = "ndjson"
+)
+
+type ImportStatus string
+
+const (
+	ImportPending ImportStatus = "pending"
+	ImportRunning ImportStatus = "running"
+	ImportDone    ImportStatus = "done"
+	ImportFailed  ImportStatus = "failed"
+)
+
+// ImportRowStatus is the outcome of one row of an import.
+type ImportRowStatus string
+
+const (
+	ImportRowCreated ImportRowStatus = "created"
+	ImportRowUpdated ImportRowStatus = "updated"
+	ImportRowSkipped ImportRowStatus = "skipped"
+	ImportRowFailed  ImportRowStatus = "failed"
+)
+
+// UserImport is a bulk user import job. Rows holds the per-row report once
+// the import is done; in a dry run it says what would have happened.
+type UserImport struct {
+	ID          string            `json:"id"`
+	RequestedBy *string           `json:"requested_by,omitempty"`
+	Format      ImportFormat      `json:"format"`
+	DryRun      bool              `json:"dry_run"`
+	Upsert      bool              `json:"upsert"`
+	Status      ImportStatus      `json:"status"`
+	Error       string            `json:"error,omitempty"`
+	Total       int               `json:"total"`
+	Processed   int               `json:"processed"`
+	Created     int               `json:"created"`
+	Updated     int               `json:"updated"`
+	Skipped     int               `json:"skipped"`
+	Failed      int               `json:"failed"`
+	Rows        []ImportRowResult `json:"rows,omitempty"`
+	CreatedAt   time.Time         `json:"created_at"`
+	CompletedAt *time.Time        `json:"completed_at,omitempty"`
+	ExpiresAt   time.Time         `json:"expires_at"`
+}
+
+// ImportRowResult reports one input row. Line is the line number in the
+// uploaded file, counting the CSV header.
+type ImportRowResult struct {
+	Line   int             `json:"line"`
+	Email  string          `json:"email,omitempty"`
+	Status ImportRowStatus `json:"status"`
+	UserID string          `json:"user_id,omitempty"`
+	Error  string          `json:"error,omitempty"`
+	// Code is set for failures the single-user endpoints report with a code
+	Code string `json:"code,omitempty"`
+}
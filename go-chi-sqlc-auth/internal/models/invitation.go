@@ -0,0 +1,38 @@
+package models
+
+import "time"
+
+type Invitation struct {
+	ID         string     `json:"id"`
+	OrgID      string     `json:"org_id"`
+	Email      string     `json:"email"`
+	OrgRole    OrgRole    `json:"org_role"`
+	InvitedBy  *string    `json:"invited_by,omitempty"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	AcceptedAt *time.Time `json:"accepted_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+type InvitationRequest struct {
+	Email   string  `json:"email"`
+	OrgRole OrgRole `json:"org_role"`
+}
+
+type AcceptInvitationRequest struct {
+	Token string `json:"token"`
+}
+
+type OrgDomain struct {
+	Domain            string     `json:"domain"`
+	OrgID             string     `json:"org_id"`
+	VerificationToken string     `json:"verification_token,omitempty"`
+	VerifiedAt        *time.Time `json:"verified_at,omitempty"`
+	AutoJoinRole      OrgRole    `json:"auto_join_role"`
+	CreatedAt         time.Time  `json:"created_at"`
+}
+
+type OrgDomainRequest struct {
+	Domain       string  `json:"domain"`
+	AutoJoinRole OrgRole `json:"auto_join_role"`
+}
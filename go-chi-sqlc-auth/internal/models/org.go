@@ -0,0 +1,54 @@
+package models
+
+import "time"
+
+type OrgRole string
+
+const (
+	OrgRoleOwner  OrgRole = "owner"
+	OrgRoleAdmin  OrgRole = "admin"
+	OrgRoleMember OrgRole = "member"
+)
+
+func (r OrgRole) Valid() bool {
+	return r == OrgRoleOwner || r == OrgRoleAdmin || r == OrgRoleMember
+}
+
+type Organization struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Slug      string    `json:"slug"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type OrgRequest struct {
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+}
+
+type Membership struct {
+	UserID    string    `json:"user_id"`
+	OrgID     string    `json:"org_id"`
+	Username  string    `json:"username,omitempty"`
+	Email     string    `json:"email,omitempty"`
+	OrgRole   OrgRole   `json:"org_role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// UserOrganization is an organization as embedded in a user with ?expand=organizations.
+type UserOrganization struct {
+	ID       string    `json:"id"`
+	Name     string    `json:"name"`
+	Slug     string    `json:"slug"`
+	OrgRole  OrgRole   `json:"org_role"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+type MembershipRequest struct {
+	OrgRole OrgRole `json:"org_role"`
+}
+
+type SwitchOrgRequest struct {
+	OrgID string `json:"org_id"`
+}
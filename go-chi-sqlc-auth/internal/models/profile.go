@@ -0,0 +1,69 @@
+package models
+
+import "time"
+
+type AttributeType string
+
+const (
+	AttrString  AttributeType = "string"
+	AttrNumber  AttributeType = "number"
+	AttrBoolean AttributeType = "boolean"
+	AttrDate    AttributeType = "date" // YYYY-MM-DD
+	AttrEnum    AttributeType = "enum" // one of Options
+)
+
+// AttributeVisibility says who sees and edits an attribute's value.
+type AttributeVisibility string
+
+const (
+	// VisibilityPublic values are seen by anyone who can read the user, and
+	// edited by the user and their admins.
+	VisibilityPublic AttributeVisibility = "public"
+	// VisibilityPrivate values are seen and edited by the user and their admins.
+	VisibilityPrivate AttributeVisibility = "private"
+	// VisibilityAdmin values are seen and edited by admins only.
+	VisibilityAdmin AttributeVisibility = "admin"
+)
+
+// ProfileAttribute is an admin-defined profile field. Its values are kept
+// per user and checked against the definition whenever they are written.
+type ProfileAttribute struct {
+	Name        string              `json:"name"`
+	Label       string              `json:"label"`
+	Description string              `json:"description"`
+	Type        AttributeType       `json:"type"`
+	Required    bool                `json:"required"`
+	Pattern     string              `json:"pattern,omitempty"` // regexp the whole value must match (string only)
+	Options     []string            `json:"options,omitempty"` // enum only
+	Visibility  AttributeVisibility `json:"visibility"`
+	CreatedAt   time.Time           `json:"created_at"`
+	UpdatedAt   time.Time           `json:"updated_at"`
+}
+
+// Digest frequencies for NotificationSettings.
+const (
+	DigestOff    = "off"
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+)
+
+// NotificationSettings are the optional emails a user receives. Security
+// email such as password links is always sent.
+type NotificationSettings struct {
+	ProductUpdates bool   `json:"product_updates"`
+	OrgActivity    bool   `json:"org_activity"`
+	Digest         string `json:"digest"`
+}
+
+type Preferences struct {
+	Locale        string               `json:"locale"`   // BCP 47, e.g. en-GB
+	Timezone      string               `json:"timezone"` // IANA, e.g. Europe/Berlin
+	Notifications NotificationSettings `json:"notifications"`
+	UpdatedAt     *time.Time           `json:"updated_at"` // nil until first saved
+}
+
+// DefaultPreferences apply to users who never saved any.
+func DefaultPreferences() Preferences {
+	return Preferences{Locale: "en", Timezone: "UTC",
+		Notifications: NotificationSettings{OrgActivity: true, Digest: DigestOff}}
+}
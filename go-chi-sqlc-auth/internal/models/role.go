@@ -0,0 +1,102 @@
+package models
+
+import "time"
+
+type Permission string
+
+const (
+	PermUsersCreate      Permission = "users:create"
+	PermUsersRead        Permission = "users:read"
+	PermUsersUpdate      Permission = "users:update"
+	PermUsersDelete      Permission = "users:delete"
+	PermUsersRoleAssign  Permission = "users:role:assign"
+	PermUsersPasswordSet Permission = "users:password:set"
+	PermUsersStatus      Permission = "users:status:update"
+	PermUsersExport      Permission = "users:export"
+	PermRolesRead        Permission = "roles:read"
+	PermRolesManage      Permission = "roles:manage"
+	PermPlatformAdmin    Permission = "platform:admin"
+	PermRegistrations    Permission = "registrations:manage"
+	PermProfileAttrs     Permission = "profile:attributes:manage"
+	PermUsernames        Permission = "usernames:manage"
+)
+
+// RoleDefinition is a role row together with the permissions it grants.
+type RoleDefinition struct {
+	ID          string       `json:"id"`
+	Name        Role         `json:"name"`
+	Description string       `json:"description"`
+	BuiltIn     bool         `json:"built_in"`
+	Level       int          `json:"level"`
+	Permissions []Permission `json:"permissions"`
+	CreatedAt   time.Time    `json:"created_at"`
+	UpdatedAt   time.Time    `json:"updated_at"`
+}
+
+type RoleRequest struct {
+	Name        Role         `json:"name"`
+	Description string       `json:"description"`
+	Level       int          `json:"level"`
+	Permissions []Permission `json:"permissions"`
+}
+
+type PermissionDefinition struct {
+	Name        Permission `json:"name"`
+	Description string     `json:"description"`
+}
+
+// Principal is the authenticated caller with roles and permissions loaded from the database.
+type Principal struct {
+	UserID      string
+	Status      UserStatus
+	Roles       []Role
+	Permissions []Permission
+	// Level is the highest level among the caller's roles.
+	Level int
+	// Scopes limits user management to these email domains; empty means unrestricted.
+	Scopes []string
+	// OrgID is the active organization from the token, cleared if the membership is gone.
+	OrgID   string
+	OrgRole OrgRole
+}
+
+// ManagedUser is what authorization needs to know about the target of a user operation.
+type ManagedUser struct {
+	ID          string
+	EmailDomain string
+	Level       int
+	Roles       []Role
+	OrgIDs      []string
+}
+
+type AdminScopesRequest struct {
+	Domains []string `json:"domains"`
+}
+
+func (p *Principal) Can(perm Permission) bool {
+	if p == nil {
+		return false
+	}
+	for _, have := range p.Permissions {
+		if have == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// InScope reports whether the target falls within the caller's delegated scope.
+func (p *Principal) InScope(u ManagedUser) bool {
+	if p == nil {
+		return false
+	}
+	if len(p.Scopes) == 0 {
+		return true
+	}
+	for _, d := range p.Scopes {
+		if d == u.EmailDomain {
+			return true
+		}
+	}
+	return false
+}
@@ -9,50 +9,192 @@ const (
 	RoleUser  Role = "user"
 )
 
+type UserStatus string
+
+const (
+	StatusActive      UserStatus = "active"
+	StatusPending     UserStatus = "pending"     // waiting for admin approval
+	StatusSuspended   UserStatus = "suspended"   // blocked by an admin, e.g. for abuse
+	StatusLocked      UserStatus = "locked"      // blocked for security reasons, e.g. a compromised account
+	StatusDeactivated UserStatus = "deactivated" // closed; kept for history
+)
+
+// statusTransitions lists the statuses each status may move to.
+var statusTransitions = map[UserStatus][]UserStatus{
+	StatusPending:     {StatusActive, StatusDeactivated},
+	StatusActive:      {StatusSuspended, StatusLocked, StatusDeactivated},
+	StatusSuspended:   {StatusActive, StatusDeactivated},
+	StatusLocked:      {StatusActive, StatusDeactivated},
+	StatusDeactivated: {StatusActive},
+}
+
+func (s UserStatus) Valid() bool {
+	_, ok := statusTransitions[s]
+	return ok
+}
+
+// CanTransitionTo reports whether an account in status s may be moved to next.
+func (s UserStatus) CanTransitionTo(next UserStatus) bool {
+	for _, t := range statusTransitions[s] {
+		if t == next {
+			return true
+		}
+	}
+	return false
+}
+
 type User struct {
-	ID          string    `json:"id"`
-	Username    string    `json:"username"`
-	Email       string    `json:"email"`
-	FirstName   string    `json:"first_name"`
-	LastName    string    `json:"last_name"`
-	PhoneNumber *string   `json:"phone_number,omitempty"`
-	Address     *string   `json:"address,omitempty"`
-	Role        Role      `json:"role"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	ID        string   `json:"id"`
+	Username  string   `json:"username"`
+	Email     string   `json:"email"`
+	FirstName string   `json:"first_name"`
+	LastName  string   `json:"last_name"`
+	Phone     *Phone   `json:"phone"`
+	Address   *Address `json:"address"`
+	// Free text saved before phone numbers and addresses were structured,
+	// kept until the user enters a new value.
+	LegacyPhoneNumber *string    `json:"legacy_phone_number,omitempty"`
+	LegacyAddress     *string    `json:"legacy_address,omitempty"`
+	AvatarURL         *string    `json:"avatar_url"`
+	Roles             []Role     `json:"roles"`
+	Status            UserStatus `json:"status"`
+	StatusReason      string     `json:"status_reason,omitempty"`
+	StatusChangedAt   time.Time  `json:"status_changed_at"`
+	DeletedAt         *time.Time `json:"deleted_at,omitempty"`
+	Version           int64      `json:"-"` // sent as the ETag
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
 }
 
 type CreateUserRequest struct {
-	Username    string  `json:"username"`
-	Email       string  `json:"email"`
-	Password    string  `json:"password"`
-	FirstName   string  `json:"first_name"`
-	LastName    string  `json:"last_name"`
-	PhoneNumber *string `json:"phone_number"`
-	Address     *string `json:"address"`
-	Role        *Role   `json:"role"`
+	Username  string   `json:"username"`
+	Email     string   `json:"email"`
+	Password  string   `json:"password"`
+	FirstName string   `json:"first_name"`
+	LastName  string   `json:"last_name"`
+	Phone     *Phone   `json:"phone"`
+	Address   *Address `json:"address"`
+	// InviteToken joins the organization that sent the invitation.
+	InviteToken *string `json:"invite_token"`
+	// InviteCode is a registration code, required in invite-only mode unless InviteToken is set.
+	InviteCode *string `json:"invite_code"`
+}
+
+// AdminCreateUserRequest creates an account without a password; the user
+// chooses one through the emailed set-password link.
+type AdminCreateUserRequest struct {
+	Username  string   `json:"username"`
+	Email     string   `json:"email"`
+	FirstName string   `json:"first_name"`
+	LastName  string   `json:"last_name"`
+	Phone     *Phone   `json:"phone"`
+	Address   *Address `json:"address"`
+	// Roles defaults to the user role.
+	Roles []Role `json:"roles"`
+}
+
+// PasswordSetupRequest redeems a set-password link.
+type PasswordSetupRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
 }
 
 type UpdateUserRequest struct {
-	Username    string  `json:"username"`
-	Email       string  `json:"email"`
-	FirstName   string  `json:"first_name"`
-	LastName    string  `json:"last_name"`
-	PhoneNumber *string `json:"phone_number"`
-	Address     *string `json:"address"`
-	Role        *Role   `json:"role"`
+	Username  string   `json:"username"`
+	Email     string   `json:"email"`
+	FirstName string   `json:"first_name"`
+	LastName  string   `json:"last_name"`
+	Phone     *Phone   `json:"phone"`
+	Address   *Address `json:"address"`
+	// Roles replaces the user's role assignments when set; requires users:role:assign.
+	Roles []Role `json:"roles"`
 }
 
 type UpdatePasswordRequest struct {
 	Password string `json:"password"`
 }
 
+type StatusChangeRequest struct {
+	Reason string `json:"reason"`
+}
+
+// StatusChange is one entry of a user's status history.
+type StatusChange struct {
+	From      UserStatus `json:"from"`
+	To        UserStatus `json:"to"`
+	Reason    string     `json:"reason"`
+	ChangedBy *string    `json:"changed_by,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
 type LoginRequest struct {
+	// Login is the username or email; Email is accepted for older clients
+	Login    string `json:"login"`
 	Email    string `json:"email"`
 	Password string `json:"password"`
 }
 
+type ReauthenticateRequest struct {
+	Password string `json:"password"`
+}
+
+// RegistrationCode is an admin-issued code that allows sign-up in invite-only mode.
+type RegistrationCode struct {
+	ID        string     `json:"id"`
+	Code      string     `json:"code,omitempty"` // only returned on creation
+	Note      string     `json:"note"`
+	MaxUses   *int       `json:"max_uses,omitempty"`
+	Uses      int        `json:"uses"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	CreatedBy *string    `json:"created_by,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+type RegistrationCodeRequest struct {
+	Note      string     `json:"note"`
+	MaxUses   *int       `json:"max_uses"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// BlockedUsername is a blocklist pattern; '*' matches any run of characters, '?' one.
+type BlockedUsername struct {
+	ID        string    `json:"id"`
+	Pattern   string    `json:"pattern"`
+	Note      string    `json:"note"`
+	CreatedBy *string   `json:"created_by,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type BlockedUsernameRequest struct {
+	Pattern string `json:"pattern"`
+	Note    string `json:"note"`
+}
+
+// UsernameAvailability answers whether a username can be registered. Code is
+// set when it can't, with the same values the registration errors carry.
+type UsernameAvailability struct {
+	Username  string `json:"username"`
+	Available bool   `json:"available"`
+	Code      string `json:"code,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+}
+
 type AuthResponse struct {
 	Token string `json:"token"`
-	Role  Role   `json:"role"`
+	Roles []Role `json:"roles"`
+	OrgID string `json:"org_id,omitempty"`
+	// Warning reports something that went wrong after the account was saved
+	Warning string `json:"warning,omitempty"`
+}
+
+type EmailVerificationRequest struct {
+	Token string `json:"token"`
+}
+
+// Avatar answers an upload with the URL stored as User.AvatarURL and the
+// URL of every thumbnail size, keyed by edge length in pixels.
+type Avatar struct {
+	URL   string            `json:"avatar_url"`
+	Sizes map[string]string `json:"sizes"`
 }
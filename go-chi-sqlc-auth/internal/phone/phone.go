@@ -0,0 +1,38 @@
+// Package phone normalizes phone numbers to E.164 using libphonenumber's
+// metadata.
+package phone
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/nyaruka/phonenumbers"
+)
+
+var (
+	// ErrNoRegion means a national number came without a region to read it in.
+	ErrNoRegion = errors.New("phone number needs a country code or a region")
+	ErrInvalid  = errors.New("not a valid phone number")
+)
+
+// Normalize parses number and returns it in E.164 (+4930123456) together
+// with the ISO 3166 region it belongs to. region is a hint for numbers
+// written without a country code; it is ignored for international ones.
+// Non-geographic numbers such as +800 have no region.
+func Normalize(number, region string) (e164, numberRegion string, err error) {
+	n, err := phonenumbers.Parse(number, strings.ToUpper(region))
+	if errors.Is(err, phonenumbers.ErrInvalidCountryCode) {
+		if region == "" && !strings.HasPrefix(strings.TrimSpace(number), "+") {
+			return "", "", ErrNoRegion
+		}
+		return "", "", ErrInvalid
+	}
+	if err != nil || !phonenumbers.IsValidNumber(n) {
+		return "", "", ErrInvalid
+	}
+	numberRegion = phonenumbers.GetRegionCodeForNumber(n)
+	if numberRegion == phonenumbers.UNKNOWN_REGION || numberRegion == "001" {
+		numberRegion = ""
+	}
+	return phonenumbers.Format(n, phonenumbers.E164), numberRegion, nil
+}
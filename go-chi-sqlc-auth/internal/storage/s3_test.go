@@ -0,0 +1,82 @@
+package storage_test
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"dev.mfr/go-chi-sqlc-auth/internal/storage"
+	"dev.mfr/go-chi-sqlc-auth/internal/storage/s3fake"
+)
+
+func newS3Store(t *testing.T) (*storage.S3Store, *s3fake.Server) {
+	t.Helper()
+	fake := s3fake.New("access", "secret")
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	return &storage.S3Store{Endpoint: srv.URL, Region: "eu-central-1", Bucket: "media",
+		AccessKey: "access", SecretKey: "secret", Client: srv.Client()}, fake
+}
+
+func TestS3StorePutAndDelete(t *testing.T) {
+	s, fake := newS3Store(t)
+	ctx := context.Background()
+	key := "avatars/u1/a b.png"
+
+	if err := s.Put(ctx, key, "image/png", []byte("png")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	o, ok := fake.Object("media", key)
+	if !ok {
+		t.Fatalf("object %q not stored", key)
+	}
+	if o.ContentType != "image/png" || !bytes.Equal(o.Data, []byte("png")) {
+		t.Errorf("stored %q %q, want image/png png", o.ContentType, o.Data)
+	}
+
+	if err := s.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if fake.Len() != 0 {
+		t.Errorf("%d objects left after Delete", fake.Len())
+	}
+}
+
+func TestS3StoreDeleteMissingKey(t *testing.T) {
+	s, _ := newS3Store(t)
+	if err := s.Delete(context.Background(), "avatars/u1/missing.png"); err != nil {
+		t.Errorf("Delete of a missing key: %v", err)
+	}
+}
+
+func TestS3StoreWrongSecret(t *testing.T) {
+	s, fake := newS3Store(t)
+	s.SecretKey = "wrong"
+	if err := s.Put(context.Background(), "avatars/u1/a.png", "image/png", []byte("png")); err == nil {
+		t.Error("Put with a wrong secret succeeded")
+	}
+	if fake.Len() != 0 {
+		t.Errorf("%d objects stored with a wrong secret", fake.Len())
+	}
+}
+
+func TestS3StoreURL(t *testing.T) {
+	s := &storage.S3Store{Endpoint: "http://localhost:9000", Bucket: "media"}
+	if got, want := s.URL("avatars/u1/a b.png"), "http://localhost:9000/media/avatars/u1/a%20b.png"; got != want {
+		t.Errorf("URL = %q, want %q", got, want)
+	}
+	s.PublicURL = "https://cdn.example.com"
+	if got, want := s.URL("avatars/u1/a.png"), "https://cdn.example.com/avatars/u1/a.png"; got != want {
+		t.Errorf("URL with PublicURL = %q, want %q", got, want)
+	}
+}
+
+func TestS3StoreInvalidKey(t *testing.T) {
+	s, _ := newS3Store(t)
+	for _, key := range []string{"", "/abs", "a/../b"} {
+		if err := s.Put(context.Background(), key, "image/png", nil); err != storage.ErrInvalidKey {
+			t.Errorf("Put(%q) = %v, want ErrInvalidKey", key, err)
+		}
+	}
+}
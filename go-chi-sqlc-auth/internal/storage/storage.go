@@ -0,0 +1,63 @@
+// Package storage keeps uploaded files such as avatars outside the database.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"dev.mfr/go-chi-sqlc-auth/internal/config"
+)
+
+var ErrInvalidKey = errors.New("invalid blob key")
+
+// BlobStore stores files under slash-separated keys and hands out public URLs
+// for them. Keys are never reused, so a stored blob can be cached forever.
+type BlobStore interface {
+	Put(ctx context.Context, key, contentType string, data []byte) error
+	// Delete removes the blob; deleting a missing key is not an error.
+	Delete(ctx context.Context, key string) error
+	URL(key string) string
+}
+
+// New returns the store selected by STORAGE_DRIVER: "local" (the default) or "s3".
+// Local files are served by the app under baseURL + "/media".
+func New(cfg config.StorageConfig, baseURL string) (BlobStore, error) {
+	switch cfg.Driver {
+	case "", "local":
+		return &LocalStore{Dir: cfg.LocalDir, BaseURL: strings.TrimRight(baseURL, "/") + "/media"}, nil
+	case "s3":
+		if cfg.S3Endpoint == "" || cfg.S3Bucket == "" {
+			return nil, fmt.Errorf("STORAGE_S3_ENDPOINT and STORAGE_S3_BUCKET required for s3 driver")
+		}
+		return &S3Store{Endpoint: strings.TrimRight(cfg.S3Endpoint, "/"), Region: cfg.S3Region, Bucket: cfg.S3Bucket,
+			AccessKey: cfg.S3AccessKey, SecretKey: cfg.S3SecretKey, PublicURL: strings.TrimRight(cfg.S3PublicURL, "/")}, nil
+	}
+	return nil, fmt.Errorf("unknown storage driver %q", cfg.Driver)
+}
+
+// DeleteAll removes keys, logging rather than stopping at failures: it runs
+// after the database no longer points at them, so a leftover blob is only
+// wasted space.
+func DeleteAll(ctx context.Context, s BlobStore, keys []string) {
+	for _, k := range keys {
+		if err := s.Delete(ctx, k); err != nil {
+			log.Printf("storage: delete %s: %v", k, err)
+		}
+	}
+}
+
+// validKey rejects keys that could escape the store's root.
+func validKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return false
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+	return true
+}
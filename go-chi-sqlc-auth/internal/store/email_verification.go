@@ -0,0 +1,46 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var ErrEmailVerificationInvalid = errors.New("verification link is invalid, expired or already used")
+
+// CreateEmailVerification stores a link proving userID owns email, identified by tokenHash.
+func CreateEmailVerification(ctx context.Context, db DBTX, userID, email, tokenHash string, expires time.Time) error {
+	_, err := db.Exec(ctx, `INSERT INTO email_verification_tokens (user_id, email, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4)`, userID, email, tokenHash, expires)
+	return err
+}
+
+// ConsumeEmailVerification marks the user's email verified if the link
+// identified by tokenHash was sent to their current address, and uses up all
+// their open links. It returns the user.
+func ConsumeEmailVerification(ctx context.Context, db DBTX, tokenHash string) (string, error) {
+	var userID string
+	err := db.QueryRow(ctx, `UPDATE email_verification_tokens t SET used_at = now()
+		FROM users u
+		WHERE t.token_hash=$1 AND t.used_at IS NULL AND t.expires_at > now()
+			AND u.id = t.user_id AND u.deleted_at IS NULL AND u.email_key = lower(normalize(t.email, NFKC))
+		RETURNING t.user_id`, tokenHash).Scan(&userID)
+	if err == pgx.ErrNoRows {
+		return "", ErrEmailVerificationInvalid
+	}
+	if err != nil {
+		return "", err
+	}
+	if _, err := db.Exec(ctx, "UPDATE email_verification_tokens SET used_at = now() WHERE user_id=$1 AND used_at IS NULL", userID); err != nil {
+		return "", err
+	}
+	return userID, MarkEmailVerified(ctx, db, userID)
+}
+
+// MarkEmailVerified records that the user proved they own their current address.
+func MarkEmailVerified(ctx context.Context, db DBTX, userID string) error {
+	_, err := db.Exec(ctx, "UPDATE users SET email_verified_at = COALESCE(email_verified_at, now()), updated_at = now() WHERE id=$1", userID)
+	return err
+}
@@ -0,0 +1,107 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"dev.mfr/go-chi-sqlc-auth/internal/models"
+	"github.com/jackc/pgx/v5"
+)
+
+// ScheduleDeletion marks the user's account for erasure at the given time.
+// It returns pgx.ErrNoRows when the user does not exist or is deleted.
+func ScheduleDeletion(ctx context.Context, db DBTX, userID string, at time.Time) error {
+	ct, err := db.Exec(ctx, "UPDATE users SET deletion_scheduled_at=$2, updated_at=now() WHERE id=$1 AND deleted_at IS NULL", userID, at)
+	if err != nil {
+		return err
+	}
+	if ct.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// CancelDeletion clears a scheduled erasure and reports whether one was pending.
+func CancelDeletion(ctx context.Context, db DBTX, userID string) (bool, error) {
+	ct, err := db.Exec(ctx, "UPDATE users SET deletion_scheduled_at=NULL, updated_at=now() WHERE id=$1 AND deletion_scheduled_at IS NOT NULL", userID)
+	if err != nil {
+		return false, err
+	}
+	return ct.RowsAffected() > 0, nil
+}
+
+// DueErasures returns the users whose grace period ended before now.
+func DueErasures(ctx context.Context, db DBTX, now time.Time) ([]string, error) {
+	rows, err := db.Query(ctx, "SELECT id FROM users WHERE deletion_scheduled_at <= $1 AND erased_at IS NULL", now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// Erasure describes what EraseUser removed; it holds no personal data.
+type Erasure struct {
+	UserID      string
+	ScheduledAt time.Time
+	ErasedAt    time.Time
+	// Removed counts deleted rows per table.
+	Removed map[string]int64
+	// AvatarKeys are the blobs of the user's avatar, to delete after commit.
+	AvatarKeys []string
+}
+
+// EraseUser anonymizes the user's row and deletes everything linked to it.
+// The row itself is kept, marked deleted, so references resolve until the
+// purger removes it. Tokens are stateless JWTs; they stop working because the
+// account is deleted. Run it in a transaction together with the audit receipt.
+func EraseUser(ctx context.Context, tx pgx.Tx, userID string) (Erasure, error) {
+	e := Erasure{UserID: userID, Removed: map[string]int64{}}
+	var email string
+	err := tx.QueryRow(ctx, `SELECT email, deletion_scheduled_at, avatar_keys FROM users
+		WHERE id=$1 AND deletion_scheduled_at IS NOT NULL AND erased_at IS NULL FOR UPDATE`, userID).Scan(&email, &e.ScheduledAt, &e.AvatarKeys)
+	if err != nil {
+		return e, err
+	}
+	related := []struct{ table, sql string }{
+		{"user_roles", "DELETE FROM user_roles WHERE user_id=$1"},
+		{"user_admin_scopes", "DELETE FROM user_admin_scopes WHERE user_id=$1"},
+		{"memberships", "DELETE FROM memberships WHERE user_id=$1"},
+		{"data_exports", "DELETE FROM data_exports WHERE user_id=$1"},
+		{"password_setup_tokens", "DELETE FROM password_setup_tokens WHERE user_id=$1"},
+		{"email_verification_tokens", "DELETE FROM email_verification_tokens WHERE user_id=$1"},
+		{"user_preferences", "DELETE FROM user_preferences WHERE user_id=$1"},
+		// reasons are free text and may mention the person
+		{"user_status_history", "DELETE FROM user_status_history WHERE user_id=$1"},
+	}
+	for _, q := range related {
+		ct, err := tx.Exec(ctx, q.sql, userID)
+		if err != nil {
+			return e, err
+		}
+		e.Removed[q.table] = ct.RowsAffected()
+	}
+	ct, err := tx.Exec(ctx, "DELETE FROM org_invitations WHERE lower(email)=lower($1) OR accepted_by=$2", email, userID)
+	if err != nil {
+		return e, err
+	}
+	e.Removed["org_invitations"] = ct.RowsAffected()
+	// Placeholders keep the NOT NULL and UNIQUE constraints satisfied; the
+	// empty password hash never matches.
+	err = tx.QueryRow(ctx, `UPDATE users SET
+			username = 'erased-' || id, email_verified_at = NULL, username_skeleton = translate('erased-' || id, '01', 'ol'), email = 'erased-' || id || '@invalid',
+			password_hash = '', first_name = '', last_name = '', avatar_url = NULL, avatar_keys = '{}', attributes = '{}',
+			phone_number = NULL, phone_region = NULL, phone_verified_at = NULL, address = NULL, legacy_phone_number = NULL, legacy_address = NULL,
+			status = $2, status_reason = '', status_changed_at = now(), status_changed_by = NULL,
+			deletion_scheduled_at = NULL, erased_at = now(), deleted_at = now(), deleted_by = NULL, updated_at = now()
+		WHERE id=$1 RETURNING erased_at`, userID, models.StatusDeactivated).Scan(&e.ErasedAt)
+	return e, err
+}
@@ -0,0 +1,60 @@
+package store
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// testDB connects to TEST_DATABASE_URL, a database with the migrations
+// applied, and skips the test without it.
+func testDB(t *testing.T) *pgx.Conn {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	conn, err := pgx.Connect(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close(context.Background()) })
+	return conn
+}
+
+func TestEraseUserDeletesEmailVerificationTokens(t *testing.T) {
+	ctx := context.Background()
+	tx, err := testDB(t).Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+
+	var id string
+	err = tx.QueryRow(ctx, `INSERT INTO users (username, username_skeleton, email, password_hash, first_name, last_name, deletion_scheduled_at)
+		VALUES ('erasure-test', 'erasure-test', 'erasure-test@example.com', '', 'Erasure', 'Test', now()) RETURNING id`).Scan(&id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := CreateEmailVerification(ctx, tx, id, "erasure-test@example.com", "erasure-test-token", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := EraseUser(ctx, tx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Removed["email_verification_tokens"] != 1 {
+		t.Errorf("removed %d email_verification_tokens, want 1", e.Removed["email_verification_tokens"])
+	}
+	var left int
+	if err := tx.QueryRow(ctx, "SELECT count(*) FROM email_verification_tokens WHERE user_id=$1", id).Scan(&left); err != nil {
+		t.Fatal(err)
+	}
+	if left != 0 {
+		t.Errorf("%d email_verification_tokens left after erasure", left)
+	}
+}
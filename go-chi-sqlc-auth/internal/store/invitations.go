@@ -0,0 +1,63 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	ErrInvitationInvalid       = errors.New("invitation is invalid, expired or already used")
+	ErrInvitationEmailMismatch = errors.New("invitation was sent to a different email address")
+)
+
+// AcceptInvitation redeems the invitation identified by tokenHash for a user
+// with the given email and creates (or upgrades) their membership. The
+// invitation was mailed to the address, so this also verifies it. It returns
+// the org id.
+func AcceptInvitation(ctx context.Context, db DBTX, tokenHash, userID, email string) (string, error) {
+	var id, orgID, invited, orgRole string
+	err := db.QueryRow(ctx, `SELECT id, org_id, email, org_role FROM org_invitations
+		WHERE token_hash=$1 AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > now()
+		FOR UPDATE`, tokenHash).Scan(&id, &orgID, &invited, &orgRole)
+	if err == pgx.ErrNoRows {
+		return "", ErrInvitationInvalid
+	}
+	if err != nil {
+		return "", err
+	}
+	if !strings.EqualFold(invited, email) {
+		return "", ErrInvitationEmailMismatch
+	}
+	if _, err := db.Exec(ctx, "UPDATE org_invitations SET accepted_at=now(), accepted_by=$2 WHERE id=$1", id, userID); err != nil {
+		return "", err
+	}
+	if err := MarkEmailVerified(ctx, db, userID); err != nil {
+		return "", err
+	}
+	// Never downgrade an existing membership through an invitation
+	_, err = db.Exec(ctx, `INSERT INTO memberships (user_id, org_id, org_role) VALUES ($1,$2,$3)
+		ON CONFLICT (user_id, org_id) DO UPDATE SET org_role = CASE
+			WHEN memberships.org_role = 'owner' OR EXCLUDED.org_role = 'member' THEN memberships.org_role
+			ELSE EXCLUDED.org_role END`, userID, orgID, orgRole)
+	return orgID, err
+}
+
+// AutoJoinByDomain attaches the user, as a member, to every organization that
+// has verified their email domain. Anyone can sign up with any address, so
+// it does nothing until the user has verified theirs. It returns the ids of
+// the organizations joined.
+func AutoJoinByDomain(ctx context.Context, db DBTX, userID string) ([]string, error) {
+	rows, err := db.Query(ctx, `INSERT INTO memberships (user_id, org_id, org_role)
+		SELECT u.id, d.org_id, 'member' FROM users u
+		JOIN org_domains d ON d.domain = lower(split_part(u.email, '@', 2)) AND d.verified_at IS NOT NULL
+		WHERE u.id=$1 AND u.email_verified_at IS NOT NULL
+		ON CONFLICT DO NOTHING
+		RETURNING org_id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
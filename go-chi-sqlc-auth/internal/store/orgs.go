@@ -0,0 +1,46 @@
+package store
+
+import (
+	"context"
+
+	"dev.mfr/go-chi-sqlc-auth/internal/models"
+	"github.com/jackc/pgx/v5"
+)
+
+// DefaultOrg returns the organization a user joined first, or "" if they have none.
+func DefaultOrg(ctx context.Context, db DBTX, userID string) (string, error) {
+	var orgID string
+	err := db.QueryRow(ctx, "SELECT org_id FROM memberships WHERE user_id=$1 ORDER BY created_at, org_id LIMIT 1", userID).Scan(&orgID)
+	if err == pgx.ErrNoRows {
+		return "", nil
+	}
+	return orgID, err
+}
+
+// MembershipRole returns the user's role in an organization, or pgx.ErrNoRows if they aren't a member.
+func MembershipRole(ctx context.Context, db DBTX, userID, orgID string) (models.OrgRole, error) {
+	var role models.OrgRole
+	err := db.QueryRow(ctx, "SELECT org_role FROM memberships WHERE user_id=$1 AND org_id=$2", userID, orgID).Scan(&role)
+	return role, err
+}
+
+// UserOrganizations returns the organizations each of the users belongs to, keyed by user id.
+func UserOrganizations(ctx context.Context, db DBTX, userIDs []string) (map[string][]models.UserOrganization, error) {
+	rows, err := db.Query(ctx, `SELECT m.user_id, o.id, o.name, o.slug, m.org_role, m.created_at
+		FROM memberships m JOIN organizations o ON o.id = m.org_id
+		WHERE m.user_id = ANY($1) ORDER BY m.created_at, o.id`, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[string][]models.UserOrganization{}
+	for rows.Next() {
+		var userID string
+		var o models.UserOrganization
+		if err := rows.Scan(&userID, &o.ID, &o.Name, &o.Slug, &o.OrgRole, &o.JoinedAt); err != nil {
+			return nil, err
+		}
+		out[userID] = append(out[userID], o)
+	}
+	return out, rows.Err()
+}
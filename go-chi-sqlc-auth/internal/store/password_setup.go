@@ -0,0 +1,39 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var ErrPasswordSetupInvalid = errors.New("password setup link is invalid, expired or already used")
+
+// CreatePasswordSetup stores a set-password link for userID mailed to email,
+// identified by tokenHash.
+func CreatePasswordSetup(ctx context.Context, db DBTX, userID, email, createdBy, tokenHash string, expires time.Time) error {
+	_, err := db.Exec(ctx, `INSERT INTO password_setup_tokens (user_id, email, token_hash, created_by, expires_at)
+		VALUES ($1, $2, $3, NULLIF($4, '')::uuid, $5)`, userID, email, tokenHash, createdBy, expires)
+	return err
+}
+
+// ConsumePasswordSetup marks the link identified by tokenHash, and any other
+// open link of the same user, as used and returns the user. Deleted users'
+// links no longer work. sameEmail reports whether the link was mailed to the
+// user's current address; only then does redeeming it prove they own it.
+func ConsumePasswordSetup(ctx context.Context, db DBTX, tokenHash string) (userID string, sameEmail bool, err error) {
+	err = db.QueryRow(ctx, `UPDATE password_setup_tokens t SET used_at = now()
+		FROM users u
+		WHERE t.token_hash=$1 AND t.used_at IS NULL AND t.expires_at > now()
+			AND u.id = t.user_id AND u.deleted_at IS NULL
+		RETURNING t.user_id, COALESCE(u.email_key = lower(normalize(t.email, NFKC)), false)`, tokenHash).Scan(&userID, &sameEmail)
+	if err == pgx.ErrNoRows {
+		return "", false, ErrPasswordSetupInvalid
+	}
+	if err != nil {
+		return "", false, err
+	}
+	_, err = db.Exec(ctx, "UPDATE password_setup_tokens SET used_at = now() WHERE user_id=$1 AND used_at IS NULL", userID)
+	return userID, sameEmail, err
+}
@@ -0,0 +1,99 @@
+package store
+
+import (
+	"context"
+
+	"dev.mfr/go-chi-sqlc-auth/internal/models"
+	"github.com/jackc/pgx/v5"
+)
+
+const profileAttributeColumns = "name, label, description, type, required, pattern, options, visibility, created_at, updated_at"
+
+func scanProfileAttribute(row pgx.Row, a *models.ProfileAttribute) error {
+	return row.Scan(&a.Name, &a.Label, &a.Description, &a.Type, &a.Required, &a.Pattern, &a.Options, &a.Visibility, &a.CreatedAt, &a.UpdatedAt)
+}
+
+// ProfileAttributes returns every attribute definition, by name.
+func ProfileAttributes(ctx context.Context, db DBTX) ([]models.ProfileAttribute, error) {
+	rows, err := db.Query(ctx, "SELECT "+profileAttributeColumns+" FROM profile_attributes ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []models.ProfileAttribute{}
+	for rows.Next() {
+		var a models.ProfileAttribute
+		if err := scanProfileAttribute(rows, &a); err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
+
+// ProfileAttribute returns one definition, or pgx.ErrNoRows.
+func ProfileAttribute(ctx context.Context, db DBTX, name string) (models.ProfileAttribute, error) {
+	var a models.ProfileAttribute
+	err := scanProfileAttribute(db.QueryRow(ctx, "SELECT "+profileAttributeColumns+" FROM profile_attributes WHERE name=$1", name), &a)
+	return a, err
+}
+
+// CreateProfileAttribute stores a new definition.
+func CreateProfileAttribute(ctx context.Context, db DBTX, a models.ProfileAttribute) (models.ProfileAttribute, error) {
+	err := scanProfileAttribute(db.QueryRow(ctx, `INSERT INTO profile_attributes (name, label, description, type, required, pattern, options, visibility)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8) RETURNING `+profileAttributeColumns,
+		a.Name, a.Label, a.Description, a.Type, a.Required, a.Pattern, a.Options, a.Visibility), &a)
+	return a, err
+}
+
+// UpdateProfileAttribute changes a definition; name and type stay. Stored
+// values are not revalidated. It returns pgx.ErrNoRows when there is no such attribute.
+func UpdateProfileAttribute(ctx context.Context, db DBTX, a models.ProfileAttribute) (models.ProfileAttribute, error) {
+	err := scanProfileAttribute(db.QueryRow(ctx, `UPDATE profile_attributes SET label=$2, description=$3, required=$4, pattern=$5, options=$6, visibility=$7, updated_at=now()
+		WHERE name=$1 RETURNING `+profileAttributeColumns,
+		a.Name, a.Label, a.Description, a.Required, a.Pattern, a.Options, a.Visibility), &a)
+	return a, err
+}
+
+// DeleteProfileAttribute removes a definition and every user's value for it,
+// returning how many users had one. Run it in a transaction.
+func DeleteProfileAttribute(ctx context.Context, tx pgx.Tx, name string) (int64, error) {
+	ct, err := tx.Exec(ctx, "DELETE FROM profile_attributes WHERE name=$1", name)
+	if err != nil {
+		return 0, err
+	}
+	if ct.RowsAffected() == 0 {
+		return 0, pgx.ErrNoRows
+	}
+	ct, err = tx.Exec(ctx, "UPDATE users SET attributes = attributes - $1::text, updated_at=now() WHERE attributes ? $1", name)
+	return ct.RowsAffected(), err
+}
+
+// UserAttributes returns all of the user's attribute values, or pgx.ErrNoRows.
+func UserAttributes(ctx context.Context, db DBTX, userID string) (map[string]any, error) {
+	attrs := map[string]any{}
+	err := db.QueryRow(ctx, "SELECT attributes FROM users WHERE id=$1", userID).Scan(&attrs)
+	return attrs, err
+}
+
+// UserPreferences returns the user's saved preferences, or the defaults for
+// anything never saved.
+func UserPreferences(ctx context.Context, db DBTX, userID string) (models.Preferences, error) {
+	p := models.DefaultPreferences()
+	// Settings added after a row was saved keep their default
+	err := db.QueryRow(ctx, "SELECT locale, timezone, notifications, updated_at FROM user_preferences WHERE user_id=$1", userID).
+		Scan(&p.Locale, &p.Timezone, &p.Notifications, &p.UpdatedAt)
+	if err == pgx.ErrNoRows {
+		return models.DefaultPreferences(), nil
+	}
+	return p, err
+}
+
+// SetUserPreferences saves the user's preferences and returns them as stored.
+func SetUserPreferences(ctx context.Context, db DBTX, userID string, p models.Preferences) (models.Preferences, error) {
+	err := db.QueryRow(ctx, `INSERT INTO user_preferences (user_id, locale, timezone, notifications) VALUES ($1,$2,$3,$4)
+		ON CONFLICT (user_id) DO UPDATE SET locale=EXCLUDED.locale, timezone=EXCLUDED.timezone,
+			notifications=EXCLUDED.notifications, updated_at=now()
+		RETURNING updated_at`, userID, p.Locale, p.Timezone, p.Notifications).Scan(&p.UpdatedAt)
+	return p, err
+}
@@ -0,0 +1,24 @@
+package store
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var ErrRegistrationCodeInvalid = errors.New("registration code is invalid, expired or used up")
+
+// ConsumeRegistrationCode counts one use of the code identified by codeHash.
+func ConsumeRegistrationCode(ctx context.Context, db DBTX, codeHash string) error {
+	var id string
+	err := db.QueryRow(ctx, `UPDATE registration_codes SET uses = uses + 1
+		WHERE code_hash=$1 AND revoked_at IS NULL
+			AND (expires_at IS NULL OR expires_at > now())
+			AND (max_uses IS NULL OR uses < max_uses)
+		RETURNING id`, codeHash).Scan(&id)
+	if err == pgx.ErrNoRows {
+		return ErrRegistrationCodeInvalid
+	}
+	return err
+}
@@ -0,0 +1,149 @@
+package store
+
+import (
+	"context"
+	"errors"
+
+	"dev.mfr/go-chi-sqlc-auth/internal/models"
+)
+
+var ErrUnknownRole = errors.New("unknown role")
+
+// Principal loads the user's status, current roles and the union of their
+// permissions, plus their role in orgID. It returns pgx.ErrNoRows when the user does not exist or is deleted.
+func (s *Store) Principal(ctx context.Context, userID, orgID string) (*models.Principal, error) {
+	p := &models.Principal{UserID: userID}
+	var orgRole *models.OrgRole
+	err := s.Pool.QueryRow(ctx, `
+		SELECT
+			COALESCE(array_agg(DISTINCT r.name) FILTER (WHERE r.name IS NOT NULL), '{}'),
+			COALESCE(array_agg(DISTINCT rp.permission) FILTER (WHERE rp.permission IS NOT NULL), '{}'),
+			COALESCE(MAX(r.level), 0),
+			COALESCE((SELECT array_agg(s.email_domain ORDER BY s.email_domain) FROM user_admin_scopes s WHERE s.user_id = u.id), '{}'),
+			(SELECT m.org_role FROM memberships m WHERE m.user_id = u.id AND m.org_id = NULLIF($2, '')::uuid),
+			u.status
+		FROM users u
+		LEFT JOIN user_roles ur ON ur.user_id = u.id
+		LEFT JOIN roles r ON r.id = ur.role_id
+		LEFT JOIN role_permissions rp ON rp.role_id = r.id
+		WHERE u.id = $1 AND u.deleted_at IS NULL
+		GROUP BY u.id`, userID, orgID).Scan(&p.Roles, &p.Permissions, &p.Level, &p.Scopes, &orgRole, &p.Status)
+	if err != nil {
+		return nil, err
+	}
+	if orgRole != nil {
+		p.OrgID, p.OrgRole = orgID, *orgRole
+	}
+	return p, nil
+}
+
+// SetUserRoles replaces the user's role assignments. Pass a transaction when
+// the change must be atomic with other writes.
+func SetUserRoles(ctx context.Context, db DBTX, userID string, roles []models.Role) error {
+	names := make([]string, 0, len(roles))
+	seen := map[models.Role]struct{}{}
+	for _, r := range roles {
+		if _, ok := seen[r]; ok {
+			continue
+		}
+		seen[r] = struct{}{}
+		names = append(names, string(r))
+	}
+	if _, err := db.Exec(ctx, "DELETE FROM user_roles WHERE user_id=$1", userID); err != nil {
+		return err
+	}
+	ct, err := db.Exec(ctx, "INSERT INTO user_roles (user_id, role_id) SELECT $1, id FROM roles WHERE name = ANY($2)", userID, names)
+	if err != nil {
+		return err
+	}
+	if int(ct.RowsAffected()) != len(names) {
+		return ErrUnknownRole
+	}
+	return nil
+}
+
+// UserRoles returns the names of the roles assigned to a user.
+func UserRoles(ctx context.Context, db DBTX, userID string) ([]models.Role, error) {
+	var roles []models.Role
+	err := db.QueryRow(ctx, `
+		SELECT COALESCE(array_agg(r.name ORDER BY r.name), '{}')
+		FROM user_roles ur JOIN roles r ON r.id = ur.role_id
+		WHERE ur.user_id = $1`, userID).Scan(&roles)
+	return roles, err
+}
+
+// ManagedUser loads the hierarchy and scope attributes of a target user.
+// It returns pgx.ErrNoRows when the user does not exist or is deleted.
+func ManagedUser(ctx context.Context, db DBTX, userID string) (models.ManagedUser, error) {
+	return managedUser(ctx, db, userID, "u.deleted_at IS NULL")
+}
+
+// DeletedManagedUser is ManagedUser for soft-deleted users, used to restore
+// them. Erased accounts are left out; there is nothing left to restore.
+func DeletedManagedUser(ctx context.Context, db DBTX, userID string) (models.ManagedUser, error) {
+	return managedUser(ctx, db, userID, "u.deleted_at IS NOT NULL AND u.erased_at IS NULL")
+}
+
+func managedUser(ctx context.Context, db DBTX, userID, deleted string) (models.ManagedUser, error) {
+	u := models.ManagedUser{ID: userID}
+	err := db.QueryRow(ctx, `
+		SELECT lower(split_part(u.email, '@', 2)),
+			COALESCE(MAX(r.level), 0),
+			COALESCE(array_agg(r.name ORDER BY r.name) FILTER (WHERE r.name IS NOT NULL), '{}'),
+			COALESCE((SELECT array_agg(m.org_id::text) FROM memberships m WHERE m.user_id = u.id), '{}')
+		FROM users u
+		LEFT JOIN user_roles ur ON ur.user_id = u.id
+		LEFT JOIN roles r ON r.id = ur.role_id
+		WHERE u.id = $1 AND `+deleted+`
+		GROUP BY u.id`, userID).Scan(&u.EmailDomain, &u.Level, &u.Roles, &u.OrgIDs)
+	return u, err
+}
+
+// RoleLevels returns the level of each named role, or ErrUnknownRole if any is missing.
+func RoleLevels(ctx context.Context, db DBTX, roles []models.Role) (map[models.Role]int, error) {
+	names := make([]string, len(roles))
+	for i, r := range roles {
+		names[i] = string(r)
+	}
+	rows, err := db.Query(ctx, "SELECT name, level FROM roles WHERE name = ANY($1)", names)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	levels := map[models.Role]int{}
+	for rows.Next() {
+		var name models.Role
+		var level int
+		if err := rows.Scan(&name, &level); err != nil {
+			return nil, err
+		}
+		levels[name] = level
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, r := range roles {
+		if _, ok := levels[r]; !ok {
+			return nil, ErrUnknownRole
+		}
+	}
+	return levels, nil
+}
+
+// AdminScopes returns the email domains a delegated admin is limited to.
+func AdminScopes(ctx context.Context, db DBTX, userID string) ([]string, error) {
+	var domains []string
+	err := db.QueryRow(ctx, "SELECT COALESCE(array_agg(email_domain ORDER BY email_domain), '{}') FROM user_admin_scopes WHERE user_id=$1", userID).Scan(&domains)
+	return domains, err
+}
+
+// SetAdminScopes replaces a user's delegated scope; an empty list lifts the
+// restriction. Run it in a transaction: if the insert failed after the
+// delete, the user would be left unrestricted.
+func SetAdminScopes(ctx context.Context, db DBTX, userID string, domains []string) error {
+	if _, err := db.Exec(ctx, "DELETE FROM user_admin_scopes WHERE user_id=$1", userID); err != nil {
+		return err
+	}
+	_, err := db.Exec(ctx, "INSERT INTO user_admin_scopes (user_id, email_domain) SELECT $1, d FROM unnest($2::text[]) AS d", userID, domains)
+	return err
+}
@@ -0,0 +1,62 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"dev.mfr/go-chi-sqlc-auth/internal/models"
+)
+
+var (
+	ErrInvalidTransition = errors.New("status transition not allowed")
+	ErrStatusConflict    = errors.New("status was changed concurrently")
+)
+
+// TransitionStatus moves a user to status to if the state machine allows it,
+// recording reason and changedBy (empty for the system) in the status history.
+// It returns pgx.ErrNoRows when the user does not exist.
+func TransitionStatus(ctx context.Context, db DBTX, userID string, to models.UserStatus, reason, changedBy string) (models.UserStatus, error) {
+	var from models.UserStatus
+	if err := db.QueryRow(ctx, "SELECT status FROM users WHERE id=$1 AND deleted_at IS NULL", userID).Scan(&from); err != nil {
+		return "", err
+	}
+	if !from.CanTransitionTo(to) {
+		return from, fmt.Errorf("%w: %s to %s", ErrInvalidTransition, from, to)
+	}
+	// The status in the WHERE clause makes this a compare-and-swap
+	ct, err := db.Exec(ctx, `
+		WITH upd AS (
+			UPDATE users SET status=$3, status_reason=$4, status_changed_at=now(), status_changed_by=NULLIF($5, '')::uuid, updated_at=now()
+			WHERE id=$1 AND status=$2 AND deleted_at IS NULL
+			RETURNING id
+		)
+		INSERT INTO user_status_history (user_id, from_status, to_status, reason, changed_by)
+		SELECT id, $2, $3, $4, NULLIF($5, '')::uuid FROM upd`, userID, from, to, reason, changedBy)
+	if err != nil {
+		return from, err
+	}
+	if ct.RowsAffected() == 0 {
+		return from, ErrStatusConflict
+	}
+	return from, nil
+}
+
+// StatusHistory returns a user's status changes, oldest first.
+func StatusHistory(ctx context.Context, db DBTX, userID string) ([]models.StatusChange, error) {
+	rows, err := db.Query(ctx, `SELECT from_status, to_status, reason, changed_by, created_at
+		FROM user_status_history WHERE user_id=$1 ORDER BY created_at, id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []models.StatusChange{}
+	for rows.Next() {
+		var c models.StatusChange
+		if err := rows.Scan(&c.From, &c.To, &c.Reason, &c.ChangedBy, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
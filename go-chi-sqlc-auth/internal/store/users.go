@@ -1,9 +1,22 @@
 package store
 
 import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// DBTX is satisfied by both *pgxpool.Pool and pgx.Tx.
+type DBTX interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
 type Store struct {
 	Pool *pgxpool.Pool
 }
@@ -11,3 +24,55 @@ type Store struct {
 func New(pool *pgxpool.Pool) *Store {
 	return &Store{Pool: pool}
 }
+
+// PurgeDeletedUsers hard-deletes users soft-deleted before cutoff and returns
+// how many were removed, with the blob keys of their avatars for the caller
+// to delete.
+func PurgeDeletedUsers(ctx context.Context, db DBTX, cutoff time.Time) (int64, []string, error) {
+	rows, err := db.Query(ctx, "DELETE FROM users WHERE deleted_at IS NOT NULL AND deleted_at < $1 RETURNING avatar_keys", cutoff)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer rows.Close()
+	var n int64
+	var keys []string
+	for rows.Next() {
+		var k []string
+		if err := rows.Scan(&k); err != nil {
+			return 0, nil, err
+		}
+		n++
+		keys = append(keys, k...)
+	}
+	if err := rows.Err(); err != nil {
+		// The delete failed as a whole, so the blobs are still referenced
+		return 0, nil, err
+	}
+	return n, keys, nil
+}
+
+var ErrUsernameConfusable = errors.New("username looks too much like an existing one")
+
+// CheckUsername fails with ErrUsernameConfusable when another user's
+// username has the same skeleton (see internal/confusables) as the one user
+// id is about to take; pass "" as id for a new user. Usernames differing only
+// in case are left to the unique index, and a user keeping their skeleton
+// never fails, so lookalikes that predate the check stay usable. Run it in
+// the transaction that writes the username: it holds a lock on the skeleton
+// until commit, so two lookalikes can't be taken at once.
+func CheckUsername(ctx context.Context, tx pgx.Tx, id, username, skeleton string) error {
+	if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock(hashtext('username:' || $1))", skeleton); err != nil {
+		return err
+	}
+	var taken bool
+	err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE username_skeleton=$1
+		AND username_key <> lower(normalize($2, NFKC)) AND id::text <> $3)
+		AND NOT EXISTS (SELECT 1 FROM users WHERE id::text = $3 AND username_skeleton = $1)`, skeleton, username, id).Scan(&taken)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrUsernameConfusable
+	}
+	return nil
+}
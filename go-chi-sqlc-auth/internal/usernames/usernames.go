@@ -0,0 +1,107 @@
+// Package usernames decides which usernames may be chosen at all: reserved
+// names from configuration, such as admin or www, and the blocklist admins
+// keep in the database. Both ignore case and lookalike characters (see
+// internal/confusables), so ADMIN and аdmin with a Cyrillic а are reserved too.
+package usernames
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+
+	"dev.mfr/go-chi-sqlc-auth/internal/confusables"
+	"dev.mfr/go-chi-sqlc-auth/internal/store"
+	"golang.org/x/text/unicode/norm"
+)
+
+var (
+	ErrReserved = errors.New("username is reserved")
+	ErrBlocked  = errors.New("username is not allowed")
+)
+
+// Policy holds the reserved usernames; the blocklist is read on every check,
+// so changes apply at once.
+type Policy struct {
+	reserved []string
+	// by skeleton
+	skeletons map[string]bool
+}
+
+func NewPolicy(reserved []string) *Policy {
+	p := &Policy{reserved: reserved, skeletons: make(map[string]bool, len(reserved))}
+	for _, name := range reserved {
+		p.skeletons[confusables.Skeleton(name)] = true
+	}
+	return p
+}
+
+// Reserved returns the reserved usernames as configured.
+func (p *Policy) Reserved() []string {
+	return p.reserved
+}
+
+// Check fails with ErrReserved or ErrBlocked when username may not be chosen.
+func (p *Policy) Check(ctx context.Context, db store.DBTX, username string) error {
+	// Upper-case I reads as l, so ADMIN only matches once lower-cased
+	if p.skeletons[confusables.Skeleton(username)] || p.skeletons[confusables.Skeleton(strings.ToLower(username))] {
+		return ErrReserved
+	}
+	rows, err := db.Query(ctx, "SELECT pattern FROM username_blocklist")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var pattern string
+		if err := rows.Scan(&pattern); err != nil {
+			return err
+		}
+		if Matches(pattern, username) {
+			return ErrBlocked
+		}
+	}
+	return rows.Err()
+}
+
+// patternRe is what a blocklist pattern may contain: the characters of a
+// username plus the wildcards.
+var patternRe = regexp.MustCompile(`^[\pL\pM\pN_.*?-]{1,64}$`)
+
+// NormalizePattern trims and lower-cases a blocklist pattern and checks it.
+// '*' matches any run of characters, '?' exactly one.
+func NormalizePattern(pattern string) (string, error) {
+	pattern = strings.ToLower(norm.NFKC.String(strings.TrimSpace(pattern)))
+	if !patternRe.MatchString(pattern) {
+		return "", errors.New("pattern: must be 1-64 letters, digits, '.', '_', '-' or the wildcards '*' and '?'")
+	}
+	if strings.Trim(pattern, "*?") == "" {
+		return "", errors.New("pattern: would block every username")
+	}
+	return pattern, nil
+}
+
+// Matches reports whether username matches pattern, ignoring case, or does
+// once lookalike characters are replaced, like p0rn or ѕhit with a Cyrillic ѕ.
+func Matches(pattern, username string) bool {
+	key := strings.ToLower(norm.NFKC.String(username))
+	return compile(strings.ToLower(pattern)).MatchString(key) ||
+		compile(confusables.Fold(pattern)).MatchString(confusables.Fold(key))
+}
+
+func compile(pattern string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
@@ -0,0 +1,134 @@
+package validate
+
+import (
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"dev.mfr/go-chi-sqlc-auth/internal/models"
+	"golang.org/x/text/language"
+)
+
+var attributeNameRe = regexp.MustCompile(`^[a-z][a-z0-9_]{0,62}$`)
+
+const (
+	maxLabelLen          = 100
+	maxDescriptionLen    = 500
+	maxAttributeValueLen = 1000
+	maxOptions           = 100
+)
+
+// ProfileAttribute checks an attribute definition.
+func ProfileAttribute(a models.ProfileAttribute) error {
+	if !attributeNameRe.MatchString(a.Name) {
+		return fieldErr("name", "must be 1-63 lowercase letters, digits or '_' and start with a letter")
+	}
+	if err := name("label", a.Label); err != nil {
+		return err
+	}
+	if utf8.RuneCountInString(a.Description) > maxDescriptionLen {
+		return fieldErr("description", "must be at most %d characters", maxDescriptionLen)
+	}
+	switch a.Type {
+	case models.AttrString, models.AttrNumber, models.AttrBoolean, models.AttrDate, models.AttrEnum:
+	default:
+		return fieldErr("type", "must be string, number, boolean, date or enum")
+	}
+	if a.Pattern != "" {
+		if a.Type != models.AttrString {
+			return fieldErr("pattern", "only applies to string attributes")
+		}
+		if _, err := attributePattern(a.Pattern); err != nil {
+			return fieldErr("pattern", "invalid regular expression: %v", err)
+		}
+	}
+	if a.Type == models.AttrEnum {
+		if len(a.Options) == 0 || len(a.Options) > maxOptions {
+			return fieldErr("options", "enum attributes need 1-%d options", maxOptions)
+		}
+		seen := map[string]bool{}
+		for _, o := range a.Options {
+			if strings.TrimSpace(o) == "" || seen[o] {
+				return fieldErr("options", "must be non-empty and unique")
+			}
+			seen[o] = true
+		}
+	} else if len(a.Options) > 0 {
+		return fieldErr("options", "only apply to enum attributes")
+	}
+	switch a.Visibility {
+	case models.VisibilityPublic, models.VisibilityPrivate, models.VisibilityAdmin:
+	default:
+		return fieldErr("visibility", "must be public, private or admin")
+	}
+	return nil
+}
+
+// AttributeValue checks a value decoded from JSON against its definition.
+func AttributeValue(a models.ProfileAttribute, v any) error {
+	field := "attributes." + a.Name
+	switch a.Type {
+	case models.AttrString:
+		s, ok := v.(string)
+		if !ok {
+			return fieldErr(field, "must be a string")
+		}
+		if utf8.RuneCountInString(s) > maxAttributeValueLen {
+			return fieldErr(field, "must be at most %d characters", maxAttributeValueLen)
+		}
+		if a.Pattern != "" {
+			re, err := attributePattern(a.Pattern)
+			if err != nil || !re.MatchString(s) {
+				return fieldErr(field, "must match %s", a.Pattern)
+			}
+		}
+	case models.AttrNumber:
+		if _, ok := v.(float64); !ok {
+			return fieldErr(field, "must be a number")
+		}
+	case models.AttrBoolean:
+		if _, ok := v.(bool); !ok {
+			return fieldErr(field, "must be true or false")
+		}
+	case models.AttrDate:
+		s, ok := v.(string)
+		if _, err := time.Parse(time.DateOnly, s); !ok || err != nil {
+			return fieldErr(field, "must be a date as YYYY-MM-DD")
+		}
+	case models.AttrEnum:
+		s, _ := v.(string)
+		for _, o := range a.Options {
+			if s == o {
+				return nil
+			}
+		}
+		return fieldErr(field, "must be one of %s", strings.Join(a.Options, ", "))
+	}
+	return nil
+}
+
+// attributePattern compiles a pattern so that it must match the whole value.
+// Go regexps run in linear time, so admin-supplied patterns can't hang a request.
+func attributePattern(p string) (*regexp.Regexp, error) {
+	return regexp.Compile(`^(?:` + p + `)$`)
+}
+
+// Preferences checks a user's locale, timezone and notification settings.
+func Preferences(p models.Preferences) error {
+	if _, err := language.Parse(p.Locale); err != nil || p.Locale == "" {
+		return fieldErr("locale", "must be a BCP 47 language tag such as en or de-AT")
+	}
+	if p.Timezone == "" || p.Timezone == "Local" {
+		return fieldErr("timezone", "must be an IANA time zone such as Europe/Berlin")
+	}
+	if _, err := time.LoadLocation(p.Timezone); err != nil {
+		return fieldErr("timezone", "must be an IANA time zone such as Europe/Berlin")
+	}
+	switch p.Notifications.Digest {
+	case models.DigestOff, models.DigestDaily, models.DigestWeekly:
+	default:
+		return fieldErr("notifications.digest", "must be off, daily or weekly")
+	}
+	return nil
+}
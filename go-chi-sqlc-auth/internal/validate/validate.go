@@ -0,0 +1,207 @@
+package validate
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"dev.mfr/go-chi-sqlc-auth/internal/address"
+	"dev.mfr/go-chi-sqlc-auth/internal/confusables"
+	"dev.mfr/go-chi-sqlc-auth/internal/models"
+	"dev.mfr/go-chi-sqlc-auth/internal/phone"
+	"golang.org/x/text/unicode/norm"
+)
+
+// FieldError reports the first invalid field of a request.
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+func (e *FieldError) Error() string { return e.Field + ": " + e.Message }
+
+func fieldErr(field, format string, args ...any) error {
+	return &FieldError{Field: field, Message: fmt.Sprintf(format, args...)}
+}
+
+var usernameRe = regexp.MustCompile(`^[\pL\pN][\pL\pM\pN_.-]{2,31}$`)
+
+const (
+	maxNameLen    = 100
+	maxEmailLen   = 254
+	maxPhoneLen   = 32
+	maxAddressLen = 100 // per line or part
+	maxAddrLines  = 3
+	maxPostalLen  = 20
+	minPassword   = 8
+	maxPassword   = 72 // bcrypt ignores anything longer
+)
+
+// UserFields are the profile fields shared by registration, admin edits and patches.
+type UserFields struct {
+	Username  string
+	Email     string
+	FirstName string
+	LastName  string
+	Phone     *models.Phone
+	Address   *models.Address
+}
+
+// User checks the profile fields and returns a *FieldError for the first
+// invalid one. Phone and Address are normalized in place; a national phone
+// number is read in its given region or else the address's country.
+func User(u UserFields) error {
+	if err := Username(u.Username); err != nil {
+		return err
+	}
+	if err := Email(u.Email); err != nil {
+		return err
+	}
+	if err := name("first_name", u.FirstName); err != nil {
+		return err
+	}
+	if err := name("last_name", u.LastName); err != nil {
+		return err
+	}
+	if u.Address != nil {
+		if err := Address(u.Address); err != nil {
+			return err
+		}
+	}
+	if u.Phone != nil {
+		hint := u.Phone.Region
+		if hint == "" && u.Address != nil {
+			hint = u.Address.Country
+		}
+		if err := Phone(u.Phone, hint); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Phone normalizes p.Number to E.164 and sets p.Region to the number's
+// region, reading national numbers in region.
+func Phone(p *models.Phone, region string) error {
+	if strings.TrimSpace(p.Number) == "" {
+		return fieldErr("phone.number", "required")
+	}
+	if utf8.RuneCountInString(p.Number) > maxPhoneLen {
+		return fieldErr("phone.number", "must be at most %d characters", maxPhoneLen)
+	}
+	if region != "" {
+		if _, ok := address.Lookup(strings.ToUpper(region)); !ok {
+			return fieldErr("phone.region", "must be an ISO 3166 country code such as DE")
+		}
+	}
+	n, r, err := phone.Normalize(p.Number, region)
+	switch {
+	case errors.Is(err, phone.ErrNoRegion):
+		return fieldErr("phone.number", "must start with + and the country code, or give phone.region")
+	case err != nil:
+		return fieldErr("phone.number", "not a valid phone number")
+	}
+	p.Number, p.Region = n, r
+	return nil
+}
+
+// Address trims a, upper-cases its country and postal code and checks them
+// against the country's rules.
+func Address(a *models.Address) error {
+	var lines []string
+	for _, l := range a.Lines {
+		if l = strings.TrimSpace(l); l != "" {
+			lines = append(lines, l)
+		}
+	}
+	a.Lines = lines
+	a.City, a.Region = strings.TrimSpace(a.City), strings.TrimSpace(a.Region)
+	a.PostalCode = address.NormalizePostalCode(a.PostalCode)
+	a.Country = strings.ToUpper(strings.TrimSpace(a.Country))
+	if len(a.Lines) == 0 || len(a.Lines) > maxAddrLines {
+		return fieldErr("address.lines", "must have 1-%d lines", maxAddrLines)
+	}
+	for _, l := range a.Lines {
+		if utf8.RuneCountInString(l) > maxAddressLen {
+			return fieldErr("address.lines", "must be at most %d characters each", maxAddressLen)
+		}
+	}
+	if err := name("address.city", a.City); err != nil {
+		return err
+	}
+	if utf8.RuneCountInString(a.Region) > maxAddressLen {
+		return fieldErr("address.region", "must be at most %d characters", maxAddressLen)
+	}
+	c, ok := address.Lookup(a.Country)
+	if !ok {
+		return fieldErr("address.country", "must be an ISO 3166 country code such as DE")
+	}
+	if len(a.PostalCode) > maxPostalLen {
+		return fieldErr("address.postal_code", "must be at most %d characters", maxPostalLen)
+	}
+	if a.PostalCode != "" && !c.ValidPostalCode(a.PostalCode) {
+		return fieldErr("address.postal_code", "is not a valid postal code for %s", c.Name)
+	}
+	return nil
+}
+
+// Username checks a username as returned by NormalizeUsername.
+func Username(s string) error {
+	if !usernameRe.MatchString(s) {
+		return fieldErr("username", "must be 3-32 letters, digits, '.', '_' or '-' and start with a letter or digit")
+	}
+	if confusables.MixedScript(s) {
+		return fieldErr("username", "must not mix letters from different scripts")
+	}
+	return nil
+}
+
+// NormalizeUsername returns s trimmed and in Unicode NFKC, the form usernames
+// are stored in, so that e.g. full-width letters become ASCII ones.
+func NormalizeUsername(s string) string {
+	return norm.NFKC.String(strings.TrimSpace(s))
+}
+
+// NormalizeEmail returns s trimmed and in NFKC with the domain lower-cased.
+// The local part keeps its case; uniqueness and login ignore it anyway.
+func NormalizeEmail(s string) string {
+	s = norm.NFKC.String(strings.TrimSpace(s))
+	if at := strings.LastIndex(s, "@"); at >= 0 {
+		s = s[:at+1] + strings.ToLower(s[at+1:])
+	}
+	return s
+}
+
+func Email(s string) error {
+	if s == "" {
+		return fieldErr("email", "required")
+	}
+	a, err := mail.ParseAddress(s)
+	if err != nil || a.Address != s || len(s) > maxEmailLen || !strings.Contains(s[strings.LastIndex(s, "@")+1:], ".") {
+		return fieldErr("email", "must be a valid email address")
+	}
+	return nil
+}
+
+func Password(s string) error {
+	if len(s) < minPassword {
+		return fieldErr("password", "must be at least %d characters", minPassword)
+	}
+	if len(s) > maxPassword {
+		return fieldErr("password", "must be at most %d bytes", maxPassword)
+	}
+	return nil
+}
+
+func name(field, s string) error {
+	if strings.TrimSpace(s) == "" {
+		return fieldErr(field, "required")
+	}
+	if utf8.RuneCountInString(s) > maxNameLen {
+		return fieldErr(field, "must be at most %d characters", maxNameLen)
+	}
+	return nil
+}
@@ -6,13 +6,25 @@ import (
 	"log"
 	"net/http"
 	"time"
+	// timezone preferences are checked against the IANA database, which the host may lack
+	_ "time/tzdata"
 
+	"dev.mfr/go-chi-sqlc-auth/internal/audit"
 	"dev.mfr/go-chi-sqlc-auth/internal/auth"
+	"dev.mfr/go-chi-sqlc-auth/internal/authz"
 	"dev.mfr/go-chi-sqlc-auth/internal/config"
+	"dev.mfr/go-chi-sqlc-auth/internal/confusables"
 	"dev.mfr/go-chi-sqlc-auth/internal/database"
+	"dev.mfr/go-chi-sqlc-auth/internal/export"
 	"dev.mfr/go-chi-sqlc-auth/internal/handlers"
+	"dev.mfr/go-chi-sqlc-auth/internal/importer"
+	"dev.mfr/go-chi-sqlc-auth/internal/jobs"
+	"dev.mfr/go-chi-sqlc-auth/internal/mailer"
 	mw "dev.mfr/go-chi-sqlc-auth/internal/middleware"
 	"dev.mfr/go-chi-sqlc-auth/internal/models"
+	"dev.mfr/go-chi-sqlc-auth/internal/storage"
+	"dev.mfr/go-chi-sqlc-auth/internal/store"
+	"dev.mfr/go-chi-sqlc-auth/internal/usernames"
 	"github.com/go-chi/chi/v5"
 	middleware2 "github.com/go-chi/chi/v5/middleware"
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -32,28 +44,84 @@ func main() {
 
 	issuer := auth.JWTIssuer{Secret: []byte(cfg.JWT.Secret), Expires: time.Duration(cfg.JWT.ExpiresInHours) * time.Hour}
 
+	policy, err := authz.LoadPolicy(cfg.Authz.PolicyFile)
+	if err != nil {
+		log.Fatalf("authz: %v", err)
+	}
+	az := authz.New(policy, cfg.Authz.LogDecisions)
+
+	mail, err := mailer.New(cfg.Mail)
+	if err != nil {
+		log.Fatalf("mail: %v", err)
+	}
+
 	// Seed admin and demo user if not exists
 	if err := seedUsers(pool); err != nil {
 		log.Printf("seed warning: %v", err)
 	}
 
+	al := audit.New([]byte(cfg.Audit.SigningKey))
+
+	blobs, err := storage.New(cfg.Storage, cfg.BaseURL)
+	if err != nil {
+		log.Fatalf("storage: %v", err)
+	}
+
+	// Erase accounts whose deletion grace period ended, and hard-delete
+	// soft-deleted users once their retention period is over
+	purgeEvery := time.Duration(cfg.Retention.PurgeIntervalMinutes) * time.Minute
+	go jobs.NewEraser(pool, al, blobs, purgeEvery).Run(context.Background())
+	go jobs.NewPurger(pool, blobs, time.Duration(cfg.Retention.DeletedUserDays)*24*time.Hour, purgeEvery).Run(context.Background())
+	go jobs.MigrateLegacyPhones(context.Background(), pool, cfg.Users.LegacyPhoneRegion)
+
 	r := chi.NewRouter()
 	r.Use(middleware2.Logger)
 	r.Use(middleware2.Recoverer)
+	r.Use(authz.CaptureEnvironment)
 
 	r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		_, _ = w.Write([]byte("ok"))
 	})
 
-	authH := handlers.NewAuthHandler(pool, issuer)
+	reauthMaxAge := time.Duration(cfg.JWT.ReauthMaxAgeMinutes) * time.Minute
+	names := usernames.NewPolicy(cfg.Users.ReservedUsernames)
+	authH := handlers.NewAuthHandler(pool, issuer, cfg.Reg, al, mail, cfg.AppURL, reauthMaxAge, time.Duration(cfg.Retention.DeletionGraceDays)*24*time.Hour,
+		time.Duration(cfg.Users.EmailVerificationHours)*time.Hour, names)
 	r.Mount("/auth", authH.Routes())
 
-	usersH := handlers.NewUsersHandler(pool)
-	// protect users routes
+	imports := importer.New(pool, az, al, names, time.Duration(cfg.Users.ImportRetentionHours)*time.Hour)
+	usersH := handlers.NewUsersHandler(pool, az, al, mail, imports, blobs, names, cfg.AppURL, reauthMaxAge, cfg.Users)
+	rolesH := handlers.NewRolesHandler(pool, az)
+	authzH := handlers.NewAuthzHandler(pool, az)
+	orgsH := handlers.NewOrgsHandler(pool, az, mail, cfg.AppURL, time.Duration(cfg.Orgs.InviteExpiresInHours)*time.Hour)
+	registrationsH := handlers.NewRegistrationsHandler(pool, az, mail)
+	usernamesH := handlers.NewUsernamesHandler(pool, az, names)
+	profileH := handlers.NewProfileHandler(pool, al)
+	exports := export.New(pool, []byte(cfg.JWT.Secret), cfg.BaseURL,
+		time.Duration(cfg.Exports.URLTTLMinutes)*time.Minute, time.Duration(cfg.Exports.RetentionHours)*time.Hour)
+	exportsH := handlers.NewExportsHandler(pool, az, exports, al)
+	// signed download URLs work without a token
+	r.Mount("/exports", exportsH.Routes())
+	// locally stored avatars are public, like a bucket behind a CDN
+	if local, ok := blobs.(*storage.LocalStore); ok {
+		r.Handle("/media/*", http.StripPrefix("/media", local))
+	}
+	// protect everything except health and the public auth routes
 	r.Group(func(pr chi.Router) {
-		pr.Use(mw.JWT(issuer))
+		pr.Use(mw.JWT(issuer, store.New(pool)))
+		pr.Post("/auth/me/export", exportsH.RequestMine)
+		pr.Get("/auth/me/exports/{exportID}", exportsH.GetMine)
 		pr.Mount("/users", usersH.Routes())
+		pr.Post("/users/{id}/export", exportsH.RequestForUser)
+		pr.Get("/users/{id}/exports/{exportID}", exportsH.GetForUser)
+		pr.Mount("/roles", rolesH.Routes())
+		pr.Mount("/orgs", orgsH.Routes())
+		pr.Post("/invitations/accept", orgsH.AcceptInvitation)
+		pr.Mount("/admin/registrations", registrationsH.Routes())
+		pr.Mount("/admin/usernames", usernamesH.Routes())
+		pr.Mount("/profile", profileH.Routes())
+		pr.Mount("/authz", authzH.Routes())
 	})
 
 	addr := fmt.Sprintf(":%d", cfg.Port)
@@ -63,30 +131,31 @@ func main() {
 
 func seedUsers(pool *pgxpool.Pool) error {
 	ctx := context.Background()
-	// admin
-	var count int
-	if err := pool.QueryRow(ctx, "SELECT COUNT(1) FROM users WHERE email=$1", "admin@example.com").Scan(&count); err != nil {
-		return err
+	seeds := []struct {
+		username, email, password, firstName string
+		role                                 models.Role
+	}{
+		{"admin", "admin@example.com", "AdminPass123!", "Admin", models.RoleAdmin},
+		{"demo", "demo@example.com", "DemoPass123!", "Demo", models.RoleUser},
 	}
-	if count == 0 {
-		pw, _ := auth.HashPassword("AdminPass123!")
-		_, err := pool.Exec(ctx, `INSERT INTO users (username, email, password_hash, first_name, last_name, role) VALUES ($1,$2,$3,$4,$5,$6)`,
-			"admin", "admin@example.com", pw, "Admin", "User", models.RoleAdmin)
-		if err != nil {
+	for _, s := range seeds {
+		var count int
+		if err := pool.QueryRow(ctx, "SELECT COUNT(1) FROM users WHERE email_key=lower($1)", s.email).Scan(&count); err != nil {
 			return err
 		}
-	}
-	// demo
-	if err := pool.QueryRow(ctx, "SELECT COUNT(1) FROM users WHERE email=$1", "demo@example.com").Scan(&count); err != nil {
-		return err
-	}
-	if count == 0 {
-		pw, _ := auth.HashPassword("DemoPass123!")
-		_, err := pool.Exec(ctx, `INSERT INTO users (username, email, password_hash, first_name, last_name, role) VALUES ($1,$2,$3,$4,$5,$6)`,
-			"demo", "demo@example.com", pw, "Demo", "User", models.RoleUser)
+		if count > 0 {
+			continue
+		}
+		pw, _ := auth.HashPassword(s.password)
+		var id string
+		err := pool.QueryRow(ctx, `INSERT INTO users (username, username_skeleton, email, password_hash, first_name, last_name) VALUES ($1,$2,$3,$4,$5,$6) RETURNING id`,
+			s.username, confusables.Skeleton(s.username), s.email, pw, s.firstName, "User").Scan(&id)
 		if err != nil {
 			return err
 		}
+		if err := store.SetUserRoles(ctx, pool, id, []models.Role{s.role}); err != nil {
+			return err
+		}
 	}
 	return nil
 }